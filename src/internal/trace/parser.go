@@ -967,7 +967,9 @@ const (
 	EvGoBlockGC         = 42 // goroutine blocks on GC assist [timestamp, stack]
 	EvGCMarkAssistStart = 43 // GC mark assist start [timestamp, stack]
 	EvGCMarkAssistDone  = 44 // GC mark assist done [timestamp]
-	EvCount             = 45
+	EvGoRunqSpill       = 45 // local run queue spilled to global queue [timestamp, P id, number of goroutines moved]
+	EvGoRunnextSteal    = 46 // goroutine in another P's runnext slot stolen [timestamp, victim P id, thief P id, goroutine id]
+	EvCount             = 47
 )
 
 var EventDescriptions = [EvCount]struct {
@@ -1021,4 +1023,6 @@ var EventDescriptions = [EvCount]struct {
 	EvGoBlockGC:         {"GoBlockGC", 1008, true, []string{}},
 	EvGCMarkAssistStart: {"GCMarkAssistStart", 1009, true, []string{}},
 	EvGCMarkAssistDone:  {"GCMarkAssistDone", 1009, false, []string{}},
+	EvGoRunqSpill:       {"GoRunqSpill", 1010, false, []string{"p", "n"}},
+	EvGoRunnextSteal:    {"GoRunnextSteal", 1010, false, []string{"victim", "thief", "g"}},
 }
@@ -266,6 +266,7 @@ func chansend(c *hchan, ep unsafe.Pointer, block bool, callerpc uintptr) bool {
 	mysg.waitlink = nil
 	mysg.g = gp
 	mysg.isSelect = false
+	mysg.isSend = true
 	mysg.c = c
 	gp.waiting = mysg
 	gp.param = nil
@@ -562,6 +563,7 @@ func chanrecv(c *hchan, ep unsafe.Pointer, block bool) (selected, received bool)
 	gp.waiting = mysg
 	mysg.g = gp
 	mysg.isSelect = false
+	mysg.isSend = false
 	mysg.c = c
 	gp.param = nil
 	c.recvq.enqueue(mysg)
@@ -788,6 +790,13 @@ func (q *waitq) dequeue() *sudog {
 	}
 }
 
+// queued reports whether sgp, which was enqueued on q, is still there.
+// dequeue and dequeueSudoG clear the links of the sudogs they remove.
+// The caller must hold the channel lock or have stopped the world.
+func (q *waitq) queued(sgp *sudog) bool {
+	return q.first == sgp || sgp.prev != nil || sgp.next != nil
+}
+
 func racesync(c *hchan, sg *sudog) {
 	racerelease(chanbuf(c, 0))
 	raceacquireg(sg.g, chanbuf(c, 0))
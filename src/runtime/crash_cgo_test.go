@@ -413,6 +413,19 @@ func TestCgoNumGoroutine(t *testing.T) {
 	}
 }
 
+func TestCgoCallsInFlight(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "plan9":
+		t.Skipf("skipping cgo calls in flight test on %s", runtime.GOOS)
+	}
+	t.Parallel()
+	got := runTestProg(t, "testprogcgo", "CgoCallsInFlight")
+	want := "OK\n"
+	if got != want {
+		t.Errorf("expected %q got %v", want, got)
+	}
+}
+
 func TestCatchPanic(t *testing.T) {
 	t.Parallel()
 	switch runtime.GOOS {
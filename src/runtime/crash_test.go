@@ -203,6 +203,19 @@ func TestSimpleDeadlock(t *testing.T) {
 	testDeadlock(t, "SimpleDeadlock")
 }
 
+func TestDeadlockDetail(t *testing.T) {
+	output := runTestProg(t, "testprog", "SimpleDeadlock", "GODEBUG=deadlockdetail=1")
+	for _, want := range []string{
+		"runtime: blocked goroutines:\n",
+		"goroutine 1 [waiting]: select (no cases)\n",
+		"fatal error: all goroutines are asleep - deadlock!\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("output does not contain %q:\n%s", want, output)
+		}
+	}
+}
+
 func TestInitDeadlock(t *testing.T) {
 	testDeadlock(t, "InitDeadlock")
 }
@@ -231,6 +244,14 @@ func TestStackOverflow(t *testing.T) {
 	}
 }
 
+func TestStackOverflowMaxStackMB(t *testing.T) {
+	output := runTestProg(t, "testprog", "StackOverflowDefaultLimit", "GODEBUG=maxstackmb=16")
+	want := "runtime: goroutine stack exceeds 16000000-byte limit\nfatal error: stack overflow"
+	if !strings.HasPrefix(output, want) {
+		t.Fatalf("output does not start with %q:\n%s", want, output)
+	}
+}
+
 func TestThreadExhaustion(t *testing.T) {
 	output := runTestProg(t, "testprog", "ThreadExhaustion")
 	want := "runtime: program exceeds 10-thread limit\nfatal error: thread exhaustion"
@@ -251,6 +272,14 @@ panic: again
 
 }
 
+func TestFinalPanicHook(t *testing.T) {
+	output := runTestProg(t, "testprog", "FinalPanicHook")
+	want := "main done\nhook\n"
+	if output != want {
+		t.Fatalf("want %q, got %q", want, output)
+	}
+}
+
 func TestGoexitCrash(t *testing.T) {
 	output := runTestProg(t, "testprog", "GoexitExit")
 	want := "no goroutines (main called runtime.Goexit) - deadlock!"
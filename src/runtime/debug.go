@@ -22,7 +22,13 @@ func GOMAXPROCS(n int) int {
 		return ret
 	}
 
-	stopTheWorld("GOMAXPROCS")
+	// Allocate any new Ps before stopping the world so that
+	// growing GOMAXPROCS only pauses to publish them.
+	ps, mcaches := preparePs(int32(n))
+	semacquire(&worldsema)
+	preparedPs.ps, preparedPs.mcaches = ps, mcaches
+	getg().m.preemptoff = "GOMAXPROCS"
+	systemstack(stopTheWorldWithSema)
 
 	// newprocs will be processed by startTheWorld
 	newprocs = int32(n)
@@ -31,6 +37,81 @@ func GOMAXPROCS(n int) int {
 	return ret
 }
 
+// TryStopTheWorld reports whether every goroutine can be brought to a
+// safe point within timeout nanoseconds. It stops the world as the
+// garbage collector does and restarts it at once. If some goroutine
+// does not stop in time, for example because it is running a loop
+// without function calls, the stop is abandoned and the goroutines
+// that did stop resume. A watchdog can use this to detect goroutines
+// that would otherwise delay every garbage collection.
+//
+// The timeout is checked every 100 microseconds while waiting, so a
+// timeout of 0 still waits that long.
+func TryStopTheWorld(timeout int64) bool {
+	if timeout < 0 {
+		timeout = 0
+	}
+	semacquire(&worldsema)
+	getg().m.preemptoff = "TryStopTheWorld"
+	var stopped bool
+	systemstack(func() {
+		stopped = stopTheWorldTimeout(timeout)
+	})
+	if !stopped {
+		semrelease(&worldsema)
+		getg().m.preemptoff = ""
+		return false
+	}
+	startTheWorld()
+	return true
+}
+
+// ForEachP calls fn once for each P, the processors that execute Go
+// code, with the P's id, which ranges over [0, GOMAXPROCS). fn runs
+// the next time the P enters the scheduler, for example because its
+// goroutine was preempted, blocked, or entered a system call, on the
+// thread that holds the P; if the P is idle or in a system call, fn
+// may instead run in the caller. No goroutine runs on that P while fn
+// runs. This can be used to flush per-P state kept by code that pins
+// itself to a P. ForEachP returns once fn has run for every P.
+//
+// ForEachP is expensive: it preempts every running goroutine, and it
+// does not run at the same time as anything else that stops the world,
+// so it may have to wait for that to finish first. fn runs on a small
+// system stack, possibly while the scheduler is locked, so it must be
+// short and must not block, allocate, or call ForEachP.
+//
+// The race detector cannot follow code that runs outside a goroutine,
+// so when it is enabled ForEachP instead stops the world and calls fn
+// for every P from the calling goroutine.
+func ForEachP(fn func(pid int)) {
+	if raceenabled {
+		stopTheWorld("ForEachP")
+		for pid := range allp {
+			fn(pid)
+		}
+		startTheWorld()
+		return
+	}
+	semacquire(&worldsema)
+	getg().m.preemptoff = "ForEachP"
+	forEachPFn = fn
+	systemstack(func() {
+		forEachP(forEachPCall)
+	})
+	forEachPFn = nil
+	getg().m.preemptoff = ""
+	semrelease(&worldsema)
+}
+
+// forEachPFn is the function passed to ForEachP. Protected by
+// worldsema.
+var forEachPFn func(pid int)
+
+func forEachPCall(pp *p) {
+	forEachPFn(int(pp.id))
+}
+
 // NumCPU returns the number of logical CPUs usable by the current process.
 //
 // The set of available CPUs is checked by querying the operating system
@@ -49,7 +130,694 @@ func NumCgoCall() int64 {
 	return n
 }
 
+// CgoCallsInFlight returns the number of operating system threads
+// that are currently executing a cgo call, not counting threads that
+// have called back into Go. Each such call keeps its thread busy, so
+// a high count explains thread growth. The value is a snapshot and
+// may change immediately.
+func CgoCallsInFlight() int32 {
+	var n int32
+	lock(&sched.lock)
+	for mp := allm; mp != nil; mp = mp.alllink {
+		if mp.incgo {
+			n++
+		}
+	}
+	unlock(&sched.lock)
+	return n
+}
+
 // NumGoroutine returns the number of goroutines that currently exist.
 func NumGoroutine() int {
 	return int(gcount())
 }
+
+// UserGoroutineCount returns the number of goroutines that currently
+// exist, not counting those the runtime itself uses. Unlike
+// NumGoroutine, which derives its result from counters, it walks the
+// list of all goroutines and so is exact at the cost of being slower;
+// it is meant for occasional use, such as by monitoring.
+func UserGoroutineCount() int {
+	n := 0
+	lock(&allglock)
+	for _, gp := range allgs {
+		if readgstatus(gp)&^_Gscan != _Gdead && !isSystemGoroutine(gp) {
+			n++
+		}
+	}
+	unlock(&allglock)
+	return n
+}
+
+// NumSpinningM returns the number of OS threads that are currently
+// spinning, looking for runnable goroutines to execute.
+// The value is an instantaneous snapshot and may change immediately.
+func NumSpinningM() int {
+	return int(int32(atomic.Load(&sched.nmspinning)))
+}
+
+// NumIdleM returns the number of OS threads that are currently parked
+// waiting for work.
+// The value is an instantaneous snapshot and may change immediately.
+func NumIdleM() int {
+	lock(&sched.lock)
+	n := sched.nmidle
+	unlock(&sched.lock)
+	return int(n)
+}
+
+// PeakThreadCount returns the largest number of OS threads the
+// program has had at any one time. Unlike the current thread count,
+// it catches short-lived spikes, such as from many goroutines blocking
+// in system calls at once, which helps choose a value for
+// debug.SetMaxThreads.
+func PeakThreadCount() int32 {
+	lock(&sched.lock)
+	n := peakmcount
+	unlock(&sched.lock)
+	return n
+}
+
+// GlobalRunQueueSize returns the number of goroutines in the global
+// run queue. Goroutines land there when a P's local run queue
+// overflows or when they are not associated with any P; a queue that
+// stays non-empty suggests Ps are not draining it fast enough.
+// The value is an instantaneous snapshot and may change immediately.
+func GlobalRunQueueSize() int {
+	lock(&sched.lock)
+	n := sched.runqsize
+	unlock(&sched.lock)
+	return int(n)
+}
+
+// NetpollWaiterCount returns the number of waits currently registered
+// with the network poller, which is roughly the number of goroutines
+// blocked reading from or writing to network connections and other
+// pollable files such as pipes. Set against the run queue sizes it helps
+// tell whether a program is waiting for I/O or for CPU. It counts wait
+// registrations rather than goroutines: a goroutine is counted while
+// it waits on a descriptor, and stops being counted as soon as the
+// poller makes it ready, before it has actually run. Goroutines
+// blocked in other ways, such as on a channel or in a system call,
+// are not counted. It is always 0 on systems without a network poller.
+func NetpollWaiterCount() int32 {
+	return int32(atomic.Load(&netpollWaiters))
+}
+
+// CountRunnableGoroutines returns an estimate of the number of
+// goroutines that are running or ready to run: those in the global
+// and per-P run queues plus one for each P that is executing code.
+// Dividing it by GOMAXPROCS gives a rough measure of load.
+// The result is a lower bound, since the goroutine each P will run
+// next (which bypasses its run queue) is not counted.
+// The value is an instantaneous snapshot and may change immediately.
+func CountRunnableGoroutines() int {
+	lock(&sched.lock)
+	n := sched.runqsize
+	for _, pp := range allp {
+		h := atomic.Load(&pp.runqhead)
+		t := atomic.Load(&pp.runqtail)
+		if d := int32(t - h); d > 0 {
+			n += d
+		}
+		n += int32(atomic.Load(&pp.pinqsize))
+		if atomic.Load(&pp.status) == _Prunning {
+			n++
+		}
+	}
+	unlock(&sched.lock)
+	return int(n)
+}
+
+// LastSTWDuration returns how long, in nanoseconds, all goroutines
+// were stopped the last time the runtime stopped the world, for
+// example for garbage collection. It measures the time from the
+// moment every processor had stopped until they were allowed to
+// resume, so it does not include the time taken to stop them.
+// It returns 0 if the world has never been stopped.
+func LastSTWDuration() int64 {
+	return int64(atomic.Load64(&lastSTWDuration))
+}
+
+// PStat holds scheduling statistics for a single P, as reported by
+// ReadPStats.
+type PStat struct {
+	// ID is the index of the P, in the range [0, GOMAXPROCS).
+	ID int
+
+	// Status is the P's state: 0 idle, 1 running, 2 in a
+	// system call, 3 stopped for GC, 4 dead.
+	Status uint32
+
+	// SchedTick is incremented on every scheduler call on this P.
+	SchedTick uint32
+
+	// SyscallTick is incremented on every system call on this P.
+	SyscallTick uint32
+
+	// RunqSize is the number of goroutines in the P's local run
+	// queue, not counting runnext.
+	RunqSize int
+
+	// GFreeCount is the number of dead goroutines cached on the P
+	// for reuse.
+	GFreeCount int
+}
+
+// ReadPStats fills dst with one PStat per P and returns the number of
+// entries written. If dst is too small, the result is truncated.
+// The statistics are read without stopping the world, so they are a
+// loosely consistent snapshot.
+func ReadPStats(dst []PStat) int {
+	lock(&allpLock)
+	n := 0
+	for _, pp := range allp {
+		if n >= len(dst) {
+			break
+		}
+		h := atomic.Load(&pp.runqhead)
+		t := atomic.Load(&pp.runqtail)
+		dst[n] = PStat{
+			ID:          int(pp.id),
+			Status:      atomic.Load(&pp.status),
+			SchedTick:   atomic.Load(&pp.schedtick),
+			SyscallTick: atomic.Load(&pp.syscalltick),
+			RunqSize:    int(int32(t - h)),
+			GFreeCount:  int(pp.gfreecnt),
+		}
+		n++
+	}
+	unlock(&allpLock)
+	return n
+}
+
+// GlobalQueueSamplingStats reports on the scheduler's periodic check
+// of the global run queue, which each P makes every so many rounds
+// (see GODEBUG=schedglobalevery) even if it has local work, so that
+// goroutines on the global queue are not starved. It returns how many
+// times the check was made and how many of those found a goroutine to
+// run. A low ratio of hits to checks suggests the interval could be
+// longer; a high one with a large GlobalRunQueueSize suggests it
+// should be shorter.
+func GlobalQueueSamplingStats() (checks, hits uint64) {
+	return atomic.Load64(&globalSampleChecks), atomic.Load64(&globalSampleHits)
+}
+
+// StealCount returns the cumulative number of times a P has
+// successfully stolen goroutines from another P's run queue.
+// The per-P counters are read one at a time while Ps keep stealing,
+// so the sum is not a snapshot of a single instant.
+func StealCount() uint64 {
+	lock(&allpLock)
+	var n uint64
+	for _, pp := range allp {
+		n += atomic.Load64(&pp.nsteal)
+	}
+	unlock(&allpLock)
+	return n
+}
+
+// schedLatencyBuckets is the number of buckets in the histogram of
+// scheduling latencies; see SchedLatencyBuckets.
+const schedLatencyBuckets = 24
+
+// schedLatencyBucket returns the histogram bucket for a scheduling
+// latency of d nanoseconds.
+func schedLatencyBucket(d int64) int {
+	i := 0
+	for d >= 1024 && i < schedLatencyBuckets-1 {
+		d >>= 1
+		i++
+	}
+	return i
+}
+
+// SchedLatencyBuckets returns a histogram of how long goroutines have
+// waited between becoming runnable, for example when the channel
+// operation they were blocked on completed, and starting to run.
+// Element 0 counts waits shorter than 1024ns, and element i > 0 counts
+// waits of at least 2^(9+i)ns but shorter than twice that, except that
+// the last element also counts all longer waits.
+//
+// Recording costs a little each time a goroutine is made runnable, so it
+// is only done while the program runs with GODEBUG=schedlatency=1;
+// otherwise the counts are zero. As with StealCount, the counters are
+// read without synchronization, so the result is approximate.
+func SchedLatencyBuckets() []uint64 {
+	buckets := make([]uint64, schedLatencyBuckets)
+	lock(&allpLock)
+	// Ps beyond GOMAXPROCS are kept for reuse and still hold counts.
+	for _, pp := range allp[:cap(allp)] {
+		if pp == nil {
+			continue
+		}
+		for i := range buckets {
+			buckets[i] += pp.schedlatency[i]
+		}
+	}
+	unlock(&allpLock)
+	return buckets
+}
+
+// DeferPoolClasses is the number of size classes of defer records
+// reported by DeferPoolStats.
+const DeferPoolClasses = 5
+
+// Fails to compile unless p.deferpool has DeferPoolClasses classes.
+var _ = [1]struct{}{}[len(p{}.deferpool)-DeferPoolClasses]
+
+// DeferPoolStat holds the counts reported by DeferPoolStats for one
+// size class of defer records.
+type DeferPoolStat struct {
+	Hits   uint64 // records taken from a processor's pool
+	Misses uint64 // records allocated because the pool was empty
+}
+
+// DeferPoolStats returns, for each size class of the records that hold
+// deferred calls and their arguments, how many records the processors
+// took from their pools of free records and how many they had to
+// allocate. A high proportion of misses means defers are set up faster
+// than they complete, or on different processors, so the pools do not
+// help. Defers whose arguments are too large for any size class are
+// always allocated and not counted.
+//
+// Counting costs a little on every defer, so it is only done while the
+// program runs with GODEBUG=deferpoolstats=1; otherwise the counts are
+// zero. The counters are read without synchronization, so the result
+// is approximate.
+func DeferPoolStats() [DeferPoolClasses]DeferPoolStat {
+	var stats [DeferPoolClasses]DeferPoolStat
+	lock(&allpLock)
+	for _, pp := range allp {
+		for i := range stats {
+			stats[i].Hits += pp.deferhits[i]
+			stats[i].Misses += pp.defermisses[i]
+		}
+	}
+	unlock(&allpLock)
+	return stats
+}
+
+// WaitReasonCounts returns the number of blocked goroutines for each
+// reason they are blocked, such as "chan receive" or "select".
+// Goroutines started by the runtime itself are not counted.
+func WaitReasonCounts() map[string]int {
+	// Don't allocate while holding allglock; size the buffer first
+	// and retry if more goroutines appear in the meantime.
+	var reasons []string
+	for {
+		lock(&allglock)
+		n := len(allgs)
+		unlock(&allglock)
+		reasons = make([]string, 0, n+10)
+		lock(&allglock)
+		if len(allgs) > cap(reasons) {
+			unlock(&allglock)
+			continue
+		}
+		for _, gp := range allgs {
+			if readgstatus(gp)&^_Gscan == _Gwaiting && !isSystemGoroutine(gp) {
+				reasons = append(reasons, gp.waitreason)
+			}
+		}
+		unlock(&allglock)
+		break
+	}
+
+	counts := make(map[string]int)
+	for _, r := range reasons {
+		counts[r]++
+	}
+	return counts
+}
+
+// ParkedByFunction returns the number of blocked goroutines for each
+// function that was started with a go statement, keyed by the entry
+// PC of the function as FuncForPC expects. Functions with no blocked
+// goroutines are left out. Goroutines started by the runtime itself
+// are not counted. Together with WaitReasonCounts it shows which parts
+// of a program have goroutines piling up.
+func ParkedByFunction() map[uintptr]int {
+	// As in WaitReasonCounts, don't allocate while holding allglock.
+	var pcs []uintptr
+	for {
+		lock(&allglock)
+		n := len(allgs)
+		unlock(&allglock)
+		pcs = make([]uintptr, 0, n+10)
+		lock(&allglock)
+		if len(allgs) > cap(pcs) {
+			unlock(&allglock)
+			continue
+		}
+		for _, gp := range allgs {
+			if readgstatus(gp)&^_Gscan == _Gwaiting && !isSystemGoroutine(gp) {
+				pcs = append(pcs, gp.startpc)
+			}
+		}
+		unlock(&allglock)
+		break
+	}
+
+	counts := make(map[uintptr]int)
+	for _, pc := range pcs {
+		counts[pc]++
+	}
+	return counts
+}
+
+// WaitEdge records that a goroutine is blocked on a channel, as
+// reported by WaitEdges.
+type WaitEdge struct {
+	Goid int64   // id of the blocked goroutine
+	Chan uintptr // address of the channel, as printed by fmt's %p verb
+	Send bool    // blocked sending on the channel rather than receiving
+}
+
+// WaitEdges returns an edge for each channel operation a goroutine is
+// blocked on: one for a plain send or receive, and one per channel for
+// a select. Together with the program's own knowledge of which
+// goroutines serve which channels, the edges form a wait-for graph in
+// which a cycle is a deadlock, even one the runtime cannot report
+// because other goroutines are still running.
+//
+// Coverage is deliberately partial. A channel operation does not name
+// the goroutine that will complete it, so the runtime cannot supply
+// edges from channels to goroutines. Goroutines blocked on a nil
+// channel, a select with no cases, sync.Mutex, sync.WaitGroup and
+// other non-channel waits have no edges, and neither do goroutines
+// started by the runtime itself. The world is stopped while the edges
+// are collected, so they are consistent with each other, but they are
+// stale by the time WaitEdges returns.
+func WaitEdges() []WaitEdge {
+	// Don't allocate with the world stopped. Guess the size from
+	// the number of goroutines and retry with the counted size if
+	// the edges don't fit.
+	n := int(gcount())
+	for {
+		edges := make([]WaitEdge, 0, n+10)
+		stopTheWorld("wait edges")
+		n = 0
+		forEachWaitEdge(func(gp *g, s *sudog, send bool) {
+			if n < cap(edges) {
+				edges = append(edges, WaitEdge{gp.goid, uintptr(unsafe.Pointer(s.c)), send})
+			}
+			n++
+		})
+		startTheWorld()
+		if n <= cap(edges) {
+			return edges
+		}
+	}
+}
+
+// forEachWaitEdge calls fn for each channel operation a user goroutine
+// is blocked on. The world must be stopped.
+func forEachWaitEdge(fn func(gp *g, s *sudog, send bool)) {
+	for _, gp := range allgs {
+		if readgstatus(gp)&^_Gscan != _Gwaiting || isSystemGoroutine(gp) {
+			continue
+		}
+		for s := gp.waiting; s != nil; s = s.waitlink {
+			if s.c == nil {
+				continue
+			}
+			// The sudog is off its queue if its channel operation
+			// completed and gp is about to be readied.
+			q := &s.c.recvq
+			if s.isSend {
+				q = &s.c.sendq
+			}
+			if q.queued(s) {
+				fn(gp, s, s.isSend)
+			}
+		}
+	}
+}
+
+// GState describes a single goroutine, as reported by GoroutineStates.
+type GState struct {
+	// Goid is the goroutine's unique id, as shown in stack traces.
+	Goid int64
+
+	// Status is the goroutine's scheduling state: "runnable",
+	// "running", "syscall", "waiting" or "copystack".
+	Status string
+
+	// WaitReason describes why a waiting goroutine is blocked,
+	// such as "chan receive". It is empty if the goroutine is not
+	// waiting.
+	WaitReason string
+
+	// LockedM reports whether the goroutine is locked to its OS
+	// thread, as by LockOSThread.
+	LockedM bool
+
+	// StartPC is the program counter of the function the goroutine
+	// was started with.
+	StartPC uintptr
+}
+
+// GoroutineStates fills dst with one GState per live goroutine and
+// returns the number of entries written. If dst is too small, the
+// result is truncated. Goroutines started by the runtime itself are
+// not reported. Unlike Stack, GoroutineStates does not stop the
+// world; goroutines may change state while it runs.
+func GoroutineStates(dst []GState) int {
+	n := 0
+	lock(&allglock)
+	for _, gp := range allgs {
+		if n >= len(dst) {
+			break
+		}
+		s := readgstatus(gp) &^ _Gscan
+		if s == _Gidle || s == _Gdead || isSystemGoroutine(gp) {
+			continue
+		}
+		st := GState{
+			Goid:    gp.goid,
+			Status:  "???",
+			LockedM: gp.lockedm != 0,
+			StartPC: gp.startpc,
+		}
+		if s < uint32(len(gStatusStrings)) {
+			st.Status = gStatusStrings[s]
+		}
+		if s == _Gwaiting {
+			st.WaitReason = gp.waitreason
+		}
+		dst[n] = st
+		n++
+	}
+	unlock(&allglock)
+	return n
+}
+
+// TotalSyscallTime returns the time in nanoseconds that threads
+// running Go code have spent in system calls and calls to C, summed
+// over all threads. System calls still in progress are not counted.
+// The per-thread totals are read without synchronization, so the
+// result is approximate while system calls are being made.
+func TotalSyscallTime() int64 {
+	lock(&sched.lock)
+	t := sched.syscalltime
+	for mp := allm; mp != nil; mp = mp.alllink {
+		t += mp.syscalltime
+	}
+	unlock(&sched.lock)
+	return t
+}
+
+// GoroutineSchedCount returns how many times the goroutine with the
+// given id has been scheduled to run, and whether such a goroutine
+// exists. A goroutine that has been scheduled few times for its age
+// may be starved of processor time. The count is read without
+// synchronization, so it may lag for a goroutine that is running.
+func GoroutineSchedCount(goid int64) (uint64, bool) {
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp.goid != goid {
+			continue
+		}
+		s := readgstatus(gp) &^ _Gscan
+		if s == _Gidle || s == _Gdead {
+			break
+		}
+		n := gp.schedcount
+		unlock(&allglock)
+		return n, true
+	}
+	unlock(&allglock)
+	return 0, false
+}
+
+// PreemptGoroutine asks the goroutine with the given id to give up its
+// processor, as the scheduler does with goroutines that have been
+// running for too long, and reports whether the request was issued.
+// It is only issued if the goroutine is running on a processor at the
+// time of the call and is not the caller. The goroutine stops at its
+// next function call, and is then put on the global run queue, so it
+// resumes later; it is not killed. A goroutine in a loop that calls no
+// functions, or only functions small enough to skip the stack check,
+// does not notice the request.
+//
+// Like the scheduler's own preemption, this is best-effort: the
+// goroutine can stop running, or ignore the request, before it sees
+// it. It is meant for debugging tools that want to interrupt a runaway
+// goroutine.
+func PreemptGoroutine(goid int64) bool {
+	mp := acquirem()
+	lock(&allglock)
+	var target *p
+	for _, gp := range allgs {
+		if gp.goid != goid {
+			continue
+		}
+		if readgstatus(gp)&^_Gscan != _Grunning {
+			break
+		}
+		// A goroutine in a system call has no P and will be
+		// rescheduled when it returns anyway.
+		gpm := gp.m
+		if gpm == nil || gpm == mp {
+			break
+		}
+		if pp := gpm.p.ptr(); pp != nil && pp.m.ptr() == gpm && gpm.curg == gp {
+			target = pp
+		}
+		break
+	}
+	unlock(&allglock)
+	ok := target != nil && preemptone(target)
+	releasem(mp)
+	return ok
+}
+
+// GoroutineCreationSite returns the entry PC of the function run by
+// the goroutine with the given id and the PC of the go statement that
+// created it, for use with FuncForPC. ok is false if there is no such
+// goroutine or it has exited.
+func GoroutineCreationSite(goid int64) (startPC, goPC uintptr, ok bool) {
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp.goid != goid {
+			continue
+		}
+		s := readgstatus(gp) &^ _Gscan
+		if s == _Gidle || s == _Gdead {
+			break
+		}
+		startPC, goPC = gp.startpc, gp.gopc
+		unlock(&allglock)
+		return startPC, goPC, true
+	}
+	unlock(&allglock)
+	return 0, 0, false
+}
+
+// OldestBlockedGoroutine returns the id of the goroutine that has been
+// blocked the longest, for example on a channel, a mutex or a
+// select, and for how many nanoseconds it has been blocked. Goroutines
+// in system calls and those the runtime runs internally are not
+// considered. If no goroutine is blocked, it returns 0, 0. A goroutine
+// that stays blocked for minutes is often stuck.
+//
+// As for the minutes shown in goroutine tracebacks, a goroutine is
+// timed from the start of the first garbage collection that finds it
+// blocked, so the result lags by up to the time between collections,
+// and goroutines that blocked after the last one are not considered.
+// The runtime forces a collection at least every two minutes.
+func OldestBlockedGoroutine() (goid int64, blockedNs int64) {
+	now := nanotime()
+	var since int64
+	lock(&allglock)
+	for _, gp := range allgs {
+		if readgstatus(gp)&^_Gscan != _Gwaiting || isSystemGoroutine(gp) {
+			continue
+		}
+		if ws := gp.waitsince; ws != 0 && (since == 0 || ws < since) {
+			goid, since = gp.goid, ws
+		}
+	}
+	unlock(&allglock)
+	if since == 0 {
+		return 0, 0
+	}
+	return goid, now - since
+}
+
+// RuntimeStartTime returns the time at which the runtime started, in
+// nanoseconds on the monotonic clock the scheduler uses internally,
+// for example to record when a goroutine blocked. That clock is not
+// wall-clock time and has an arbitrary origin, so only differences
+// between its readings are meaningful. RuntimeNanotime reads it; the
+// difference from RuntimeStartTime is the process uptime that
+// GODEBUG=gctrace=1 output is stamped with, and subtracting a duration
+// reported by OldestBlockedGoroutine gives the reading at which that
+// goroutine was first found blocked.
+func RuntimeStartTime() int64 {
+	return runtimeInitTime
+}
+
+// RuntimeNanotime returns the current reading, in nanoseconds, of the
+// monotonic clock described at RuntimeStartTime.
+func RuntimeNanotime() int64 {
+	return nanotime()
+}
+
+// GoroutineCPUTime returns the time in nanoseconds the goroutine with
+// the given id has spent running, and whether such a goroutine exists.
+// The time is wall-clock time during which the goroutine was scheduled
+// on a thread, not CPU time as accounted by the operating system, and
+// does not include time spent in system calls. The value is read
+// without synchronization, so it is approximate for a goroutine that
+// is currently running.
+func GoroutineCPUTime(goid int64) (int64, bool) {
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp.goid != goid {
+			continue
+		}
+		s := readgstatus(gp) &^ _Gscan
+		if s == _Gidle || s == _Gdead {
+			break
+		}
+		t := gp.cputime
+		if s == _Grunning {
+			if d := nanotime() - gp.runstart; d > 0 {
+				t += d
+			}
+		}
+		unlock(&allglock)
+		return t, true
+	}
+	unlock(&allglock)
+	return 0, false
+}
+
+// GoroutineStackSize returns the size in bytes of the stack currently
+// allocated to the goroutine with the given id, and whether such a
+// goroutine exists and has a stack. Stacks grow by doubling as needed,
+// so sampling this can find goroutines approaching the maximum set by
+// debug.SetMaxStack. The value is read without synchronization, so it
+// may be stale for a goroutine whose stack is growing or shrinking.
+func GoroutineStackSize(goid int64) (size uintptr, ok bool) {
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp.goid != goid {
+			continue
+		}
+		s := readgstatus(gp) &^ _Gscan
+		if s == _Gidle || s == _Gdead {
+			break
+		}
+		lo, hi := gp.stack.lo, gp.stack.hi
+		if lo == 0 || hi < lo {
+			break
+		}
+		unlock(&allglock)
+		return hi - lo, true
+	}
+	unlock(&allglock)
+	return 0, false
+}
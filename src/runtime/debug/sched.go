@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import "io"
+
+// DumpSchedState writes a snapshot of the scheduler's state to w: one
+// summary line followed by a line for each P, each M and each
+// goroutine, in the format printed by GODEBUG=schedtrace=X,scheddetail=1.
+// The snapshot is taken with the scheduler locked, but the fields of
+// running Ps, Ms and goroutines may still change while it is taken, so
+// it need not be entirely consistent.
+func DumpSchedState(w io.Writer) error {
+	_, err := w.Write(schedDump())
+	return err
+}
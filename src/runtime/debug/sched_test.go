@@ -0,0 +1,47 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug_test
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	. "runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestDumpSchedState(t *testing.T) {
+	// Make sure there are more goroutines than fit in the
+	// initial buffer.
+	stop := make(chan bool)
+	defer close(stop)
+	for i := 0; i < 200; i++ {
+		go func() { <-stop }()
+	}
+
+	var buf bytes.Buffer
+	if err := DumpSchedState(&buf); err != nil {
+		t.Fatalf("DumpSchedState: %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "SCHED ") {
+		t.Fatalf("dump does not start with a SCHED line:\n%s", out)
+	}
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		if p := fmt.Sprintf("\n  P%d: ", i); !strings.Contains(out, p) {
+			t.Errorf("dump is missing P%d", i)
+		}
+	}
+	if !strings.Contains(out, "\n  M0: ") {
+		t.Errorf("dump is missing M0")
+	}
+	if n := strings.Count(out, "\n  G"); n < 200 {
+		t.Errorf("dump lists %d goroutines, want at least 200", n)
+	}
+	if !strings.HasSuffix(out, "\n") {
+		t.Errorf("dump is truncated")
+	}
+}
@@ -54,11 +54,15 @@ const (
 	PROT_WRITE = C.PROT_WRITE
 	PROT_EXEC  = C.PROT_EXEC
 
-	MAP_ANON    = C.MAP_ANONYMOUS
-	MAP_PRIVATE = C.MAP_PRIVATE
-	MAP_FIXED   = C.MAP_FIXED
-
-	MADV_DONTNEED = C.MADV_DONTNEED
+	MAP_ANON     = C.MAP_ANONYMOUS
+	MAP_PRIVATE  = C.MAP_PRIVATE
+	MAP_FIXED    = C.MAP_FIXED
+	MAP_POPULATE = C.MAP_POPULATE
+
+	MADV_DONTNEED   = C.MADV_DONTNEED
+	MADV_FREE       = C.MADV_FREE
+	MADV_PAGEOUT    = C.MADV_PAGEOUT
+	MADV_WIPEONFORK = C.MADV_WIPEONFORK
 
 	SA_RESTART  = C.SA_RESTART
 	SA_ONSTACK  = C.SA_ONSTACK
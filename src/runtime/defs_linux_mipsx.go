@@ -17,13 +17,17 @@ const (
 	_PROT_WRITE = 0x2
 	_PROT_EXEC  = 0x4
 
-	_MAP_ANON    = 0x800
-	_MAP_PRIVATE = 0x2
-	_MAP_FIXED   = 0x10
+	_MAP_ANON     = 0x800
+	_MAP_PRIVATE  = 0x2
+	_MAP_FIXED    = 0x10
+	_MAP_POPULATE = 0x10000
 
 	_MADV_DONTNEED   = 0x4
+	_MADV_FREE       = 0x8
 	_MADV_HUGEPAGE   = 0xe
 	_MADV_NOHUGEPAGE = 0xf
+	_MADV_PAGEOUT    = 0x15
+	_MADV_WIPEONFORK = 0x12
 
 	_SA_RESTART = 0x10000000
 	_SA_ONSTACK = 0x8000000
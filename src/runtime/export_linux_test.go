@@ -6,5 +6,19 @@
 
 package runtime
 
+import (
+	"runtime/internal/sys"
+	"unsafe"
+)
+
 var NewOSProc0 = newosproc0
 var Mincore = mincore
+
+const HugePageSize = sys.HugePageSize
+
+// SysUnusedUsed releases the n bytes at v to the OS and reuses them,
+// as the heap does with sysUnused and sysUsed.
+func SysUnusedUsed(v unsafe.Pointer, n uintptr) {
+	sysUnused(v, n)
+	sysUsed(v, n)
+}
@@ -26,6 +26,7 @@ var Sqrt = sqrt
 var Entersyscall = entersyscall
 var Exitsyscall = exitsyscall
 var LockedOSThread = lockedOSThread
+var StartGoroutines = startGoroutines
 var Xadduintptr = atomic.Xadduintptr
 
 var FuncPC = funcPC
@@ -34,6 +35,7 @@ var Fastlog2 = fastlog2
 
 var Atoi = atoi
 var Atoi32 = atoi32
+var Atoi64 = atoi64
 
 type LFNode struct {
 	Next    uint64
@@ -120,6 +122,27 @@ func RunSchedLocalQueueStealTest() {
 	}
 }
 
+func RunSchedLocalQueuePriorityTest() {
+	_p_ := new(p)
+	gs := make([]g, 3)
+	gs[1].priority = 1
+
+	// A high-priority G goes to runnext even if next is false,
+	// and is not displaced by a normal G put with next.
+	runqput(_p_, &gs[0], false)
+	runqput(_p_, &gs[1], false)
+	runqput(_p_, &gs[2], true)
+	for i, want := range []*g{&gs[1], &gs[0], &gs[2]} {
+		if g, _ := runqget(_p_); g != want {
+			print("bad element at ", i, "\n")
+			throw("bad element")
+		}
+	}
+	if g, _ := runqget(_p_); g != nil {
+		throw("runq is not empty afterwards")
+	}
+}
+
 func RunSchedLocalQueueEmptyTest(iters int) {
 	// Test that runq is not spuriously reported as empty.
 	// Runq emptiness affects scheduling decisions and spurious emptiness
@@ -401,6 +424,178 @@ func LockOSCounts() (external, internal uint32) {
 	return g.m.lockedExt, g.m.lockedInt
 }
 
+// Goid returns the id of the calling goroutine.
+func Goid() int64 {
+	return getg().goid
+}
+
+// CurrentP returns the id of the P the calling goroutine is running on.
+func CurrentP() int {
+	mp := acquirem()
+	id := mp.p.ptr().id
+	releasem(mp)
+	return int(id)
+}
+
+// externalTestG holds a goroutine parked for RunExternalRunSourceTest.
+// Like all guintptrs it is not seen by the GC, which is fine because
+// the goroutine is kept alive by allgs.
+var externalTestG guintptr
+
+func externalTestPark(gp *g, _ unsafe.Pointer) bool {
+	if !externalTestG.cas(0, guintptr(unsafe.Pointer(gp))) {
+		throw("externalTestPark: goroutine already parked")
+	}
+	return true
+}
+
+func externalTestSource() *g {
+	gp := externalTestG.ptr()
+	if gp != nil && externalTestG.cas(guintptr(unsafe.Pointer(gp)), 0) {
+		return gp
+	}
+	return nil
+}
+
+// RunExternalRunSourceTest parks a goroutine that only an external
+// run source hands back to the scheduler, and returns once it has run.
+func RunExternalRunSourceTest() {
+	setExternalRunSource(externalTestSource)
+	defer setExternalRunSource(nil)
+	done := make(chan bool)
+	go func() {
+		gopark(externalTestPark, nil, "external run source test", traceEvGoBlock, 1)
+		done <- true
+	}()
+	<-done
+}
+
+// injectTest collects the goroutines parked by RunInjectReadyTest.
+var injectTest struct {
+	lock mutex
+	gs   []*g
+}
+
+func injectTestPark(gp *g, _ unsafe.Pointer) bool {
+	injectTest.gs = append(injectTest.gs, gp)
+	unlock(&injectTest.lock)
+	return true
+}
+
+// RunInjectReadyTest parks n goroutines, makes them runnable with
+// injectReady, and returns once they have all run.
+func RunInjectReadyTest(n int) {
+	injectTest.gs = make([]*g, 0, n)
+	done := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func() {
+			lock(&injectTest.lock)
+			gopark(injectTestPark, nil, "inject ready test", traceEvGoBlock, 1)
+			done <- true
+		}()
+	}
+	for {
+		lock(&injectTest.lock)
+		parked := len(injectTest.gs)
+		unlock(&injectTest.lock)
+		if parked == n {
+			break
+		}
+		Gosched()
+	}
+	injectReady(injectTest.gs)
+	injectTest.gs = nil
+	for i := 0; i < n; i++ {
+		<-done
+	}
+}
+
+// StealSequence returns the order in which the P with the given id
+// would visit count Ps in its next n passes in findrunnable, as if it
+// had just been created.
+func StealSequence(id int32, count uint32, n int) []uint32 {
+	pp := &p{id: id, numaNode: -1}
+	var ord randomOrder
+	ord.reset(count)
+	var seq []uint32
+	for i := 0; i < n; i++ {
+		for enum := ord.start(stealStart(pp), pp.numaNode, nil); !enum.done(); enum.next() {
+			seq = append(seq, enum.position())
+		}
+	}
+	return seq
+}
+
+// MutexSpin returns the number of procyield iterations
+// sync.Mutex.Lock spins for on the mutex at m.
+func MutexSpin(m unsafe.Pointer) uint32 {
+	return mutexSpinCount(mutexSpinSlot(m))
+}
+
+// MutexSpinResult feeds back the outcome of a round of spinning on m.
+func MutexSpinResult(m unsafe.Pointer, acquired bool) {
+	sync_runtime_spinResult(m, acquired)
+}
+
+const DefaultMutexSpin = active_spin_cnt
+
+// MmapFailed records a failed mmap call as the memory helpers do.
+func MmapFailed(errno int) {
+	mmapFailed(errno)
+}
+
+// MergeMemRanges merges contiguous [base, n] ranges as sysFreeBatch does.
+func MergeMemRanges(ranges [][2]uintptr) [][2]uintptr {
+	regions := make([]memRange, len(ranges))
+	for i, r := range ranges {
+		regions[i] = memRange{r[0], r[1]}
+	}
+	var merged [][2]uintptr
+	for _, r := range mergeMemRanges(regions) {
+		merged = append(merged, [2]uintptr{r.base, r.n})
+	}
+	return merged
+}
+
+// ReserveAligned reserves n bytes aligned to align with
+// sysReserveAligned, maps and touches them, and frees them again. It
+// returns the address of the reservation, or 0 if it failed.
+func ReserveAligned(n, align uintptr) uintptr {
+	var reserved bool
+	p := sysReserveAligned(nil, n, align, &reserved)
+	if p == nil {
+		return 0
+	}
+	var stat uint64
+	sysMap(p, n, reserved, &stat)
+	*(*byte)(p) = 1
+	*(*byte)(add(p, n-1)) = 1
+	sysFree(p, n, &stat)
+	return uintptr(p)
+}
+
+// SetDebugSysmonPause sets GODEBUG=sysmonpause and returns the old value.
+func SetDebugSysmonPause(v int32) int32 {
+	old := debug.sysmonpause
+	debug.sysmonpause = v
+	return old
+}
+
+// SetDebugReadyLastP sets GODEBUG=readylastp and returns the old value.
+func SetDebugReadyLastP(v int32) int32 {
+	old := debug.readylastp
+	debug.readylastp = v
+	return old
+}
+
+// SetDebugDeferPoolStats sets GODEBUG=deferpoolstats and returns the
+// old value.
+func SetDebugDeferPoolStats(v int32) int32 {
+	old := debug.deferpoolstats
+	debug.deferpoolstats = v
+	return old
+}
+
 //go:noinline
 func TracebackSystemstack(stk []uintptr, i int) int {
 	if i == 0 {
@@ -413,3 +608,62 @@ func TracebackSystemstack(stk []uintptr, i int) int {
 	})
 	return n
 }
+
+const StealVictimWindow = stealVictimWindow
+
+// StealVictims is an m's record of Ps it recently stole from.
+type StealVictims struct {
+	m m
+}
+
+func (v *StealVictims) Note(id int32, now int64) {
+	v.m.noteVictim(id, now)
+}
+
+func (v *StealVictims) Recent(id int32, now int64) bool {
+	return v.m.recentVictim(id, now)
+}
+
+var SchedLatencyBucket = schedLatencyBucket
+
+func ExtraMCount() (n int, ok bool) {
+	if !cgoHasExtraM {
+		return 0, false
+	}
+	mp := lockextra(true)
+	n = int(extraMCount)
+	unlockextra(mp)
+	return n, true
+}
+
+type HookRing struct {
+	r hookRing
+}
+
+func (r *HookRing) Put(a int64) {
+	r.r.put(a, 0, 0)
+}
+
+// Claim claims a slot as put does, without writing it.
+func (r *HookRing) Claim() uint32 {
+	i := atomic.Xadd(&r.r.head, 1) - 1
+	atomic.Store(&r.r.buf[i%uint32(len(r.r.buf))].seq, 0)
+	return i
+}
+
+// Write writes and publishes the slot returned by Claim.
+func (r *HookRing) Write(i uint32, a int64) {
+	e := &r.r.buf[i%uint32(len(r.r.buf))]
+	e.a = a
+	atomic.Store(&e.seq, i+1)
+}
+
+func (r *HookRing) Get() []int64 {
+	var evs [len(hookRing{}.buf)]hookEvent
+	n := r.r.get(&evs)
+	var as []int64
+	for _, ev := range evs[:n] {
+		as = append(as, ev.a)
+	}
+	return as
+}
@@ -34,6 +34,14 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	expensive checks that should not miss any errors, but will
 	cause your program to run slower.
 
+	deadlockdetail: setting deadlockdetail=1 causes the runtime to print
+	the id, status and wait reason of every goroutine before crashing
+	with "all goroutines are asleep - deadlock!".
+
+	deferpoolstats: setting deferpoolstats=1 makes each processor count how often
+	deferred calls are set up with a record from its pool of free defer records
+	rather than a newly allocated one, as reported by DeferPoolStats.
+
 	efence: setting efence=1 causes the allocator to run in a mode
 	where each object is allocated on a unique page and addresses are
 	never recycled.
@@ -95,6 +103,11 @@ It is a comma-separated list of name=val pairs setting these named variables:
 		released: #  MB released to the system
 		consumed: #  MB allocated from the system
 
+	goidcachebatch: setting goidcachebatch=N makes each processor reserve goroutine
+	ids N at a time rather than 16. Larger values mean less contention between
+	processors creating goroutines, at the cost of larger gaps between the ids of
+	goroutines created one after another. Values below 1 are treated as 1.
+
 	memprofilerate: setting memprofilerate=X will update the value of runtime.MemProfileRate.
 	When set to 0 memory profiling is disabled.  Refer to the description of
 	MemProfileRate for the default value.
@@ -105,19 +118,128 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	This should only be used as a temporary workaround to diagnose buggy code.
 	The real fix is to not store integers in pointer-typed locations.
 
+	madvdontneed: setting madvdontneed=1 will use MADV_DONTNEED
+	instead of MADV_FREE on Linux when returning memory to the
+	kernel. This is less efficient, but causes RSS numbers to drop
+	more quickly.
+
+	maxstackmb: setting maxstackmb=N limits the stack of each goroutine to N
+	megabytes (10^6 bytes) instead of the default 1000 on 64-bit systems and 250 on
+	32-bit systems. Values larger than the default are ignored. A goroutine that
+	exceeds the limit crashes the program with a stack overflow.
+	debug.SetMaxStack overrides this setting.
+
+	netpolloff: setting netpolloff=1 stops the scheduler from polling the network,
+	so goroutines blocked on network or other pollable I/O, such as pipes, are never
+	made runnable again. THIS BREAKS NETWORKING and can make programs hang or crash
+	with a deadlock. It exists only to find out whether an unexpected wakeup comes
+	from the network poller and must never be used in production.
+
+	nosteal: setting nosteal=1 stops idle processors from stealing goroutines from
+	the run queues of other processors, so goroutines stay on the processor that made
+	them runnable unless they go through the global run queue. Combined with
+	GOMAXPROCS=1 this makes scheduling order closer to deterministic, which can help
+	reproduce ordering bugs. It can cause severe load imbalance and is intended for
+	debugging only.
+
+	preemptus: setting preemptus=X asks goroutines that have been running for X
+	microseconds without yielding to give up their processor. The default is 10000.
+	Lower values improve scheduling latency at the cost of more context switches.
+	Values are clamped to between 100 and 1000000; sysmonmaxus should be no larger
+	than X for the setting to take full effect.
+
+	readylastp: setting readylastp=1 makes the scheduler try to run a goroutine that
+	is woken up on the processor it last ran on, if that processor is idle, rather
+	than on the processor of the goroutine that woke it, so that it resumes with a
+	warm cache. Each such attempt takes the scheduler's global lock, so it can slow
+	down programs that wake many goroutines while some processors are idle.
+
+	retakesyscallus: setting retakesyscallus=X makes the scheduler hand off the
+	processor of a goroutine blocked in a system call for X microseconds, even if no
+	other goroutine is waiting to run. The default is 10000. Values are clamped to
+	between 100 and 1000000.
+
 	sbrk: setting sbrk=1 replaces the memory allocator and garbage collector
 	with a trivial allocator that obtains memory from the operating system and
 	never reclaims any memory.
 
 	scavenge: scavenge=1 enables debugging mode of heap scavenger.
 
+	scavengelimitns: setting scavengelimitns=X makes the runtime return heap memory
+	to the operating system once it has gone unused for X nanoseconds after a garbage
+	collection, checking every X/2 nanoseconds. The default is 300000000000 (5
+	minutes). Lower values reduce the resident size of programs whose heap shrinks,
+	at the cost of page faults if it grows again. Values below 1000000 (1ms) are
+	treated as 1000000, and values that are not positive are ignored. scavenge=1
+	overrides this setting.
+
 	scheddetail: setting schedtrace=X and scheddetail=1 causes the scheduler to emit
 	detailed multiline info every X milliseconds, describing state of the scheduler,
 	processors, threads and goroutines.
 
+	schedglobalevery: setting schedglobalevery=N causes the scheduler to check the
+	global run queue every N scheduling rounds on each P, even if the P has local work.
+	The default is 61. Smaller values improve fairness for goroutines waiting on the
+	global queue at some cost in locality. Values below 1 are treated as 1.
+
+	schedlatency: setting schedlatency=1 makes the scheduler record how long each
+	goroutine waits between becoming runnable and starting to run, as reported by
+	SchedLatencyBuckets. It costs one clock read each time a goroutine is made
+	runnable.
+
+	schedrandom: setting schedrandom=1 randomizes the order in which the scheduler
+	runs goroutines, as it always does in programs built with -race, so that tests
+	can shake out latent assumptions about scheduling order without the overhead of
+	the race detector. It deliberately makes scheduling less efficient and should
+	not be used in production.
+
 	schedtrace: setting schedtrace=X causes the scheduler to emit a single line to standard
 	error every X milliseconds, summarizing the scheduler state.
 
+	stealattempts: setting stealattempts=N makes an idle processor look through the
+	run queues of all other processors N times for goroutines to steal before it gives
+	up and goes idle. Only the last pass steals a goroutine that another processor is
+	about to run next. The default is 4. Larger values can find work that appears
+	shortly after a processor runs out, at the cost of more CPU spent spinning.
+	Values below 1 are treated as 1.
+
+	stealnextbackoffus: setting stealnextbackoffus=N makes an idle processor wait N
+	microseconds before stealing the goroutine that a running processor is about to
+	run next, giving that processor a chance to run it first. The default is 3.
+	Larger values reduce goroutines bouncing between processors where the wait itself
+	is slow; smaller values reduce the latency of stolen goroutines. 0 disables the
+	wait and negative values are treated as 0. On Windows, where sleeps are too
+	coarse, the processor yields the thread instead and this setting has no effect.
+
+	stwlog: setting stwlog=1 causes the runtime to emit a single line to standard
+	error each time it restarts the world after stopping it, giving the reason for
+	the stop and how long, in microseconds, goroutines were kept from running.
+	Stops made by the garbage collector are reported with the reason "GC" or "gcing".
+
+	sudogcache: setting sudogcache=N sets how many sudogs, the records of goroutines
+	blocked on channels and other synchronization, each processor caches locally. When
+	a cache fills or empties, half of it is exchanged with a central cache under a lock.
+	The default is 128. Larger values reduce contention on that lock in programs with
+	heavy channel traffic, at the cost of more memory held per processor. Values are
+	limited to the range 1 to 4096.
+
+	syscallmpool: setting syscallmpool=N keeps at least N idle operating system threads
+	parked and ready, so that a goroutine entering a blocking system call can hand off
+	its processor to a waiting thread instead of creating one. Threads taken from the
+	pool are replaced in the background. Idle threads are never destroyed, so the pool
+	only sets a minimum; its threads count toward the limit set by
+	runtime/debug.SetMaxThreads. The default, 0, keeps no pool.
+
+	sysmonminus, sysmonmaxus: setting sysmonminus=X and sysmonmaxus=Y bound how long
+	the system monitor thread sleeps between checks to between X and Y microseconds.
+	The defaults are 20 and 10000. Raising the floor reduces wakeups on idle systems;
+	lowering the ceiling makes preemption of long-running goroutines and retaking of
+	Ps blocked in system calls more prompt. If X is larger than Y, Y is used for both.
+
+	sysmonpause: setting sysmonpause=1 allows SetSysmonPaused to pause the system
+	monitor thread. It has no effect on its own. It is meant for controlled
+	experiments such as microbenchmarks; see SetSysmonPaused for the consequences.
+
 The net and net/http packages also refer to debugging variables in GODEBUG.
 See the documentation for those packages for details.
 
@@ -376,6 +376,193 @@ func BenchmarkAllocation(b *testing.B) {
 	}
 }
 
+func TestForceGCNow(t *testing.T) {
+	// Make sure we're not in the middle of a GC.
+	runtime.GC()
+
+	var ms1, ms2 runtime.MemStats
+	runtime.ReadMemStats(&ms1)
+
+	// The forced GC runs in the background, so give it some slack
+	// on a heavily loaded system.
+	for i := 0; i < 200; i++ {
+		runtime.ForceGCNow()
+		time.Sleep(5 * time.Millisecond)
+		runtime.ReadMemStats(&ms2)
+		if ms2.NumGC > ms1.NumGC {
+			return
+		}
+	}
+	t.Fatalf("no GC after ForceGCNow: NumGC stayed at %v", ms1.NumGC)
+}
+
+func TestLastSTWDuration(t *testing.T) {
+	runtime.GC()
+	d := runtime.LastSTWDuration()
+	if d <= 0 || d > int64(10*time.Second) {
+		t.Errorf("LastSTWDuration after GC = %v, want positive and reasonable", time.Duration(d))
+	}
+}
+
+var scavengeSink []byte
+
+func TestScavengeColdPages(t *testing.T) {
+	scavengeSink = make([]byte, 16<<20)
+	for i := 0; i < len(scavengeSink); i += 4096 {
+		scavengeSink[i] = 1
+	}
+	scavengeSink = nil
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	runtime.ScavengeColdPages()
+	runtime.ReadMemStats(&after)
+	if after.HeapReleased < before.HeapReleased+8<<20 {
+		t.Errorf("HeapReleased went from %d to %d, want an increase of at least 8MB", before.HeapReleased, after.HeapReleased)
+	}
+}
+
+func TestReserveAligned(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux aligns reservations")
+	}
+	const align = 2 << 20
+	for _, n := range []uintptr{64 << 10, 3 << 20, 8 << 20} {
+		p := runtime.ReserveAligned(n, align)
+		if p == 0 {
+			t.Fatalf("ReserveAligned(%#x, %#x) failed", n, align)
+		}
+		if p%align != 0 {
+			t.Errorf("ReserveAligned(%#x, %#x) = %#x, not aligned", n, align, p)
+		}
+	}
+}
+
+func TestMarkWipeOnFork(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if err := runtime.MarkWipeOnFork(nil, 0); err == nil {
+			t.Errorf("MarkWipeOnFork succeeded on %s", runtime.GOOS)
+		}
+		return
+	}
+	pageSize := uintptr(os.Getpagesize())
+	b := make([]byte, 64<<10)
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)%pageSize != 0 {
+		t.Skipf("large allocation at %p is not aligned to the %d byte page size", p, pageSize)
+	}
+	if err := runtime.MarkWipeOnFork(unsafe.Pointer(uintptr(p)+1), pageSize); err == nil {
+		t.Errorf("MarkWipeOnFork accepted an unaligned pointer")
+	}
+	if err := runtime.MarkWipeOnFork(p, pageSize+1); err == nil {
+		t.Errorf("MarkWipeOnFork accepted an unaligned length")
+	}
+	if err := runtime.MarkWipeOnFork(p, uintptr(len(b))/pageSize*pageSize); err != nil {
+		t.Skipf("MarkWipeOnFork: %v", err)
+	}
+	b[0] = 1 // still usable in the parent
+	runtime.KeepAlive(b)
+}
+
+func TestAddressSpaceStats(t *testing.T) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	reserved, mapped := runtime.AddressSpaceStats()
+	if mapped < ms.HeapSys {
+		t.Errorf("mapped = %d, want at least HeapSys = %d", mapped, ms.HeapSys)
+	}
+	if reserved < mapped {
+		t.Errorf("reserved = %d, want at least mapped = %d", reserved, mapped)
+	}
+}
+
+func TestMmapFailureStats(t *testing.T) {
+	before := runtime.MmapFailureStats()
+	runtime.MmapFailed(12)
+	runtime.MmapFailed(1000)
+	runtime.MmapFailed(0)
+	after := runtime.MmapFailureStats()
+	if got, want := after[12], before[12]+1; got != want {
+		t.Errorf("failures with errno 12 = %d, want %d", got, want)
+	}
+	if got, want := after[255], before[255]+1; got != want {
+		t.Errorf("failures with errno 255 = %d, want %d", got, want)
+	}
+	if n, ok := after[0]; ok {
+		t.Errorf("%d failures with errno 0 recorded", n)
+	}
+}
+
+func TestMappingStats(t *testing.T) {
+	// Other goroutines may map memory while we look, so retry until
+	// MemStats is the same on both sides of MappingStats.
+	var before, after runtime.MemStats
+	var m map[string]uint64
+	for i := 0; ; i++ {
+		runtime.ReadMemStats(&before)
+		m = runtime.MappingStats()
+		runtime.ReadMemStats(&after)
+		if before.Sys == after.Sys && before.StackSys == after.StackSys {
+			break
+		}
+		if i == 100 {
+			t.Skip("memory mappings kept changing")
+		}
+	}
+	want := map[string]uint64{
+		"heap":     after.HeapSys,
+		"stacks":   after.StackSys,
+		"mspan":    after.MSpanSys,
+		"mcache":   after.MCacheSys,
+		"buckhash": after.BuckHashSys,
+		"gc":       after.GCSys,
+		"other":    after.OtherSys,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("MappingStats() = %v, want %v", m, want)
+	}
+}
+
+func TestMergeMemRanges(t *testing.T) {
+	tests := []struct {
+		in, want [][2]uintptr
+	}{
+		{nil, nil},
+		{[][2]uintptr{{0x1000, 0x1000}}, [][2]uintptr{{0x1000, 0x1000}}},
+		// Out of order but contiguous.
+		{
+			[][2]uintptr{{0x3000, 0x1000}, {0x1000, 0x1000}, {0x2000, 0x1000}},
+			[][2]uintptr{{0x1000, 0x3000}},
+		},
+		// A gap of one byte keeps ranges apart.
+		{
+			[][2]uintptr{{0x1000, 0x1000}, {0x2001, 0x1000}, {0x4000, 0x2000}, {0x3001, 0xfff}},
+			[][2]uintptr{{0x1000, 0x1000}, {0x2001, 0x3fff}},
+		},
+	}
+	for _, tt := range tests {
+		in := append([][2]uintptr(nil), tt.in...)
+		if got := runtime.MergeMemRanges(in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MergeMemRanges(%#x) = %#x, want %#x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPreallocHeap(t *testing.T) {
+	const n = 16 << 20
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	runtime.PreallocHeap(n)
+	runtime.ReadMemStats(&after)
+	if after.HeapSys < before.HeapSys+n {
+		t.Errorf("HeapSys grew from %d to %d, want growth of at least %d", before.HeapSys, after.HeapSys, n)
+	}
+	if after.HeapIdle < before.HeapIdle+n {
+		t.Errorf("HeapIdle grew from %d to %d, want growth of at least %d", before.HeapIdle, after.HeapIdle, n)
+	}
+}
+
 func TestPrintGC(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping in short mode")
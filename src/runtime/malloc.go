@@ -115,6 +115,7 @@
 package runtime
 
 import (
+	"runtime/internal/atomic"
 	"runtime/internal/sys"
 	"unsafe"
 )
@@ -211,6 +212,205 @@ const (
 // mallocinit.
 var physPageSize uintptr
 
+// hugePagesOff is 1 if the heap should not ask the OS to back it with
+// huge pages. Accessed atomically; see SetHugePagePolicy.
+var hugePagesOff uint32
+
+// SetHugePagePolicy controls whether the runtime asks the operating
+// system to back the heap with transparent huge pages. It is enabled
+// by default. It currently only has an effect on Linux.
+//
+// Huge pages make memory access cheaper but can cause latency spikes
+// when the kernel compacts memory to form them. When disabled, the
+// runtime stops marking reused heap memory as eligible for huge pages
+// (MADV_HUGEPAGE) but still marks released memory as ineligible
+// (MADV_NOHUGEPAGE), so over time less of the heap is backed by them.
+// Memory the runtime never marked either way is governed by the
+// kernel's THP setting in /sys/kernel/mm/transparent_hugepage/enabled:
+// with "always" the kernel may still use huge pages for it, with
+// "madvise" it will not, and with "never" huge pages are not used at
+// all regardless of this policy.
+func SetHugePagePolicy(enable bool) {
+	if enable {
+		atomic.Store(&hugePagesOff, 0)
+	} else {
+		atomic.Store(&hugePagesOff, 1)
+	}
+}
+
+// addrSpaceReserved and addrSpaceMapped count the bytes of address
+// space the OS-defined helpers below have reserved and mapped. Mapped
+// memory is also counted as reserved. They are uintptrs so they can be
+// updated atomically without locks on every platform; see mSysStatInc.
+var (
+	addrSpaceReserved uintptr
+	addrSpaceMapped   uintptr
+)
+
+//go:nosplit
+func addrSpaceAdd(reserved, mapped uintptr) {
+	if reserved != 0 {
+		atomic.Xadduintptr(&addrSpaceReserved, reserved)
+	}
+	if mapped != 0 {
+		atomic.Xadduintptr(&addrSpaceMapped, mapped)
+	}
+}
+
+//go:nosplit
+func addrSpaceSub(reserved, mapped uintptr) {
+	addrSpaceAdd(-reserved, -mapped)
+}
+
+// AddressSpaceStats reports how much virtual address space the
+// runtime has obtained from the operating system. reserved is all of
+// it, including mapped; mapped is the part that has been made
+// accessible, whether or not it has since been returned to the OS
+// with debug.FreeOSMemory or the scavenger. The difference is address
+// space that is reserved but not yet usable, which can matter when
+// tuning ulimit -v.
+//
+// On 64-bit Unix systems, large heap reservations are only checked
+// rather than made (see sysReserve), so part of reserved may not
+// count against ulimit -v until it is mapped.
+func AddressSpaceStats() (reserved, mapped uint64) {
+	return uint64(atomic.Loaduintptr(&addrSpaceReserved)), uint64(atomic.Loaduintptr(&addrSpaceMapped))
+}
+
+// mmapFailures counts the mmap calls made by the OS-defined helpers
+// below that failed, indexed by errno. Errnos too large for the array
+// are counted in its last entry.
+var mmapFailures [256]uint32
+
+// mmapFailed records a failed mmap call that returned errno.
+//
+//go:nosplit
+func mmapFailed(errno int) {
+	if errno <= 0 {
+		return
+	}
+	if errno >= len(mmapFailures) {
+		errno = len(mmapFailures) - 1
+	}
+	atomic.Xadd(&mmapFailures[errno], 1)
+}
+
+// MmapFailureStats returns the number of times mmap has failed while
+// the runtime was reserving or mapping memory, keyed by errno. Most of
+// these failures are fatal, but some, such as reservations the heap
+// can place elsewhere, are not, so a growing count can reveal address
+// space pressure from ulimit -v or vm.max_map_count before the runtime
+// runs out of memory. Errnos of 255 and above are all counted as 255.
+// The map is empty on systems that do not use mmap.
+func MmapFailureStats() map[int]uint64 {
+	m := make(map[int]uint64)
+	for errno := range mmapFailures {
+		if n := atomic.Load(&mmapFailures[errno]); n != 0 {
+			m[errno] = uint64(n)
+		}
+	}
+	return m
+}
+
+// memRange is the region of memory [base, base+n).
+type memRange struct {
+	base, n uintptr
+}
+
+// sysFreeBatch frees each of regions as sysFree would, but makes one
+// call to sysFree for each run of regions that are contiguous in
+// memory, so that freeing many adjacent regions takes few munmap
+// calls. It sorts regions in place. Regions that merely overlap or are
+// separated by a gap are never merged.
+//
+// On Windows memory can only be released one allocation at a time, so
+// there the regions are freed individually.
+func sysFreeBatch(regions []memRange, sysStat *uint64) {
+	if GOOS == "windows" {
+		for _, r := range regions {
+			sysFree(unsafe.Pointer(r.base), r.n, sysStat)
+		}
+		return
+	}
+	for _, r := range mergeMemRanges(regions) {
+		sysFree(unsafe.Pointer(r.base), r.n, sysStat)
+	}
+}
+
+// mergeMemRanges sorts regions by base address and merges each run of
+// regions that are exactly contiguous into one. It works in place and
+// returns the merged prefix of regions.
+func mergeMemRanges(regions []memRange) []memRange {
+	// Insertion sort; batches are small and often already in order.
+	for i := 1; i < len(regions); i++ {
+		for j := i; j > 0 && regions[j].base < regions[j-1].base; j-- {
+			regions[j], regions[j-1] = regions[j-1], regions[j]
+		}
+	}
+	n := 0
+	for _, r := range regions {
+		if n > 0 && regions[n-1].base+regions[n-1].n == r.base {
+			regions[n-1].n += r.n
+			continue
+		}
+		regions[n] = r
+		n++
+	}
+	return regions[:n]
+}
+
+// sysFreeBatcher collects regions to be freed and frees them with
+// sysFreeBatch once it has a full batch or when flushed.
+type sysFreeBatcher struct {
+	stat    *uint64
+	n       int
+	regions [16]memRange
+}
+
+// free arranges for [v, v+n) to be freed. The memory must not be used
+// afterwards, but it may not be released until the next flush.
+func (b *sysFreeBatcher) free(v unsafe.Pointer, n uintptr) {
+	b.regions[b.n] = memRange{uintptr(v), n}
+	b.n++
+	if b.n == len(b.regions) {
+		b.flush()
+	}
+}
+
+// flush frees all regions passed to free so far.
+func (b *sysFreeBatcher) flush() {
+	sysFreeBatch(b.regions[:b.n], b.stat)
+	b.n = 0
+}
+
+// PreallocHeap grows the heap by at least n bytes of free memory and,
+// where the operating system supports it (currently Linux), has the
+// kernel back that memory with physical pages immediately. Programs
+// with a warmup phase can call it to avoid paying for page faults
+// when the heap later grows into that memory. If the memory cannot be
+// backed up front, it is mapped lazily as usual.
+//
+// The new memory is free heap memory like any other: it counts towards
+// the heap's size but not towards the amount in use, and if it stays
+// unused long enough the scavenger may return it to the operating
+// system.
+func PreallocHeap(n uintptr) {
+	if n == 0 {
+		return
+	}
+	npage := n >> _PageShift
+	if n&(_PageSize-1) != 0 {
+		npage++
+	}
+	systemstack(func() {
+		lock(&mheap_.lock)
+		mheap_.populate = true
+		mheap_.grow(npage)
+		mheap_.populate = false
+		unlock(&mheap_.lock)
+	})
+}
+
 // OS-defined helpers:
 //
 // sysAlloc obtains a large chunk of zeroed memory from the
@@ -514,7 +714,10 @@ func (h *mheap) sysAlloc(n uintptr) unsafe.Pointer {
 			// is reserved and part is not.
 			// TODO: 如果一段内存一部分预留了一部分没有预留，会发生错误
 			var reserved bool
-			p := uintptr(sysReserve(unsafe.Pointer(h.arena_end), p_size, &reserved))
+			// If the new block cannot follow the current one,
+			// start it on a huge page boundary so sysUsed can
+			// back all of it with huge pages.
+			p := uintptr(sysReserveAligned(unsafe.Pointer(h.arena_end), p_size, sys.HugePageSize, &reserved))
 			if p == 0 {
 				// TODO: Try smaller reservation
 				// growths in case we're in a crowded
@@ -581,6 +784,9 @@ func (h *mheap) sysAlloc(n uintptr) unsafe.Pointer {
 				//
 				// 我们还没有统计这次分配，因此去掉它防止下溢
 				stat := uint64(p_size)
+				// Likewise, sysFree assumes the region
+				// was mapped, so count it as mapped.
+				addrSpaceAdd(0, p_size)
 				// 释放这段内存
 				sysFree(unsafe.Pointer(p), p_size, &stat)
 			}
@@ -592,7 +798,11 @@ func (h *mheap) sysAlloc(n uintptr) unsafe.Pointer {
 	if n <= h.arena_end-h.arena_alloc {
 		// Keep taking from our reservation.
 		p := h.arena_alloc
-		sysMap(unsafe.Pointer(p), n, h.arena_reserved, &memstats.heap_sys)
+		if h.populate {
+			sysMapPopulate(unsafe.Pointer(p), n, h.arena_reserved, &memstats.heap_sys)
+		} else {
+			sysMap(unsafe.Pointer(p), n, h.arena_reserved, &memstats.heap_sys)
+		}
 		h.arena_alloc += n
 		if h.arena_alloc > h.arena_used {
 			h.setArenaUsed(h.arena_alloc, true)
@@ -17,9 +17,11 @@ import (
 func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 	v, err := mmap(nil, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
 	if err != 0 {
+		mmapFailed(err)
 		return nil
 	}
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(n, n)
 	return v
 }
 
@@ -35,6 +37,7 @@ func sysUsed(v unsafe.Pointer, n uintptr) {
 //go:nosplit
 func sysFree(v unsafe.Pointer, n uintptr, sysStat *uint64) {
 	mSysStatDec(sysStat, n)
+	addrSpaceSub(n, n)
 	munmap(v, n)
 }
 
@@ -48,14 +51,17 @@ func sysReserve(v unsafe.Pointer, n uintptr, reserved *bool) unsafe.Pointer {
 	// and check the assumption in SysMap.
 	if sys.PtrSize == 8 && uint64(n) > 1<<32 || sys.GoosNacl != 0 {
 		*reserved = false
+		addrSpaceAdd(n, 0)
 		return v
 	}
 
 	p, err := mmap(v, n, _PROT_NONE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
 	if err != 0 {
+		mmapFailed(err)
 		return nil
 	}
 	*reserved = true
+	addrSpaceAdd(n, 0)
 	return p
 }
 
@@ -64,6 +70,7 @@ const _ENOMEM = 12
 
 func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(0, n)
 
 	// On 64-bit, we don't actually have v reserved, so tread carefully.
 	if !reserved {
@@ -77,6 +84,7 @@ func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 			flags |= _MAP_FIXED
 		}
 		p, err := mmap(v, n, _PROT_READ|_PROT_WRITE, flags, -1, 0)
+		mmapFailed(err)
 		if err == _ENOMEM || (GOOS == "solaris" && err == _sunosEAGAIN) {
 			throw("runtime: out of memory")
 		}
@@ -88,6 +96,7 @@ func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 	}
 
 	p, err := mmap(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_FIXED|_MAP_PRIVATE, -1, 0)
+	mmapFailed(err)
 	if err == _ENOMEM || (GOOS == "solaris" && err == _sunosEAGAIN) {
 		throw("runtime: out of memory")
 	}
@@ -0,0 +1,15 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+import "unsafe"
+
+// sysUnusedCold is like sysUnused. Only Linux can page memory out
+// eagerly.
+func sysUnusedCold(v unsafe.Pointer, n uintptr) {
+	sysUnused(v, n)
+}
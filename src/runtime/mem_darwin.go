@@ -12,9 +12,11 @@ import "unsafe"
 func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 	v, err := mmap(nil, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
 	if err != 0 {
+		mmapFailed(err)
 		return nil
 	}
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(n, n)
 	return v
 }
 
@@ -31,6 +33,7 @@ func sysUsed(v unsafe.Pointer, n uintptr) {
 //go:nosplit
 func sysFree(v unsafe.Pointer, n uintptr, sysStat *uint64) {
 	mSysStatDec(sysStat, n)
+	addrSpaceSub(n, n)
 	munmap(v, n)
 }
 
@@ -42,8 +45,10 @@ func sysReserve(v unsafe.Pointer, n uintptr, reserved *bool) unsafe.Pointer {
 	*reserved = true
 	p, err := mmap(v, n, _PROT_NONE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
 	if err != 0 {
+		mmapFailed(err)
 		return nil
 	}
+	addrSpaceAdd(n, 0)
 	return p
 }
 
@@ -53,7 +58,9 @@ const (
 
 func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(0, n)
 	p, err := mmap(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_FIXED|_MAP_PRIVATE, -1, 0)
+	mmapFailed(err)
 	if err == _ENOMEM {
 		throw("runtime: out of memory")
 	}
@@ -5,6 +5,7 @@
 package runtime
 
 import (
+	"runtime/internal/atomic"
 	"runtime/internal/sys"
 	"unsafe"
 )
@@ -31,6 +32,50 @@ const (
 // at a time. See golang.org/issue/7476.
 var addrspace_vec [1]byte
 
+// adviseUnused is the madvise flag sysUnused uses to release pages.
+// It starts as _MADV_DONTNEED and is upgraded to _MADV_FREE by
+// probeMadvFree if the kernel supports it (Linux 4.5 and later).
+var adviseUnused = uint32(_MADV_DONTNEED)
+
+// probeMadvFree checks whether the kernel accepts MADV_FREE by
+// advising a scratch page. Called from osinit, before any heap
+// pages have been released.
+func probeMadvFree() {
+	if physPageSize == 0 {
+		// Page size not known yet; stay with MADV_DONTNEED.
+		return
+	}
+	p, err := mmap(nil, physPageSize, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
+	if err != 0 {
+		return
+	}
+	if madvise(p, physPageSize, _MADV_FREE) == 0 {
+		atomic.Store(&adviseUnused, _MADV_FREE)
+	}
+	munmap(p, physPageSize)
+}
+
+// pageoutSupported is 1 if the kernel accepts MADV_PAGEOUT (Linux 5.4
+// and later). Rather than parse the kernel version, which may not
+// reflect backports, probeMadvPageout tries the advice directly.
+var pageoutSupported uint32
+
+// probeMadvPageout checks whether the kernel accepts MADV_PAGEOUT by
+// advising a scratch page. Called from osinit.
+func probeMadvPageout() {
+	if physPageSize == 0 {
+		return
+	}
+	p, err := mmap(nil, physPageSize, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
+	if err != 0 {
+		return
+	}
+	if madvise(p, physPageSize, _MADV_PAGEOUT) == 0 {
+		atomic.Store(&pageoutSupported, 1)
+	}
+	munmap(p, physPageSize)
+}
+
 func addrspace_free(v unsafe.Pointer, n uintptr) bool {
 	for off := uintptr(0); off < n; off += physPageSize {
 		// Use a length of 1 byte, which the kernel will round
@@ -70,6 +115,7 @@ func mmap_fixed(v unsafe.Pointer, n uintptr, prot, flags, fd int32, offset uint3
 func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 	p, err := mmap(nil, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
 	if err != 0 {
+		mmapFailed(err)
 		if err == _EACCES {
 			print("runtime: mmap: access denied\n")
 			exit(2)
@@ -81,10 +127,23 @@ func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 		return nil
 	}
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(n, n)
 	return p
 }
 
 func sysUnused(v unsafe.Pointer, n uintptr) {
+	sysUnusedAdvise(v, n, false)
+}
+
+// sysUnusedCold is like sysUnused, but if the kernel supports
+// MADV_PAGEOUT the pages are reclaimed right away instead of when
+// the kernel gets around to it, writing their contents to swap if
+// any is configured. Otherwise it behaves exactly like sysUnused.
+func sysUnusedCold(v unsafe.Pointer, n uintptr) {
+	sysUnusedAdvise(v, n, true)
+}
+
+func sysUnusedAdvise(v unsafe.Pointer, n uintptr, cold bool) {
 	// By default, Linux's "transparent huge page" support will
 	// merge pages into a huge page if there's even a single
 	// present regular page, undoing the effects of the DONTNEED
@@ -110,6 +169,9 @@ func sysUnused(v unsafe.Pointer, n uintptr) {
 	// gets most of the benefit of huge pages while keeping the
 	// number of VMAs under control. With hugePageSize = 2MB, even
 	// a pessimal heap can reach 128GB before running out of VMAs.
+	//
+	// This is done even if huge pages are disabled by
+	// SetHugePagePolicy, so khugepaged doesn't merge the pages back.
 	if sys.HugePageSize != 0 {
 		var s uintptr = sys.HugePageSize // division by constant 0 is a compile-time error :(
 
@@ -152,10 +214,36 @@ func sysUnused(v unsafe.Pointer, n uintptr) {
 		throw("unaligned sysUnused")
 	}
 
-	madvise(v, n, _MADV_DONTNEED)
+	// MADV_FREE lets the kernel reclaim the pages lazily, which is
+	// much cheaper than MADV_DONTNEED, but the pages may keep their
+	// old contents until they are actually reclaimed. That is fine:
+	// the heap never assumes released pages read as zero and tracks
+	// that separately with mspan.needzero.
+	var advise uint32
+	if cold && atomic.Load(&pageoutSupported) != 0 {
+		advise = _MADV_PAGEOUT
+	} else if debug.madvdontneed != 0 {
+		advise = _MADV_DONTNEED
+	} else {
+		advise = atomic.Load(&adviseUnused)
+	}
+	errno := madvise(v, n, int32(advise))
+	if errno != 0 && advise == _MADV_PAGEOUT {
+		// MADV_PAGEOUT fails if, for example, some of the pages
+		// are locked. Release them the usual way instead.
+		madvise(v, n, _MADV_DONTNEED)
+	} else if errno != 0 && advise == _MADV_FREE {
+		// MADV_FREE was rejected after all; fall back for good.
+		atomic.Store(&adviseUnused, _MADV_DONTNEED)
+		madvise(v, n, _MADV_DONTNEED)
+	}
 }
 
 func sysUsed(v unsafe.Pointer, n uintptr) {
+	if atomic.Load(&hugePagesOff) != 0 {
+		// Leave any NOHUGEPAGE marks from sysUnused in place.
+		return
+	}
 	if sys.HugePageSize != 0 {
 		// Partially undo the NOHUGEPAGE marks from sysUnused
 		// for whole huge pages between v and v+n. This may
@@ -183,6 +271,7 @@ func sysUsed(v unsafe.Pointer, n uintptr) {
 //go:nosplit
 func sysFree(v unsafe.Pointer, n uintptr, sysStat *uint64) {
 	mSysStatDec(sysStat, n)
+	addrSpaceSub(n, n)
 	munmap(v, n)
 }
 
@@ -211,29 +300,84 @@ func sysReserve(v unsafe.Pointer, n uintptr, reserved *bool) unsafe.Pointer {
 			if err == 0 {
 				munmap(p, 64<<10)
 			}
+			mmapFailed(err)
 			return nil
 		}
 		munmap(p, 64<<10)
 		*reserved = false
+		addrSpaceAdd(n, 0)
 		return v
 	}
 
 	p, err := mmap(v, n, _PROT_NONE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
 	if err != 0 {
+		mmapFailed(err)
 		return nil
 	}
 	*reserved = true
+	addrSpaceAdd(n, 0)
 	return p
 }
 
+// sysReserveAligned is like sysReserve, but if the reservation does
+// not start at v it starts at a multiple of align, which must be a
+// power of two. It reserves align bytes more than asked for and
+// releases the slack at either end. Aligning heap reservations to
+// sys.HugePageSize lets sysUsed mark every huge page in them with
+// MADV_HUGEPAGE, rather than leaving partial huge pages at the ends.
+// A reservation that lands at v is kept there, so that callers
+// extending the region right before v stay contiguous.
+func sysReserveAligned(v unsafe.Pointer, n, align uintptr, reserved *bool) unsafe.Pointer {
+	if align <= physPageSize || n+align < n {
+		return sysReserve(v, n, reserved)
+	}
+	p := sysReserve(v, n+align, reserved)
+	if p == nil {
+		return nil
+	}
+	start := uintptr(p)
+	if p != v {
+		start = round(start, align)
+	}
+	// If the reservation is only a check, there is nothing to
+	// release and the address space count is all that changes.
+	if *reserved {
+		if head := start - uintptr(p); head != 0 {
+			munmap(p, head)
+		}
+		if tail := uintptr(p) + align - start; tail != 0 {
+			munmap(unsafe.Pointer(start+n), tail)
+		}
+	}
+	addrSpaceSub(align, 0)
+	return unsafe.Pointer(start)
+}
+
 // 分配虚拟内存，没有分配物理内存。在第一次访问已分配的虚拟地址空间的时候，发生缺页中断，
 // 操作系统负责分配物理内存，然后建立虚拟内存和物理内存之间的映射关系。
 func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
+	sysMapFlags(v, n, reserved, sysStat, 0)
+}
+
+// sysMapPopulate is like sysMap, but asks the kernel to allocate
+// physical pages for the mapping up front so that the first touch
+// doesn't fault. If the kernel refuses, the memory is mapped lazily.
+func sysMapPopulate(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
+	sysMapFlags(v, n, reserved, sysStat, _MAP_POPULATE)
+}
+
+func sysMapFlags(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64, flags int32) {
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(0, n)
 
 	// On 64-bit, we don't actually have v reserved, so tread carefully.
 	if !reserved {
-		p, err := mmap_fixed(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
+		p, err := mmap_fixed(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE|flags, -1, 0)
+		if err == _ENOMEM && flags != 0 {
+			mmapFailed(err)
+			p, err = mmap_fixed(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
+		}
+		mmapFailed(err)
 		if err == _ENOMEM {
 			throw("runtime: out of memory")
 		}
@@ -244,7 +388,12 @@ func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 		return
 	}
 
-	p, err := mmap(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_FIXED|_MAP_PRIVATE, -1, 0)
+	p, err := mmap(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_FIXED|_MAP_PRIVATE|flags, -1, 0)
+	if err == _ENOMEM && flags != 0 {
+		mmapFailed(err)
+		p, err = mmap(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_FIXED|_MAP_PRIVATE, -1, 0)
+	}
+	mmapFailed(err)
 	if err == _ENOMEM {
 		throw("runtime: out of memory")
 	}
@@ -252,3 +401,42 @@ func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 		throw("runtime: cannot map pages in arena address space")
 	}
 }
+
+// sysLock locks the pages covering [v, v+n) into memory, faulting
+// them in first. It returns 0 on success or a non-zero errno, for
+// example if the lock would exceed RLIMIT_MEMLOCK.
+func sysLock(v unsafe.Pointer, n uintptr) int32 {
+	return mlock(v, n)
+}
+
+// sysUnlock undoes sysLock. Locks do not nest, so this also unlocks
+// pages that were locked for other reasons.
+func sysUnlock(v unsafe.Pointer, n uintptr) {
+	munlock(v, n)
+}
+
+// MarkWipeOnFork asks the kernel to present the n bytes starting at
+// ptr as zero-filled in any child created by fork, so that secrets
+// held there do not leak into the child, for example between the fork
+// and exec done by the syscall package. The parent's memory is not
+// affected. ptr and n must be multiples of the physical page size;
+// large heap allocations are suitably aligned. The advice stays with
+// the pages even after the memory is freed and reused by the runtime.
+//
+// MarkWipeOnFork requires Linux 4.14 or later. It returns an error,
+// and does nothing, if the range is unaligned or the kernel rejects
+// the advice. On other systems it always returns an error.
+func MarkWipeOnFork(ptr unsafe.Pointer, n uintptr) error {
+	if uintptr(ptr)&(physPageSize-1) != 0 || n&(physPageSize-1) != 0 {
+		// As in sysUnused, madvise would round out to
+		// every page the range touches.
+		return errorString("MarkWipeOnFork: range is not page aligned")
+	}
+	if n == 0 {
+		return nil
+	}
+	if madvise(ptr, n, _MADV_WIPEONFORK) != 0 {
+		return errorString("MarkWipeOnFork: madvise(MADV_WIPEONFORK) failed; it requires Linux 4.14 or later")
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+import "unsafe"
+
+// sysLock would lock the pages covering [v, v+n) into memory. Only
+// Linux supports this, so elsewhere it always fails.
+func sysLock(v unsafe.Pointer, n uintptr) int32 {
+	return -1
+}
+
+func sysUnlock(v unsafe.Pointer, n uintptr) {
+}
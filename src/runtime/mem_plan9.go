@@ -142,12 +142,14 @@ func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 	unlock(&memlock)
 	if p != nil {
 		mSysStatInc(sysStat, n)
+		addrSpaceAdd(n, n)
 	}
 	return p
 }
 
 func sysFree(v unsafe.Pointer, n uintptr, sysStat *uint64) {
 	mSysStatDec(sysStat, n)
+	addrSpaceSub(n, n)
 	lock(&memlock)
 	memFree(v, n)
 	memCheck()
@@ -164,6 +166,7 @@ func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 	// sysReserve has already allocated all heap memory,
 	// but has not adjusted stats.
 	mSysStatInc(sysStat, n)
+	addrSpaceAdd(0, n)
 }
 
 func sysFault(v unsafe.Pointer, n uintptr) {
@@ -175,5 +178,8 @@ func sysReserve(v unsafe.Pointer, n uintptr, reserved *bool) unsafe.Pointer {
 	p := memAlloc(n)
 	memCheck()
 	unlock(&memlock)
+	if p != nil {
+		addrSpaceAdd(n, 0)
+	}
 	return p
 }
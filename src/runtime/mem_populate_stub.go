@@ -0,0 +1,15 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+import "unsafe"
+
+// sysMapPopulate is like sysMap. Only Linux can populate a mapping
+// up front.
+func sysMapPopulate(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
+	sysMap(v, n, reserved, sysStat)
+}
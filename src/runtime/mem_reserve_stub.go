@@ -0,0 +1,16 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+import "unsafe"
+
+// sysReserveAligned is like sysReserve. Only Linux backs the heap with
+// huge pages on request, so other systems ignore align and callers
+// must not rely on it.
+func sysReserveAligned(v unsafe.Pointer, n, align uintptr, reserved *bool) unsafe.Pointer {
+	return sysReserve(v, n, reserved)
+}
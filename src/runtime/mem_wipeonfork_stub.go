@@ -0,0 +1,16 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+import "unsafe"
+
+// MarkWipeOnFork asks the kernel to present the n bytes starting at
+// ptr as zero-filled in any child created by fork. Only Linux supports
+// this, so elsewhere it always returns an error.
+func MarkWipeOnFork(ptr unsafe.Pointer, n uintptr) error {
+	return errorString("MarkWipeOnFork: not supported on this system")
+}
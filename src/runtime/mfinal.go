@@ -35,6 +35,7 @@ var finc *finblock // cache of free blocks
 var finptrmask [_FinBlockSize / sys.PtrSize / 8]byte
 var fingwait bool
 var fingwake bool
+var finpaused uint32 // set by PauseFinalizers; read atomically
 var allfin *finblock // list of all blocks
 
 // NOTE: Layout known to queuefinalizer.
@@ -145,6 +146,30 @@ func wakefing() *g {
 	return res
 }
 
+// PauseFinalizers stops (pause is true) or resumes (pause is false)
+// the running of finalizers. While paused, finalizers of unreachable
+// objects are queued but not run, so that a latency-critical section
+// of the program does not compete with them for processor time;
+// finalizers that had already started running may finish. Resuming
+// runs the queued finalizers.
+//
+// Objects with finalizers, and everything they reference, are not
+// freed until their finalizers have run, so pausing for too long
+// delays reclaiming that memory and any resources, such as file
+// descriptors, that the finalizers would release.
+func PauseFinalizers(pause bool) {
+	if pause {
+		atomic.Store(&finpaused, 1)
+		return
+	}
+	atomic.Store(&finpaused, 0)
+	// Don't wait for findrunnable, which a busy program may
+	// rarely reach.
+	if gp := wakefing(); gp != nil {
+		goready(gp, 0)
+	}
+}
+
 var (
 	fingCreate  uint32
 	fingRunning bool
@@ -167,7 +192,14 @@ func runfinq() {
 	for {
 		lock(&finlock)
 		fb := finq
-		finq = nil
+		if fb != nil && atomic.Load(&finpaused) != 0 {
+			// Leave the queue for PauseFinalizers(false)
+			// to wake us for.
+			fb = nil
+			fingwake = true
+		} else {
+			finq = nil
+		}
 		if fb == nil {
 			gp := getg()
 			fing = gp
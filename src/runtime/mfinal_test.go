@@ -107,6 +107,36 @@ func TestFinalizerInterfaceBig(t *testing.T) {
 	}
 }
 
+func TestPauseFinalizers(t *testing.T) {
+	runtime.PauseFinalizers(true)
+	defer runtime.PauseFinalizers(false)
+
+	ch := make(chan bool, 1)
+	done := make(chan bool, 1)
+	go func() {
+		v := new(bigValue)
+		runtime.SetFinalizer(v, func(*bigValue) {
+			ch <- true
+		})
+		v = nil
+		done <- true
+	}()
+	<-done
+	runtime.GC()
+	select {
+	case <-ch:
+		t.Fatalf("finalizer ran while finalizers were paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	runtime.PauseFinalizers(false)
+	select {
+	case <-ch:
+	case <-time.After(4 * time.Second):
+		t.Errorf("finalizer didn't run after finalizers were resumed")
+	}
+}
+
 func fin(v *int) {
 }
 
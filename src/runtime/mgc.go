@@ -1492,6 +1492,9 @@ func gcStart(mode gcMode, trigger gcTrigger) {
 	now := nanotime()
 	work.tSweepTerm = now
 	work.pauseStart = now
+	// Stacks are scanned during concurrent mark, before gcMark
+	// sets tstart, so set it here for markroot to stamp waitsince.
+	work.tstart = now
 	if trace.enabled {
 		traceGCSTWStart(1)
 	}
@@ -242,13 +242,13 @@ func (root *mTreap) removeSpan(span *mspan) {
 
 // scavengetreap visits each node in the treap and scavenges the
 // treapNode's span.
-func scavengetreap(treap *treapNode, now, limit uint64) uintptr {
+func scavengetreap(treap *treapNode, now, limit uint64, release func(unsafe.Pointer, uintptr)) uintptr {
 	if treap == nil {
 		return 0
 	}
-	return scavengeTreapNode(treap, now, limit) +
-		scavengetreap(treap.left, now, limit) +
-		scavengetreap(treap.right, now, limit)
+	return scavengeTreapNode(treap, now, limit, release) +
+		scavengetreap(treap.left, now, limit, release) +
+		scavengetreap(treap.right, now, limit, release)
 }
 
 // rotateLeft rotates the tree rooted at node x.
@@ -146,6 +146,10 @@ type mheap struct {
 	// here and *must* clobber it to use it.
 	arena_reserved bool
 
+	// populate indicates that sysAlloc should populate the
+	// mappings it makes for the arena; see PreallocHeap.
+	populate bool
+
 	_ uint32 // ensure 64-bit alignment
 
 	// central free lists for small size classes.
@@ -1165,7 +1169,7 @@ func (h *mheap) busyList(npages uintptr) *mSpanList {
 	return &h.busylarge
 }
 
-func scavengeTreapNode(t *treapNode, now, limit uint64) uintptr {
+func scavengeTreapNode(t *treapNode, now, limit uint64, release func(unsafe.Pointer, uintptr)) uintptr {
 	s := t.spanKey
 	var sumreleased uintptr
 	if (now-uint64(s.unusedsince)) > limit && s.npreleased != s.npages {
@@ -1193,12 +1197,12 @@ func scavengeTreapNode(t *treapNode, now, limit uint64) uintptr {
 		memstats.heap_released += uint64(released)
 		sumreleased += released
 		s.npreleased = len >> _PageShift
-		sysUnused(unsafe.Pointer(start), len)
+		release(unsafe.Pointer(start), len)
 	}
 	return sumreleased
 }
 
-func scavengelist(list *mSpanList, now, limit uint64) uintptr {
+func scavengelist(list *mSpanList, now, limit uint64, release func(unsafe.Pointer, uintptr)) uintptr {
 	if list.isEmpty() {
 		return 0
 	}
@@ -1233,12 +1237,14 @@ func scavengelist(list *mSpanList, now, limit uint64) uintptr {
 		memstats.heap_released += uint64(released)
 		sumreleased += released
 		s.npreleased = len >> _PageShift
-		sysUnused(unsafe.Pointer(start), len)
+		release(unsafe.Pointer(start), len)
 	}
 	return sumreleased
 }
 
-func (h *mheap) scavenge(k int32, now, limit uint64) {
+// scavenge returns free spans that have been unused for longer than
+// limit to the OS, using release to do so.
+func (h *mheap) scavenge(k int32, now, limit uint64, release func(unsafe.Pointer, uintptr)) {
 	// Disallow malloc or panic while holding the heap lock. We do
 	// this here because this is an non-mallocgc entry-point to
 	// the mheap API.
@@ -1247,9 +1253,9 @@ func (h *mheap) scavenge(k int32, now, limit uint64) {
 	lock(&h.lock)
 	var sumreleased uintptr
 	for i := 0; i < len(h.free); i++ {
-		sumreleased += scavengelist(&h.free[i], now, limit)
+		sumreleased += scavengelist(&h.free[i], now, limit, release)
 	}
-	sumreleased += scavengetreap(h.freelarge.treap, now, limit)
+	sumreleased += scavengetreap(h.freelarge.treap, now, limit, release)
 	unlock(&h.lock)
 	gp.m.mallocing--
 
@@ -1264,7 +1270,21 @@ func (h *mheap) scavenge(k int32, now, limit uint64) {
 //go:linkname runtime_debug_freeOSMemory runtime/debug.freeOSMemory
 func runtime_debug_freeOSMemory() {
 	GC()
-	systemstack(func() { mheap_.scavenge(-1, ^uint64(0), 0) })
+	systemstack(func() { mheap_.scavenge(-1, ^uint64(0), 0, sysUnused) })
+}
+
+// ScavengeColdPages returns all currently free heap memory to the
+// operating system without running a garbage collection. On Linux 5.4
+// and later it uses MADV_PAGEOUT, which makes the kernel reclaim the
+// pages immediately, writing their contents to swap if swap is
+// configured, rather than when it comes under memory pressure.
+// Elsewhere, or without kernel support, it releases memory the same
+// way the runtime's background scavenger does.
+//
+// Like debug.FreeOSMemory, it only affects memory the heap is not
+// using; call runtime.GC first to make more memory free.
+func ScavengeColdPages() {
+	systemstack(func() { mheap_.scavenge(-1, ^uint64(0), 0, sysUnusedCold) })
 }
 
 // Initialize a new span with the given start and npages.
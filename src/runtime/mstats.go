@@ -667,6 +667,49 @@ func purgecachedstats(c *mcache) {
 	}
 }
 
+// sysStats names the system memory stats that callers pass as sysStat
+// to sysAlloc, sysMap, sysFree and persistentalloc, for MappingStats.
+var sysStats = [...]struct {
+	name string
+	stat *uint64
+}{
+	{"heap", &memstats.heap_sys},
+	{"stacks", &memstats.stacks_sys},
+	{"mspan", &memstats.mspan_sys},
+	{"mcache", &memstats.mcache_sys},
+	{"buckhash", &memstats.buckhash_sys},
+	{"gc", &memstats.gc_sys},
+	{"other", &memstats.other_sys},
+}
+
+// MappingStats returns how many bytes of memory the runtime currently
+// has mapped from the operating system for each of its subsystems:
+// "heap" for the garbage-collected heap, "stacks" for goroutine and
+// thread stacks, "mspan" and "mcache" for the allocator's own
+// structures, "buckhash" for the profiling hash table, "gc" for garbage
+// collector metadata and "other" for everything else. The values sum to
+// MemStats.Sys. As in MemStats, stacks allocated from the heap count
+// under "stacks" rather than "heap", and heap memory that has been
+// returned to the operating system is still counted.
+func MappingStats() map[string]uint64 {
+	var vals [len(sysStats)]uint64
+	systemstack(func() {
+		lock(&mheap_.lock)
+		for i := range sysStats {
+			vals[i] = atomic.Load64(sysStats[i].stat)
+			if sysStats[i].stat == &memstats.stacks_sys {
+				vals[i] += memstats.stacks_inuse
+			}
+		}
+		unlock(&mheap_.lock)
+	})
+	m := make(map[string]uint64, len(vals))
+	for i, v := range vals {
+		m[sysStats[i].name] = v
+	}
+	return m
+}
+
 // Atomically increases a given *system* memory stat. We are counting on this
 // stat never overflowing a uintptr, so this function must only be used for
 // system memory stats.
@@ -318,10 +318,14 @@ func netpollready(gpp *guintptr, pd *pollDesc, mode int32) {
 	if rg != 0 {
 		rg.ptr().schedlink = *gpp
 		*gpp = rg
+		// Balance the count taken in netpollblockcommit, as
+		// netpollgoready does for timeouts and closes.
+		atomic.Xadd(&netpollWaiters, -1)
 	}
 	if wg != 0 {
 		wg.ptr().schedlink = *gpp
 		*gpp = wg
+		atomic.Xadd(&netpollWaiters, -1)
 	}
 }
 
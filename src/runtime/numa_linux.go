@@ -0,0 +1,137 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// NUMA topology for node-aware work stealing.
+//
+// The runtime does not bind Ps or Ms to CPUs, so a P has no fixed
+// node. Instead each M caches the node of the CPU it runs on, as
+// reported by getcpu, and a P takes the node of the M holding it.
+// An M looks its node up again only once sysmon has started a new
+// numaEpoch, every numaRefreshPeriod, to follow threads the kernel
+// migrates; the scheduler's hot paths make no system calls. The node
+// is only a recent observation, which is enough to keep stealing
+// mostly within a socket.
+
+// numaRefreshPeriod is how often sysmon has Ms look up their node.
+const numaRefreshPeriod = 1e9 // 1s
+
+var (
+	numaNodes  int32 // number of online nodes; 0 if unknown
+	numaInited bool  // numaInit has run
+
+	// numaEpoch is advanced by sysmon every numaRefreshPeriod; an M
+	// whose m.numaEpoch differs looks up its node again. It starts
+	// at 1 so that new Ms look theirs up. Updated atomically.
+	numaEpoch     uint32 = 1
+	numaLastEpoch int64  // nanotime of the last advance; only used by sysmon
+
+	numaOnline = []byte("/sys/devices/system/node/online\x00")
+	numaBuf    [256]byte // scratch buffer; only used by numaInit
+)
+
+// numaInit reads the number of online NUMA nodes from sysfs. It is
+// called by procNUMAInit with the world stopped, and uses a static
+// buffer so that it does not allocate.
+func numaInit() {
+	fd := open(&numaOnline[0], 0 /* O_RDONLY */, 0)
+	if fd < 0 {
+		return
+	}
+	n := read(fd, unsafe.Pointer(&numaBuf[0]), int32(len(numaBuf)))
+	closefd(fd)
+	if n <= 0 {
+		return
+	}
+	numaParseList(numaBuf[:n], func(lo, hi int) {
+		if hi >= lo {
+			numaNodes += int32(hi - lo + 1)
+		}
+	})
+}
+
+// numaParseList parses a sysfs list such as "0-3,8-11\n" and calls
+// set for each range. It stops at the first malformed entry.
+func numaParseList(b []byte, set func(lo, hi int)) {
+	for len(b) > 0 && b[0] != '\n' {
+		lo, rest, ok := numaAtoi(b)
+		if !ok {
+			return
+		}
+		hi := lo
+		if len(rest) > 0 && rest[0] == '-' {
+			hi, rest, ok = numaAtoi(rest[1:])
+			if !ok {
+				return
+			}
+		}
+		set(lo, hi)
+		if len(rest) > 0 && rest[0] == ',' {
+			rest = rest[1:]
+		}
+		b = rest
+	}
+}
+
+func numaAtoi(b []byte) (n int, rest []byte, ok bool) {
+	i := 0
+	for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+		n = n*10 + int(b[i]-'0')
+		if n > 1<<20 {
+			return 0, nil, false
+		}
+	}
+	return n, b[i:], i > 0
+}
+
+// procNUMAInit reads the NUMA topology the first time it is called.
+// procresize only calls it when there is more than one P, so programs
+// that never steal work don't read sysfs at all.
+func procNUMAInit() {
+	if !numaInited {
+		numaInited = true
+		numaInit()
+	}
+}
+
+// procNUMAUpdate gives _p_, the P of mp, the NUMA node of mp, first
+// looking up the node of the CPU mp runs on if sysmon has started a
+// new numaEpoch since mp last did. It must run on mp and does nothing
+// on a machine with a single node.
+func procNUMAUpdate(mp *m, _p_ *p) {
+	if numaNodes < 2 {
+		return
+	}
+	if epoch := atomic.Load(&numaEpoch); mp.numaEpoch != epoch {
+		mp.numaEpoch = epoch
+		var cpu, node uint32
+		if getcpu(&cpu, &node, 0) < 0 {
+			mp.numaNode = -1
+		} else {
+			mp.numaNode = int32(node)
+		}
+	}
+	if _p_.numaNode != mp.numaNode {
+		_p_.numaNode = mp.numaNode
+		lock(&sched.lock)
+		stealOrder.setNode(_p_.id, mp.numaNode)
+		unlock(&sched.lock)
+	}
+}
+
+// procNUMATick is called by sysmon with the current time and starts a
+// new numaEpoch every numaRefreshPeriod.
+func procNUMATick(now int64) {
+	if numaNodes < 2 || now-numaLastEpoch < numaRefreshPeriod {
+		return
+	}
+	numaLastEpoch = now
+	atomic.Xadd(&numaEpoch, 1)
+}
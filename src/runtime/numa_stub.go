@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+// NUMA topology is only discovered on Linux. Elsewhere every P's node
+// stays unknown and stealing uses the plain randomized order.
+
+const numaNodes = 0
+
+func procNUMAInit() {}
+
+func procNUMAUpdate(mp *m, _p_ *p) {}
+
+func procNUMATick(now int64) {}
@@ -281,6 +281,8 @@ func sysauxv(auxv []uintptr) int {
 // 获取cpu的数量
 func osinit() {
 	ncpu = getproccount()
+	probeMadvFree()
+	probeMadvPageout()
 }
 
 var urandom_dev = []byte("/dev/urandom\x00")
@@ -397,6 +399,15 @@ func raiseproc(sig uint32)
 
 //go:noescape
 func sched_getaffinity(pid, len uintptr, buf *byte) int32
+
+// getcpu stores the CPU and NUMA node the calling thread is running on.
+// It returns a negative value on failure.
+//go:noescape
+func getcpu(cpu, node *uint32, cache uintptr) int32
+
+// mlock and munlock return 0 on success or a non-zero errno on failure.
+func mlock(addr unsafe.Pointer, n uintptr) int32
+func munlock(addr unsafe.Pointer, n uintptr) int32
 func osyield()
 
 //go:nosplit
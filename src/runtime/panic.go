@@ -215,6 +215,13 @@ func newdefer(siz int32) *_defer {
 			pp.deferpool[sc][n-1] = nil
 			pp.deferpool[sc] = pp.deferpool[sc][:n-1]
 		}
+		if debug.deferpoolstats != 0 {
+			if d != nil {
+				pp.deferhits[sc]++
+			} else {
+				pp.defermisses[sc]++
+			}
+		}
 	}
 	if d == nil {
 		// Allocate new defer+args.
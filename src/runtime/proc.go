@@ -185,6 +185,10 @@ func main() {
 	} else {
 		maxstacksize = 250000000
 	}
+	// GODEBUG=maxstackmb=N may only lower the limit.
+	if mb := debug.maxstackmb; mb > 0 && uintptr(mb) < maxstacksize/1000000 {
+		maxstacksize = uintptr(mb) * 1000000
+	}
 
 	// Allow newproc to start new Ms.
 	// 允许 newproc 启动 Ms
@@ -294,6 +298,9 @@ func main() {
 			Gosched()
 		}
 	}
+	if fn := (*func())(atomic.Loadp(unsafe.Pointer(&finalPanicHook))); fn != nil {
+		(*fn)()
+	}
 	if atomic.Load(&panicking) != 0 {
 		gopark(nil, nil, "panicwait", traceEvGoStop, 1)
 	}
@@ -308,6 +315,32 @@ func main() {
 	}
 }
 
+// finalPanicHook, if non-nil, points to the function set by
+// SetFinalPanicHook. Accessed atomically.
+var finalPanicHook unsafe.Pointer // *func()
+
+// SetFinalPanicHook arranges for fn to be called on the main goroutine
+// after main.main returns, just before the program exits. If another
+// goroutine is panicking at that point, fn is called before main waits
+// for that panic to finish printing and kill the program, so it is a
+// last chance to flush logs or metrics from a program about to die of
+// a concurrent panic. Passing nil removes the hook.
+//
+// fn is not called if the program exits any other way, such as through
+// os.Exit or an unrecovered panic on the main goroutine. Because fn may
+// run while another goroutine is panicking, it should do as little as
+// possible: blocking on state the panicking goroutine holds would keep
+// the program from exiting, and a panic in fn itself ends the program
+// with that panic.
+func SetFinalPanicHook(fn func()) {
+	var p *func()
+	if fn != nil {
+		p = new(func())
+		*p = fn
+	}
+	atomicstorep(unsafe.Pointer(&finalPanicHook), unsafe.Pointer(p))
+}
+
 // os_beforeExit is called from os.Exit(0).
 //go:linkname os_beforeExit os.runtime_beforeExit
 // 由 os.Exit(0) 函数调用，会被编译器链接成 os.runtime_beforeExit
@@ -322,6 +355,9 @@ func os_beforeExit() {
 // 用来执行强制gc
 func init() {
 	go forcegchelper()
+	if debug.syscallmpool > 0 {
+		mpool.h.start("m pool (idle)", mpoolWork)
+	}
 }
 
 // 用来执行强制gc
@@ -334,15 +370,44 @@ func forcegchelper() {
 		}
 		atomic.Store(&forcegc.idle, 1)
 		goparkunlock(&forcegc.lock, "force gc (idle)", traceEvGoBlock, 1)
-		// this goroutine is explicitly resumed by sysmon
+		// this goroutine is explicitly resumed by sysmon or ForceGCNow
 		if debug.gctrace > 0 {
 			println("GC forced")
 		}
+		lock(&forcegc.lock)
+		now := forcegc.now
+		forcegc.now = false
+		unlock(&forcegc.lock)
+		if now {
+			// Start the next cycle unless one has started since.
+			gcStart(gcBackgroundMode, gcTrigger{kind: gcTriggerCycle, n: atomic.Load(&work.cycles) + 1})
+			continue
+		}
 		// Time-triggered, fully concurrent.
 		gcStart(gcBackgroundMode, gcTrigger{kind: gcTriggerTime, now: nanotime()})
 	}
 }
 
+// ForceGCNow starts a concurrent garbage collection cycle in the
+// background, the same way the runtime does when no collection has
+// happened for two minutes, and returns without waiting for it.
+// Unlike GC, it does not block the caller until the cycle and sweeping
+// are complete. If a forced collection is already pending, or a cycle
+// starts before the forced one gets going, ForceGCNow has no effect.
+func ForceGCNow() {
+	if atomic.Load(&forcegc.idle) == 0 {
+		return
+	}
+	lock(&forcegc.lock)
+	if forcegc.idle != 0 {
+		forcegc.idle = 0
+		forcegc.now = true
+		forcegc.g.schedlink = 0
+		injectglist(forcegc.g)
+	}
+	unlock(&forcegc.lock)
+}
+
 //go:nosplit
 
 // Gosched yields the processor, allowing other goroutines to run. It does not
@@ -363,6 +428,44 @@ func goschedguarded() {
 	mcall(goschedguarded_m)
 }
 
+// YieldN yields the processor up to n times, for use in spin-wait
+// loops. Unlike Gosched, which moves the goroutine to the global run
+// queue, each yield puts it at the back of its processor's local run
+// queue, so it runs again as soon as the goroutines already queued
+// there have had a turn, even if it has a priority (see
+// SetGoroutinePriority). If there is nothing else queued locally,
+// yielding locally cannot let anything make progress, so YieldN
+// falls back to a single Gosched and returns.
+//
+// Like goschedguarded, YieldN does not yield while the runtime is in
+// a state where rescheduling is forbidden.
+func YieldN(n int) {
+	for ; n > 0; n-- {
+		mp := acquirem()
+		empty := runqempty(mp.p.ptr())
+		releasem(mp)
+		if empty {
+			Gosched()
+			return
+		}
+		mcall(goyieldlocal_m)
+	}
+}
+
+// PreemptionPoint yields the processor if the scheduler has asked the
+// calling goroutine to stop running, as sysmon does for goroutines
+// that have run too long. Preemption requests are normally noticed
+// only in function prologues, so a loop that makes no calls can hold
+// its processor indefinitely; calling PreemptionPoint in such a loop
+// lets it be preempted like any other goroutine. When no preemption
+// is pending it costs a single comparison.
+//go:nosplit
+func PreemptionPoint() {
+	if getg().stackguard0 == stackPreempt {
+		mcall(preemptpoint_m)
+	}
+}
+
 // Puts the current goroutine into a waiting state and calls unlockf.
 // If unlockf returns false, the goroutine is resumed.
 // unlockf must not access this G's stack, as it may be moved between
@@ -424,8 +527,9 @@ func acquireSudog() *sudog {
 	pp := mp.p.ptr()
 	if len(pp.sudogcache) == 0 {
 		lock(&sched.sudoglock)
-		// First, try to grab a batch from central cache.
-		for len(pp.sudogcache) < cap(pp.sudogcache)/2 && sched.sudogcache != nil {
+		// First, try to grab a batch from central cache. Round
+		// up so that a cache of one still takes from it.
+		for len(pp.sudogcache) < (cap(pp.sudogcache)+1)/2 && sched.sudogcache != nil {
 			s := sched.sudogcache
 			sched.sudogcache = s.next
 			s.next = nil
@@ -571,6 +675,7 @@ func badctxt() {
 	throw("ctxt != 0")
 }
 
+//go:nosplit
 func lockedOSThread() bool {
 	gp := getg()
 	return gp.lockedm != 0 && gp.m.lockedg != 0
@@ -594,12 +699,47 @@ func allgadd(gp *g) {
 	unlock(&allglock)
 }
 
+// schedGlobalEvery is how often, in schedticks, schedule checks the
+// global run queue before the local one to ensure fairness.
+// It is set from GODEBUG=schedglobalevery in schedinit and never
+// changes afterwards.
+var schedGlobalEvery uint32 = 61
+
+
+// globalSampleChecks and globalSampleHits count how often schedule
+// reached its periodic global run queue check and how often that
+// found a goroutine; see GlobalQueueSamplingStats. Updated atomically.
+var globalSampleChecks, globalSampleHits uint64
+
+// stealAttempts is how many passes findrunnable makes over the other
+// Ps looking for work to steal. It is set from GODEBUG=stealattempts
+// in schedinit and never changes afterwards.
+var stealAttempts = 4
+
+// stealNextBackoff is how many microseconds runqgrab waits before
+// stealing a running P's runnext goroutine. It is set from
+// GODEBUG=stealnextbackoffus in schedinit and never changes afterwards.
+var stealNextBackoff uint32 = 3
+
+// sudogCacheSize is the capacity of each P's sudog cache. It is set
+// from GODEBUG=sudogcache in schedinit and never changes afterwards.
+var sudogCacheSize = 128
+
+// maxSudogCache bounds GODEBUG=sudogcache. Each P allocates its cache
+// up front, so a huge value would exhaust memory before main runs.
+const maxSudogCache = 1 << 12
+
 const (
 	// Number of goroutine ids to grab from sched.goidgen to local per-P cache at once.
 	// 16 seems to provide enough amortization, but other than that it's mostly arbitrary number.
 	_GoidCacheBatch = 16
 )
 
+// goidCacheBatch is the number of goroutine ids each P takes from
+// sched.goidgen at once. It is set from GODEBUG=goidcachebatch in
+// schedinit and never changes afterwards.
+var goidCacheBatch uint64 = _GoidCacheBatch
+
 // The bootstrap sequence is:
 //
 //	call osinit
@@ -649,6 +789,27 @@ func schedinit() {
 	// 处理一些用于debug的参数
 	// 如： GODEBUG=schedtrace=1000
 	parsedebugvars()
+	if debug.schedglobalevery < 1 {
+		debug.schedglobalevery = 1
+	}
+	schedGlobalEvery = uint32(debug.schedglobalevery)
+	if debug.stealattempts < 1 {
+		debug.stealattempts = 1
+	}
+	stealAttempts = int(debug.stealattempts)
+	if debug.stealnextbackoffus < 0 {
+		debug.stealnextbackoffus = 0
+	}
+	stealNextBackoff = uint32(debug.stealnextbackoffus)
+	sudogCacheSize = int(debug.sudogcache)
+	if debug.goidcachebatch < 1 {
+		debug.goidcachebatch = 1
+	}
+	goidCacheBatch = uint64(debug.goidcachebatch)
+	if debug.schedrandom != 0 {
+		randomizeScheduler = true
+	}
+	netpollOff = debug.netpolloff != 0
 
 	// gc初始化
 	gcinit()
@@ -692,12 +853,48 @@ func dumpgstatus(gp *g) {
 
 func checkmcount() {
 	// sched lock is held
-	if mcount() > sched.maxmcount {
+	if n := mcount(); n > sched.maxmcount {
+		if fn := threadLimitCallback; fn != nil && n <= threadLimitCeiling() && fn(n) {
+			return
+		}
 		print("runtime: program exceeds ", sched.maxmcount, "-thread limit\n")
 		throw("thread exhaustion")
 	}
 }
 
+// threadLimitCallback is the callback installed by
+// SetThreadLimitCallback. Protected by sched.lock.
+var threadLimitCallback func(current int32) (allow bool)
+
+// threadLimitCeiling returns the number of Ms beyond which
+// threadLimitCallback is no longer consulted. sched.lock must be held.
+func threadLimitCeiling() int32 {
+	if sched.maxmcount > 0x7fffffff/2 {
+		return 0x7fffffff
+	}
+	return 2 * sched.maxmcount
+}
+
+// SetThreadLimitCallback arranges for fn to be called when the program
+// exceeds the thread limit set by debug.SetMaxThreads, instead of
+// crashing right away. fn is passed the current number of threads; if
+// it returns true, the new thread is allowed, otherwise the program
+// crashes as before. Passing nil restores the default behavior.
+//
+// fn is consulted for every thread created over the limit, up to an
+// absolute ceiling of twice the limit. Past the ceiling the program
+// crashes regardless of fn.
+//
+// fn is called with the scheduler lock held, possibly on a thread that
+// cannot run ordinary Go code. It must not block, allocate, or call
+// into the runtime; recording current atomically and returning is safe.
+// Shedding load in response should happen on another goroutine.
+func SetThreadLimitCallback(fn func(current int32) (allow bool)) {
+	lock(&sched.lock)
+	threadLimitCallback = fn
+	unlock(&sched.lock)
+}
+
 func mcommoninit(mp *m) {
 	_g_ := getg()
 
@@ -712,6 +909,9 @@ func mcommoninit(mp *m) {
 	}
 	mp.id = sched.mnext
 	sched.mnext++
+	if n := mcount(); n > peakmcount {
+		peakmcount = n
+	}
 	checkmcount()
 
 	mp.fastrand[0] = 1597334677 * uint32(mp.id)
@@ -732,6 +932,17 @@ func mcommoninit(mp *m) {
 	// NumCgoCall() iterates over allm w/o schedlock,
 	// so we need to publish it safely.
 	atomicstorep(unsafe.Pointer(&allm), unsafe.Pointer(mp))
+
+	// mcommoninit may run without a P or on the system stack, so the
+	// thread creation hook can't be called here. Flag it for sysmon,
+	// which will wake threadCreate's helper to report the new M.
+	if atomic.Load(&threadCreate.h.enabled) != 0 {
+		threadCreate.h.notify()
+		if sched.sysmonwait != 0 {
+			sched.sysmonwait = 0
+			notewakeup(&sched.sysmonnote)
+		}
+	}
 	unlock(&sched.lock)
 
 	// Allocate memory to hold a cgo traceback if the cgo call crashes.
@@ -740,6 +951,595 @@ func mcommoninit(mp *m) {
 	}
 }
 
+// A hookHelper is a goroutine that runs Go code on behalf of parts of
+// the runtime that cannot, such as the scheduler, newstack or sysmon,
+// most often to deliver events they recorded to a hook installed by
+// the program. The helper parks until notify is called; sysmon then
+// wakes it, as it does forcegchelper, and it calls work.
+//
+// Helpers are system goroutines: they are not counted by NumGoroutine
+// and are hidden from tracebacks.
+type hookHelper struct {
+	lock    mutex
+	g       *g
+	reason  string // wait reason while parked
+	work    func()
+	started bool   // hookHelperMain has been started; protected by lock
+	enabled uint32 // a hook is installed
+	pending uint32 // notify was called since sysmon last woke the helper
+	idle    uint32 // the helper is parked
+}
+
+// hookHelpers lists the helpers sysmon wakes.
+var hookHelpers = [...]*hookHelper{
+	&threadCreate.h,
+	&preemptHook.h,
+	&mParkHook.h,
+	&gstatusHook.h,
+	&stackGrowthHook.h,
+	&syscallRetakeHook.h,
+	&mpool.h,
+	&idleCallback.h,
+	&goexitHook.h,
+	&extraMPool.h,
+}
+
+// start starts the helper goroutine of h, which will call work each
+// time it is woken.
+func (h *hookHelper) start(reason string, work func()) {
+	h.reason = reason
+	h.work = work
+	go hookHelperMain(h)
+}
+
+// install records whether a hook is installed in h, starting the
+// helper the first time one is. The caller must hold h.lock, which
+// install releases, and have installed or removed the hook under it.
+func (h *hookHelper) install(on bool, reason string, work func()) {
+	if on {
+		atomic.Store(&h.enabled, 1)
+	} else {
+		atomic.Store(&h.enabled, 0)
+	}
+	start := on && !h.started
+	if start {
+		h.started = true
+	}
+	if raceenabled {
+		// Let the race detector see that the hook is published to
+		// the helper; see the raceacquire in lockHooks.
+		racereleasemerge(unsafe.Pointer(h))
+	}
+	unlock(&h.lock)
+	if start {
+		h.start(reason, work)
+	}
+}
+
+// lockHooks locks h so that the helper can read the hooks installed
+// with install.
+func (h *hookHelper) lockHooks() {
+	lock(&h.lock)
+	if raceenabled {
+		raceacquire(unsafe.Pointer(h))
+	}
+}
+
+// notify tells sysmon that the helper has work to do. It may be called
+// without a P and from casgstatus, so it must not split the stack.
+//go:nosplit
+func (h *hookHelper) notify() {
+	if atomic.Load(&h.pending) == 0 {
+		atomic.Store(&h.pending, 1)
+	}
+}
+
+// due reports whether the helper is parked with work to do.
+func (h *hookHelper) due() bool {
+	return atomic.Load(&h.pending) != 0 && atomic.Load(&h.idle) != 0
+}
+
+// wake readies the helper if it is parked. It is called by sysmon.
+//go:nowritebarrierrec
+func (h *hookHelper) wake() {
+	lock(&h.lock)
+	if h.idle != 0 {
+		h.idle = 0
+		atomic.Store(&h.pending, 0)
+		h.g.schedlink = 0
+		injectglist(h.g)
+	}
+	unlock(&h.lock)
+}
+
+// hookHelperMain is the body of every hookHelper goroutine.
+func hookHelperMain(h *hookHelper) {
+	h.g = getg()
+	for {
+		lock(&h.lock)
+		atomic.Store(&h.idle, 1)
+		goparkunlock(&h.lock, h.reason, traceEvGoBlock, 1)
+		// this goroutine is explicitly resumed by sysmon
+		h.work()
+	}
+}
+
+// A hookRing buffers events for a hookHelper to deliver. Any number of
+// threads may record events without a lock; the helper consumes them
+// under the helper's lock. If the helper falls behind, the oldest
+// events are overwritten.
+type hookRing struct {
+	buf  [256]hookEvent
+	head uint32 // number of slots claimed
+	tail uint32 // next event to deliver; protected by the helper's lock
+}
+
+// A hookEvent is one event in a hookRing. What the words mean depends
+// on the hook.
+type hookEvent struct {
+	// seq is one more than the index of the event in the slot once
+	// it has been written, and 0 while it is being written.
+	seq     uint32
+	a, b, c int64
+}
+
+// put records an event. The slot is claimed by incrementing head and
+// published by storing its seq last, so the reader never sees a slot
+// that is claimed but not yet written. It may run without a P, so it
+// must not have write barriers.
+//go:nowritebarrierrec
+func (r *hookRing) put(a, b, c int64) {
+	i := atomic.Xadd(&r.head, 1) - 1
+	e := &r.buf[i%uint32(len(r.buf))]
+	atomic.Store(&e.seq, 0)
+	e.a, e.b, e.c = a, b, c
+	atomic.Store(&e.seq, i+1)
+}
+
+// reset discards the events recorded so far. The caller must hold the
+// helper's lock.
+func (r *hookRing) reset() {
+	r.tail = atomic.Load(&r.head)
+}
+
+// get copies the undelivered events into evs, oldest first, and
+// returns how many it copied. An event whose slot is still being
+// written ends the copy, and is delivered by a later call. The caller
+// must hold the helper's lock.
+func (r *hookRing) get(evs *[len(hookRing{}.buf)]hookEvent) int {
+	head, tail := atomic.Load(&r.head), r.tail
+	if head-tail > uint32(len(r.buf)) {
+		// The ring wrapped; the oldest events are gone.
+		tail = head - uint32(len(r.buf))
+	}
+	n := 0
+	for ; tail != head; tail++ {
+		e := &r.buf[tail%uint32(len(r.buf))]
+		seq := atomic.Load(&e.seq)
+		if seq != tail+1 {
+			if seq == 0 || int32(seq-(tail+1)) < 0 {
+				// Claimed but not yet written.
+				break
+			}
+			// Overwritten by a newer event; this one is gone.
+			continue
+		}
+		ev := *e
+		if atomic.Load(&e.seq) != seq {
+			// Overwritten while we copied it.
+			continue
+		}
+		evs[n] = ev
+		n++
+	}
+	r.tail = tail
+	return n
+}
+
+// threadCreate holds the state for SetThreadCreateHook.
+var threadCreate struct {
+	h    hookHelper
+	fn   func(mid int64)
+	next int64 // next M id to report; protected by h.lock
+}
+
+// SetThreadCreateHook arranges for fn to be called with the id of
+// each OS thread (M) the runtime creates after SetThreadCreateHook
+// returns. Threads that already exist, including the main thread,
+// are not reported. Passing nil removes the hook.
+//
+// Thread creation can happen in contexts where running Go code is
+// not safe, so fn is not called inline. Instead it runs later on a
+// dedicated goroutine, in order of thread creation, typically within
+// a few milliseconds. fn should return promptly, since it delays the
+// reporting of subsequent threads.
+func SetThreadCreateHook(fn func(mid int64)) {
+	lock(&threadCreate.h.lock)
+	lock(&sched.lock)
+	threadCreate.next = sched.mnext
+	unlock(&sched.lock)
+	threadCreate.fn = fn
+	threadCreate.h.install(fn != nil, "thread create hook (idle)", threadCreateWork)
+}
+
+// threadCreateWork reports newly created Ms to the hook installed by
+// SetThreadCreateHook.
+func threadCreateWork() {
+	threadCreate.h.lockHooks()
+	lock(&sched.lock)
+	end := sched.mnext
+	unlock(&sched.lock)
+	fn, start := threadCreate.fn, threadCreate.next
+	threadCreate.next = end
+	unlock(&threadCreate.h.lock)
+	if fn == nil {
+		return
+	}
+	for id := start; id < end; id++ {
+		fn(id)
+	}
+}
+
+// preemptHook holds the state for SetPreemptHook. preemptone records
+// the id of each goroutine it asks to be preempted in ring.
+var preemptHook struct {
+	h    hookHelper
+	ring hookRing
+	fn   func(goid int64)
+}
+
+// SetPreemptHook arranges for fn to be called with the id of each
+// goroutine the scheduler asks to be preempted, for example because it
+// has been running for too long. Passing nil removes the hook.
+//
+// Preemption requests are issued in contexts where running Go code is
+// not safe, so fn is not called inline. Instead the ids are buffered
+// and delivered later on a dedicated goroutine, typically within a few
+// milliseconds. Reporting is best-effort: if fn falls behind, the
+// oldest ids are dropped. A request does not guarantee the goroutine
+// was actually preempted.
+func SetPreemptHook(fn func(goid int64)) {
+	lock(&preemptHook.h.lock)
+	// Don't report requests issued before fn was installed.
+	preemptHook.ring.reset()
+	preemptHook.fn = fn
+	preemptHook.h.install(fn != nil, "preempt hook (idle)", preemptHookWork)
+}
+
+// preemptHookWork reports preemption requests to the hook installed
+// by SetPreemptHook.
+func preemptHookWork() {
+	var evs [len(hookRing{}.buf)]hookEvent
+	preemptHook.h.lockHooks()
+	fn := preemptHook.fn
+	n := preemptHook.ring.get(&evs)
+	unlock(&preemptHook.h.lock)
+	if fn == nil {
+		return
+	}
+	for _, ev := range evs[:n] {
+		fn(ev.a)
+	}
+}
+
+// mParkHook holds the state for SetMParkCallback and
+// SetMUnparkCallback. stopm records each park and unpark in ring,
+// with the M id in a and 1 for a park or 0 for an unpark in b.
+var mParkHook struct {
+	h        hookHelper
+	ring     hookRing
+	parkFn   func(mid int64)
+	unparkFn func(mid int64)
+}
+
+// SetMParkCallback arranges for fn to be called with the id of each
+// operating system thread that parks because it has no work to do.
+// Passing nil removes the callback. See SetMUnparkCallback for the
+// matching notification when a thread is woken again.
+//
+// Threads park in contexts where running Go code is not safe, so fn
+// is not called inline. Instead the events are buffered and delivered
+// in order on a dedicated goroutine, typically within a few
+// milliseconds; if every thread is idle, delivery may wait until the
+// program becomes busy again. Reporting is best-effort: if fn falls
+// behind, the oldest events are dropped. Delivering events itself runs
+// on a thread that may then park, so an installed callback is expected
+// to see some activity even in an otherwise idle program.
+func SetMParkCallback(fn func(mid int64)) {
+	setMParkHook(&mParkHook.parkFn, fn)
+}
+
+// SetMUnparkCallback arranges for fn to be called with the id of each
+// thread that wakes up after parking as reported to the callback set
+// by SetMParkCallback. Passing nil removes the callback. Delivery is
+// deferred and best-effort, as for SetMParkCallback.
+func SetMUnparkCallback(fn func(mid int64)) {
+	setMParkHook(&mParkHook.unparkFn, fn)
+}
+
+func setMParkHook(slot *func(mid int64), fn func(mid int64)) {
+	lock(&mParkHook.h.lock)
+	if mParkHook.h.enabled == 0 {
+		// Don't report events from before a callback was installed.
+		mParkHook.ring.reset()
+	}
+	*slot = fn
+	on := mParkHook.parkFn != nil || mParkHook.unparkFn != nil
+	mParkHook.h.install(on, "m park hook (idle)", mParkHookWork)
+}
+
+// mParkHookRecord records that the current M is parking or has been
+// woken. It runs without a P, so it must not have write barriers.
+//go:nowritebarrierrec
+func mParkHookRecord(mid int64, park bool) {
+	var ev int64
+	if park {
+		ev = 1
+	}
+	mParkHook.ring.put(mid, ev, 0)
+	mParkHook.h.notify()
+}
+
+// mParkHookWork reports park events to the callbacks installed by
+// SetMParkCallback and SetMUnparkCallback.
+func mParkHookWork() {
+	var evs [len(hookRing{}.buf)]hookEvent
+	mParkHook.h.lockHooks()
+	parkFn, unparkFn := mParkHook.parkFn, mParkHook.unparkFn
+	n := mParkHook.ring.get(&evs)
+	unlock(&mParkHook.h.lock)
+	for _, ev := range evs[:n] {
+		if ev.b != 0 {
+			if parkFn != nil {
+				parkFn(ev.a)
+			}
+		} else if unparkFn != nil {
+			unparkFn(ev.a)
+		}
+	}
+}
+
+// gstatusHook holds the state for ObserveGStatusTransitions. The
+// transitions themselves are recorded in per-P rings.
+var gstatusHook struct {
+	h  hookHelper
+	fn func(goid int64, old, new uint32)
+}
+
+// ObserveGStatusTransitions arranges for fn to be called for each
+// change of a goroutine's scheduling status, with the goroutine's id
+// and its old and new status. Passing nil removes the observer. The
+// statuses are the runtime's internal values: 0 idle, 1 runnable,
+// 2 running, 3 in a system call, 4 waiting, 6 dead and 8 copying its
+// stack. They are meant for debugging tools and may change between
+// releases.
+//
+// Transitions happen deep inside the scheduler, so fn is not called
+// inline. Instead each processor records them in a small ring buffer,
+// and they are delivered on a dedicated goroutine, typically within a
+// few milliseconds, in order for each processor but not across
+// processors. Reporting is best-effort: if fn falls behind, the oldest
+// transitions are dropped, and transitions made by threads that hold
+// no processor, such as goroutines made ready by the network poller
+// while every processor is idle, are not recorded at all.
+//
+// Recording every transition has a measurable cost on scheduling, so
+// this is intended for debugging rather than for production use.
+func ObserveGStatusTransitions(fn func(goid int64, old, new uint32)) {
+	lock(&gstatusHook.h.lock)
+	if gstatusHook.h.enabled == 0 {
+		// Don't report transitions from before fn was installed.
+		lock(&allpLock)
+		for _, pp := range allp {
+			pp.gstatusTail = atomic.Load(&pp.gstatusHead)
+		}
+		unlock(&allpLock)
+	}
+	gstatusHook.fn = fn
+	gstatusHook.h.install(fn != nil, "gstatus hook (idle)", gstatusHookWork)
+}
+
+// gstatusHookRecord records a status transition of gp in the ring of
+// the current P, if there is one. It is called from casgstatus, so it
+// must not split the stack or have write barriers.
+//go:nosplit
+//go:nowritebarrierrec
+func gstatusHookRecord(gp *g, oldval, newval uint32) {
+	pp := getg().m.p.ptr()
+	if pp == nil || gp == gstatusHook.h.g {
+		// Transitions of the helper itself would keep it busy
+		// reporting them.
+		return
+	}
+	h := pp.gstatusHead
+	pp.gstatusBuf[h%uint32(len(pp.gstatusBuf))] = gstatusEvent{gp.goid, oldval, newval}
+	atomic.Store(&pp.gstatusHead, h+1)
+	gstatusHook.h.notify()
+}
+
+// gstatusHookWork reports status transitions to the observer
+// installed by ObserveGStatusTransitions.
+func gstatusHookWork() {
+	var evs [len(p{}.gstatusBuf)]gstatusEvent
+	for i := 0; ; i++ {
+		gstatusHook.h.lockHooks()
+		fn := gstatusHook.fn
+		lock(&allpLock)
+		if i >= len(allp) {
+			unlock(&allpLock)
+			unlock(&gstatusHook.h.lock)
+			break
+		}
+		pp := allp[i]
+		unlock(&allpLock)
+		head, tail := atomic.Load(&pp.gstatusHead), pp.gstatusTail
+		if head-tail > uint32(len(evs)) {
+			// The ring wrapped; the oldest events are gone.
+			tail = head - uint32(len(evs))
+		}
+		n := 0
+		for t := tail; t != head; t++ {
+			evs[n] = pp.gstatusBuf[t%uint32(len(evs))]
+			n++
+		}
+		// The owner of pp kept recording while we copied, so
+		// drop any events it may have overwritten, including
+		// the slot of an event it may be writing now.
+		skip := 0
+		if h := atomic.Load(&pp.gstatusHead) + 1; h-tail > uint32(len(evs)) {
+			skip = int(h - tail - uint32(len(evs)))
+			if skip > n {
+				skip = n
+			}
+		}
+		pp.gstatusTail = head
+		unlock(&gstatusHook.h.lock)
+		if fn == nil {
+			continue
+		}
+		for _, ev := range evs[skip:n] {
+			fn(ev.goid, ev.oldval, ev.newval)
+		}
+	}
+}
+
+// syscallRetakeHook holds the state for SetSyscallRetakeHook. retake
+// records each retake in ring, with the P id in a and how long, in
+// nanoseconds, the P had been in the system call in b.
+var syscallRetakeHook struct {
+	h    hookHelper
+	ring hookRing
+	fn   func(pid int64, durationNs int64)
+}
+
+// SetSyscallRetakeHook arranges for fn to be called each time the
+// scheduler takes a processor away from a thread that has been blocked
+// in a system call for too long, so that other goroutines can run on
+// it. fn receives the id of the processor and roughly how long, in
+// nanoseconds, it had been in the system call. Passing nil removes the
+// hook.
+//
+// Processors are retaken by a background thread that cannot run Go
+// code, so fn is not called inline. Instead the events are buffered
+// and delivered in order on a dedicated goroutine, typically within a
+// few milliseconds. Reporting is best-effort: if fn falls behind, the
+// oldest events are dropped.
+func SetSyscallRetakeHook(fn func(pid int64, durationNs int64)) {
+	lock(&syscallRetakeHook.h.lock)
+	// Don't report retakes from before fn was installed.
+	syscallRetakeHook.ring.reset()
+	syscallRetakeHook.fn = fn
+	syscallRetakeHook.h.install(fn != nil, "syscall retake hook (idle)", syscallRetakeHookWork)
+}
+
+// syscallRetakeHookRecord records that retake took pp from a system
+// call that started at when. It runs on sysmon, so it must not have
+// write barriers.
+//go:nowritebarrierrec
+func syscallRetakeHookRecord(pp *p, when, now int64) {
+	syscallRetakeHook.ring.put(int64(pp.id), now-when, 0)
+	syscallRetakeHook.h.notify()
+}
+
+// syscallRetakeHookWork reports retakes to the hook installed by
+// SetSyscallRetakeHook.
+func syscallRetakeHookWork() {
+	var evs [len(hookRing{}.buf)]hookEvent
+	syscallRetakeHook.h.lockHooks()
+	fn := syscallRetakeHook.fn
+	n := syscallRetakeHook.ring.get(&evs)
+	unlock(&syscallRetakeHook.h.lock)
+	if fn == nil {
+		return
+	}
+	for _, ev := range evs[:n] {
+		fn(ev.a, ev.b)
+	}
+}
+
+// mpool holds the state for GODEBUG=syscallmpool.
+var mpool struct {
+	h        hookHelper
+	starting uint32 // Ms created by mpoolWork that have not parked yet
+}
+
+// mpoolWork keeps at least debug.syscallmpool Ms parked on the idle
+// list, so that startm, in particular when entersyscallblock hands off
+// its P, finds a warm M with mget instead of creating a thread. Only
+// code that holds a P can create an M, so sysmon wakes mpool's helper
+// to run this when the idle list runs short.
+//
+// The pool is bounded from below only: the runtime never destroys idle
+// Ms, so Ms that go idle after a system call join the pool and are
+// simply reused first. It is drained by anything that starts an M,
+// and refilled in the background within about 10ms. New Ms count
+// toward the limit set by debug.SetMaxThreads, and the pool is not
+// refilled if that would reach it.
+func mpoolWork() {
+	for mpoolShort() {
+		atomic.Xadd(&mpool.starting, 1)
+		newm(mpoolPark, nil)
+	}
+}
+
+// mpoolShort reports whether the pool needs another M.
+func mpoolShort() bool {
+	lock(&sched.lock)
+	short := sched.nmidle+int32(atomic.Load(&mpool.starting)) < debug.syscallmpool && mcount() < sched.maxmcount
+	unlock(&sched.lock)
+	return short
+}
+
+// mpoolPark is the start function of Ms created by mpoolWork. It
+// parks the new M until startm hands it a P. Since mstartfn does not
+// return to mstart1, it runs the thread init function itself.
+func mpoolPark() {
+	atomic.Xadd(&mpool.starting, -1)
+	stopm()
+	if atomic.Loadp(unsafe.Pointer(&threadInitFn)) != nil {
+		threadInit()
+	}
+	schedule()
+}
+
+// idleCallback holds the state for SetIdleCallback.
+var idleCallback struct {
+	h  hookHelper
+	fn func()
+}
+
+// idleCallbackDelay is how long, in nanoseconds, the program must stay
+// idle before the idle callback runs.
+const idleCallbackDelay = 1000 * 1000
+
+// SetIdleCallback arranges for fn to be called each time the program
+// goes idle, that is, when no processor has any goroutine to run after
+// some did, and none has for about a millisecond. Passing nil removes
+// the callback.
+//
+// Idleness is sampled periodically by a background thread, so fn is
+// called asynchronously on a dedicated goroutine, typically within a
+// few milliseconds of the program going idle. While fn runs, and until
+// the program is seen busy again, fn is not called again; bursts of
+// work too short to be sampled may therefore go unnoticed. Any work fn
+// does makes the program busy for its duration, and work it starts in
+// other goroutines may keep it busy after fn returns.
+func SetIdleCallback(fn func()) {
+	lock(&idleCallback.h.lock)
+	idleCallback.fn = fn
+	idleCallback.h.install(fn != nil, "idle callback (idle)", idleCallbackWork)
+}
+
+// idleCallbackWork calls the callback installed by SetIdleCallback.
+func idleCallbackWork() {
+	idleCallback.h.lockHooks()
+	fn := idleCallback.fn
+	unlock(&idleCallback.h.lock)
+	if fn != nil {
+		fn()
+	}
+}
+
 // Mark gp ready to run.
 // 将gp的状态更改为_Grunnable，以便调度器调度执行
 // 并且如果next==true，那么设置为优先级最高，并尝试wakep
@@ -760,10 +1560,12 @@ func ready(gp *g, traceskip int, next bool) {
 
 	// status is Gwaiting or Gscanwaiting, make Grunnable and put on runq
 	casgstatus(gp, _Gwaiting, _Grunnable)
-	runqput(_g_.m.p.ptr(), gp, next)
-	// 如果有空闲P且没有自旋的M。
-	if atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 {
-		wakep()
+	if !readyLastP(gp, next) {
+		runqput(_g_.m.p.ptr(), gp, next)
+		// 如果有空闲P且没有自旋的M。
+		if atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 {
+			wakep()
+		}
 	}
 	_g_.m.locks--
 	if _g_.m.locks == 0 && _g_.preempt { // restore the preemption request in Case we've cleared it in newstack
@@ -771,6 +1573,36 @@ func ready(gp *g, traceskip int, next bool) {
 	}
 }
 
+// readyLastP tries to queue gp, which is runnable, on the P it last
+// ran on, so that it resumes with a warm cache, and reports whether it
+// did. This is only a hint, and is only tried with GODEBUG=readylastp=1,
+// since it takes sched.lock and bypasses runnext on the caller's P.
+//
+// Only the owner of a P may put goroutines on its local run queue, so
+// this succeeds only if the last P is idle: taking it off the idle list
+// under sched.lock makes us its owner until startm hands it to an M,
+// as in pinqput. If the last P is busy, gp stays with the caller's P.
+// Routing it through the global run queue instead would add latency
+// without helping locality, since any P may take it from there.
+func readyLastP(gp *g, next bool) bool {
+	if debug.readylastp == 0 {
+		return false
+	}
+	pp := gp.lastp.ptr()
+	if pp == nil || pp == getg().m.p.ptr() || gp.pinnedP != 0 || pp.boundm != 0 || atomic.Load(&sched.npidle) == 0 {
+		return false
+	}
+	lock(&sched.lock)
+	if pp.status != _Pidle || !pidleremove(pp) {
+		unlock(&sched.lock)
+		return false
+	}
+	unlock(&sched.lock)
+	runqput(pp, gp, next)
+	startm(pp, false)
+	return true
+}
+
 func gcprocs() int32 {
 	// Figure out how many CPUs to use during GC.
 	// Limited by gomaxprocs, number of actual CPUs, and MaxGcproc.
@@ -986,6 +1818,12 @@ func casgstatus(gp *g, oldval, newval uint32) {
 	if newval == _Grunning {
 		gp.gcscanvalid = false
 	}
+	if newval == _Grunnable && debug.schedlatency != 0 {
+		gp.runnable = nanotime()
+	}
+	if atomic.Load(&gstatusHook.h.enabled) != 0 {
+		gstatusHookRecord(gp, oldval, newval)
+	}
 }
 
 // casgstatus(gp, oldstatus, Gcopystack), assuming oldstatus is Gwaiting or Grunnable.
@@ -1166,6 +2004,22 @@ func startTheWorld() {
 // and prevents gomaxprocs from changing concurrently.
 var worldsema uint32 = 1
 
+// stwStopTime is the nanotime at which stopTheWorldWithSema last
+// finished stopping the world. Protected by worldsema.
+var stwStopTime int64
+
+// lastSTWDuration is how long, in nanoseconds, the world stayed
+// stopped the last time it was restarted. Accessed atomically;
+// see LastSTWDuration.
+var lastSTWDuration uint64
+
+// State for GODEBUG=stwlog=1, recorded when stopTheWorldWithSema
+// starts and reported by startTheWorldWithSema. Protected by worldsema.
+var (
+	stwlogReason string
+	stwlogStart  int64
+)
+
 // stopTheWorldWithSema is the core implementation of stopTheWorld.
 // The caller is responsible for acquiring worldsema and disabling
 // preemption first and then should stopTheWorldWithSema on the system
@@ -1190,7 +2044,17 @@ var worldsema uint32 = 1
 // stopTheWorld to block.
 // stopTheWorldWithSema STW的核心实现
 func stopTheWorldWithSema() {
+	stopTheWorldTimeout(-1)
+}
+
+// stopTheWorldTimeout is stopTheWorldWithSema, except that if timeout
+// is not negative it gives up once the stop has taken timeout
+// nanoseconds, restarts the Ps it managed to stop, and returns false.
+// The caller must then release worldsema and clear m.preemptoff
+// without calling startTheWorldWithSema.
+func stopTheWorldTimeout(timeout int64) bool {
 	_g_ := getg()
+	start := nanotime()
 
 	// If we hold a lock, then we won't be able to stop another M
 	// that is blocked trying to acquire the lock.
@@ -1198,6 +2062,11 @@ func stopTheWorldWithSema() {
 		throw("stopTheWorld: holding locks")
 	}
 
+	if debug.stwlog > 0 {
+		stwlogReason = _g_.m.preemptoff
+		stwlogStart = nanotime()
+	}
+
 	lock(&sched.lock)
 	sched.stopwait = gomaxprocs
 	// 设置gc等待标记, 调度时看见此标记会进入等待
@@ -1221,12 +2090,10 @@ func stopTheWorldWithSema() {
 			sched.stopwait--
 		}
 	}
-	// stop idle P's
-	for {
-		p := pidleget()
-		if p == nil {
-			break
-		}
+	// stop idle P's, bound ones included
+	for sched.pidle != 0 {
+		p := sched.pidle.ptr()
+		pidleremove(p)
 		p.status = _Pgcstop
 		sched.stopwait--
 	}
@@ -1241,6 +2108,10 @@ func stopTheWorldWithSema() {
 				noteclear(&sched.stopnote)
 				break
 			}
+			if timeout >= 0 && nanotime()-start >= timeout && abandonStopTheWorld() {
+				stwlogReason, stwlogStart = "", 0
+				return false
+			}
 			preemptall()
 		}
 	}
@@ -1267,6 +2138,61 @@ func stopTheWorldWithSema() {
 	if bad != "" {
 		throw(bad)
 	}
+	stwStopTime = nanotime()
+	return true
+}
+
+// abandonStopTheWorld undoes a partial stop by stopTheWorldTimeout:
+// the Ps that stopped are made idle, or handed to an M if they have
+// work, and the Ps still running are left alone. It returns false,
+// doing nothing, if the world finished stopping after all.
+//
+// Ps that see gcwaiting after it is cleared here must cope: gcstopm
+// rechecks it under sched.lock, and the other places that stop a P
+// for the world already check it, or stopwait, under sched.lock.
+func abandonStopTheWorld() bool {
+	_g_ := getg()
+
+	lock(&sched.lock)
+	if sched.stopwait == 0 || atomic.Load(&freezing) != 0 {
+		// Either everyone stopped and the stop note has been
+		// posted, or the world is being frozen for a panic and
+		// must stay stopped.
+		unlock(&sched.lock)
+		return false
+	}
+	sched.stopwait = 0
+	atomic.Store(&sched.gcwaiting, 0)
+	_g_.m.p.ptr().status = _Prunning
+	var runnablePs *p
+	for _, p := range allp {
+		if p.status != _Pgcstop {
+			continue
+		}
+		p.status = _Pidle
+		if runqempty(p) && atomic.Load(&p.pinqsize) == 0 {
+			pidleput(p)
+		} else {
+			p.link.set(runnablePs)
+			runnablePs = p
+		}
+	}
+	if sched.sysmonwait != 0 {
+		sched.sysmonwait = 0
+		notewakeup(&sched.sysmonnote)
+	}
+	unlock(&sched.lock)
+
+	for runnablePs != nil {
+		p := runnablePs
+		runnablePs = p.link.ptr()
+		p.link = 0
+		startm(p, false)
+	}
+	if atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 {
+		wakep()
+	}
+	return true
 }
 
 func mhelpgc() {
@@ -1279,7 +2205,7 @@ func startTheWorldWithSema(emitTraceEvent bool) int64 {
 	_g_ := getg()
 
 	_g_.m.locks++ // disable preemption because it can be holding p in a local var
-	if netpollinited() {
+	if netpollEnabled() {
 		gp := netpoll(false) // non-blocking
 		injectglist(gp)
 	}
@@ -1322,6 +2248,10 @@ func startTheWorldWithSema(emitTraceEvent bool) int64 {
 	if emitTraceEvent {
 		traceGCSTWDone()
 	}
+	if stwStopTime != 0 {
+		atomic.Store64(&lastSTWDuration, uint64(startTime-stwStopTime))
+		stwStopTime = 0
+	}
 
 	// Wakeup an additional proc in case we have excessive runnable goroutines
 	// in local queues or in the global queue. If we don't, the proc will park itself.
@@ -1345,6 +2275,17 @@ func startTheWorldWithSema(emitTraceEvent bool) int64 {
 		_g_.stackguard0 = stackPreempt
 	}
 
+	// Report the stop now that the other Ps are running again,
+	// so the write does not lengthen the pause.
+	if debug.stwlog > 0 && stwlogStart != 0 {
+		reason := stwlogReason
+		if reason == "" {
+			reason = "GC" // gcStart does not set m.preemptoff
+		}
+		print("STW ", reason, ": ", (startTime-stwlogStart)/1000, " us\n")
+		stwlogReason, stwlogStart = "", 0
+	}
+
 	return startTime
 }
 
@@ -1466,10 +2407,77 @@ func mstart1(dummy int32) {
 		_g_.m.nextp = 0
 	}
 
+	if _g_.m != &m0 && atomic.Loadp(unsafe.Pointer(&threadInitFn)) != nil {
+		threadInit()
+	}
+
 	// 进入调度，而且不会在返回
 	schedule()
 }
 
+// threadInitFn, if non-nil, points to the function set by
+// SetThreadInitFn. Accessed atomically.
+var threadInitFn unsafe.Pointer // *func()
+
+// SetThreadInitFn arranges for fn to be called on each operating
+// system thread the runtime starts from then on, on that thread,
+// before it runs any other goroutine. Passing nil removes it. It is
+// meant for configuring threads individually where the operating
+// system requires it, for example to install a seccomp filter or set
+// the CPU affinity of every thread. Threads that already exist, the
+// main thread, threads created by C code that later call into Go, and
+// threads the runtime keeps for its own background work, which never
+// run goroutines, are not affected, so fn should be set early, for
+// example in an init function.
+//
+// fn runs on an ordinary goroutine locked to the new thread, so it may
+// allocate, block and call into the runtime like any other goroutine.
+// The thread does not run other goroutines until fn returns, so fn
+// should return promptly. fn may run on several threads at once. If fn
+// returns with its goroutine still locked by LockOSThread, the thread
+// exits, as it does when any locked goroutine exits.
+func SetThreadInitFn(fn func()) {
+	var p *func()
+	if fn != nil {
+		p = new(func())
+		*p = fn
+	}
+	if raceenabled {
+		racereleasemerge(unsafe.Pointer(&threadInitFn))
+	}
+	atomicstorep(unsafe.Pointer(&threadInitFn), unsafe.Pointer(p))
+}
+
+// threadInit runs the function set by SetThreadInitFn on a goroutine
+// locked to the current M before the M runs anything else. It is
+// called by mstart1 and mpoolPark once the M has a P, and does not
+// return.
+//
+// Write barriers are allowed here because the M has a P.
+//
+//go:yeswritebarrierrec
+func threadInit() {
+	_g_ := getg()
+	if _g_.m.spinning {
+		// The M is about to run a goroutine; see schedule.
+		resetspinning()
+	}
+	fn := threadInitMain
+	gp := newproc1(*(**funcval)(unsafe.Pointer(&fn)), nil, 0, funcPC(mstart1)+sys.PCQuantum, false, nil, _g_.m)
+	execute(gp, true)
+}
+
+// threadInitMain is the body of the goroutine started by threadInit.
+func threadInitMain() {
+	if raceenabled {
+		raceacquire(unsafe.Pointer(&threadInitFn))
+	}
+	if fn := (*func())(atomic.Loadp(unsafe.Pointer(&threadInitFn))); fn != nil {
+		(*fn)()
+	}
+	unlockOSThread()
+}
+
 // mstartm0 implements part of mstart1 that only runs on the m0.
 //
 // Write barriers are allowed here because we know the GC can't be
@@ -1513,6 +2521,11 @@ func mexit(osStack bool) {
 		//
 		// We could try to clean up this M more before wedging
 		// it, but that complicates signal handling.
+		lock(&sched.lock)
+		if m.boundp != 0 {
+			unbindp(m)
+		}
+		unlock(&sched.lock)
 		handoffp(releasep())
 		lock(&sched.lock)
 		sched.nmfreed++
@@ -1540,6 +2553,10 @@ func mexit(osStack bool) {
 	}
 	throw("m not found in allm")
 found:
+	sched.syscalltime += m.syscalltime
+	if m.boundp != 0 {
+		unbindp(m)
+	}
 	if !osStack {
 		// Delay reaping m until it's done with the stack.
 		//
@@ -1872,12 +2889,62 @@ func newextram() {
 			oneNewExtraM()
 		}
 	} else {
-		// Make sure there is at least one extra M.
+		// Make sure there is at least one extra M. The rest of a
+		// pool sized by SetExtraMPoolSize is left to its helper,
+		// to keep the allocation off the callback path.
 		mp := lockextra(true)
+		n := extraMCount
 		unlockextra(mp)
 		if mp == nil {
 			oneNewExtraM()
 		}
+		if n < atomic.Load(&extraMPool.size) {
+			extraMPool.h.notify()
+		}
+	}
+}
+
+// extraMPool holds the state for SetExtraMPoolSize.
+var extraMPool struct {
+	h    hookHelper
+	size uint32 // spare Ms to keep on the extra list
+}
+
+// SetExtraMPoolSize sets the number of spare Ms the runtime keeps for
+// threads not created by Go that call into Go through cgo. Such a
+// callback takes an M from the pool for its duration. By default the
+// pool holds a single spare M and a new one is allocated on the
+// callback path whenever it runs out, which adds latency when many C
+// threads call into Go at once. SetExtraMPoolSize allocates Ms up
+// front until n are available, and once callbacks drain the pool it is
+// refilled to n in the background. Ms are never freed, so an oversized
+// pool wastes their memory for the life of the program, and lowering n
+// does not shrink a pool that has already grown. An n below 1 restores
+// the default. It has no effect in programs that do not use cgo.
+func SetExtraMPoolSize(n int) {
+	if n < 1 {
+		n = 0
+	}
+	lock(&extraMPool.h.lock)
+	atomic.Store(&extraMPool.size, uint32(n))
+	extraMPool.h.install(n > 0 && cgoHasExtraM, "extra M pool (idle)", extraMPoolWork)
+	if cgoHasExtraM {
+		extraMPoolWork()
+	}
+}
+
+// extraMPoolWork tops up the extra list to the size set by
+// SetExtraMPoolSize. newextram notifies the helper that runs it when
+// a cgo callback leaves the list short.
+func extraMPoolWork() {
+	for {
+		mp := lockextra(true)
+		n := extraMCount
+		unlockextra(mp)
+		if n >= atomic.Load(&extraMPool.size) {
+			return
+		}
+		oneNewExtraM()
 	}
 }
 
@@ -1963,6 +3030,11 @@ func dropm() {
 	casgstatus(mp.curg, _Gsyscall, _Gdead)
 	atomic.Xadd(&sched.ngsys, +1)
 
+	// The M sits on the extra list until the next needm, which
+	// is neither time spent in a system call nor a cgo call.
+	mp.syscallstart = 0
+	mp.incgo = false
+
 	// Block signals before unminit.
 	// Unminit unregisters the signal handling stack (but needs g on some systems).
 	// Setg(nil) clears g, which is the signal handler's cue not to run Go handlers.
@@ -2203,11 +3275,46 @@ func stopm() {
 
 retry:
 	lock(&sched.lock)
-	mput(_g_.m)
+	if pp := _g_.m.boundp.ptr(); pp != nil {
+		// Only our bound P will do; wait for startboundm to hand it
+		// to us, unless it was left idle with work for us already.
+		if _g_.m.boundkick {
+			_g_.m.boundkick = false
+			if pidleremove(pp) {
+				unlock(&sched.lock)
+				acquirep(pp)
+				return
+			}
+		}
+		_g_.m.boundparked = true
+		sched.nmidlebound++
+		checkdead()
+	} else {
+		mput(_g_.m)
+	}
 	unlock(&sched.lock)
+	if atomic.Load(&mParkHook.h.enabled) != 0 {
+		mParkHookRecord(_g_.m.id, true)
+	}
 	// 在lock_futex.go 中
 	notesleep(&_g_.m.park)
 	noteclear(&_g_.m.park)
+	if atomic.Load(&mParkHook.h.enabled) != 0 {
+		mParkHookRecord(_g_.m.id, false)
+	}
+	if _g_.m.reaped {
+		// reapidlem took us off midle to exit. mexit releases a P,
+		// so borrow an idle one; failing that, stay.
+		_g_.m.reaped = false
+		lock(&sched.lock)
+		pp := pidleget()
+		unlock(&sched.lock)
+		if pp == nil {
+			goto retry
+		}
+		acquirep(pp)
+		gogo(&_g_.m.g0.sched)
+	}
 	if _g_.m.helpgc != 0 {
 		// helpgc() set _g_.m.p and _g_.m.mcache, so we have a P.
 		gchelper()
@@ -2252,6 +3359,10 @@ func startm(_p_ *p, spinning bool) {
 			return
 		}
 	}
+	if _p_.boundm != 0 {
+		startboundm(_p_, spinning)
+		return
+	}
 	// 获取一个空闲的M
 	mp := mget()
 	unlock(&sched.lock)
@@ -2273,13 +3384,105 @@ func startm(_p_ *p, spinning bool) {
 	if mp.nextp != 0 { // 这个位置是要留给参数_p_的，stopm中如果被唤醒，则关联nextp和m
 		throw("startm: m has p")
 	}
-	if spinning && !runqempty(_p_) { // spinning状态的M是在本地和全局都获取不到工作的情况，不能与spinning语义矛盾
-		throw("startm: p has runnable gs")
+	if spinning && !runqempty(_p_) { // spinning状态的M是在本地和全局都获取不到工作的情况，不能与spinning语义矛盾
+		throw("startm: p has runnable gs")
+	}
+	// The caller incremented nmspinning, so set m.spinning in the new M.
+	mp.spinning = spinning //标记该M是否在自旋
+	mp.nextp.set(_p_)      // 暂存P
+	notewakeup(&mp.park)   // 唤醒M
+}
+
+// startboundm is startm for a P bound to an M by PinPToCurrentM.
+// Only that M may run _p_: if it is parked in stopm, startboundm
+// hands it _p_; otherwise it leaves _p_ for the M to pick up, acting
+// as the M itself for a pending stop-the-world or safe-point
+// function. Sched must be locked; startboundm unlocks it.
+//go:nowritebarrierrec
+func startboundm(_p_ *p, spinning bool) {
+	mp := _p_.boundm.ptr()
+	if mp.boundparked {
+		mp.boundparked = false
+		sched.nmidlebound--
+		unlock(&sched.lock)
+		if mp.nextp != 0 {
+			throw("startboundm: m has p")
+		}
+		mp.spinning = spinning
+		mp.nextp.set(_p_)
+		notewakeup(&mp.park)
+		return
+	}
+	wake := false
+	if sched.gcwaiting != 0 {
+		_p_.status = _Pgcstop
+		sched.stopwait--
+		if sched.stopwait == 0 {
+			notewakeup(&sched.stopnote)
+		}
+	} else {
+		if _p_.runSafePointFn != 0 && atomic.Cas(&_p_.runSafePointFn, 1, 0) {
+			sched.safePointFn(_p_)
+			sched.safePointWait--
+			if sched.safePointWait == 0 {
+				notewakeup(&sched.safePointNote)
+			}
+		}
+		// Other Ps may have to run what _p_ would have.
+		wake = idleboundp(_p_) || sched.runqsize != 0
+	}
+	unlock(&sched.lock)
+	if spinning {
+		if int32(atomic.Xadd(&sched.nmspinning, -1)) < 0 {
+			throw("startboundm: negative nmspinning")
+		}
+	}
+	if wake {
+		wakep()
+	}
+}
+
+// idleboundp puts _p_, whose bound M is busy elsewhere, on the idle
+// list for that M to find in stopm or exitsyscall. Goroutines in
+// _p_'s local run queue move to the global queue so that other Ps
+// can run them meanwhile; idleboundp reports whether there were any.
+// Sched must be locked.
+//go:nowritebarrierrec
+func idleboundp(_p_ *p) bool {
+	moved := false
+	for {
+		gp, _ := runqget(_p_)
+		if gp == nil {
+			break
+		}
+		globrunqput(gp)
+		moved = true
+	}
+	pidleput(_p_)
+	_p_.boundm.ptr().boundkick = true
+	return moved
+}
+
+// unbindp undoes the binding of mp and its P by PinPToCurrentM. If
+// mp is parked waiting for the P, it joins midle instead.
+// Sched must be locked.
+//go:nowritebarrierrec
+func unbindp(mp *m) {
+	mp.boundp.ptr().boundm = 0
+	mp.boundp = 0
+	mp.boundkick = false
+	sched.nboundp--
+	if mp.boundready {
+		// startlockedm left mp.lockedg to mp; now that mp may run
+		// any P, let it go through startlockedm again.
+		mp.boundready = false
+		globrunqput(mp.lockedg.ptr())
+	}
+	if mp.boundparked {
+		mp.boundparked = false
+		sched.nmidlebound--
+		mput(mp)
 	}
-	// The caller incremented nmspinning, so set m.spinning in the new M.
-	mp.spinning = spinning //标记该M是否在自旋
-	mp.nextp.set(_p_)      // 暂存P
-	notewakeup(&mp.park)   // 唤醒M
 }
 
 // Hands off P from syscall or locked M.
@@ -2291,7 +3494,7 @@ func handoffp(_p_ *p) {
 	// findrunnable would return a G to run on _p_.
 
 	// if it has local work, start it straight away
-	if !runqempty(_p_) || sched.runqsize != 0 {
+	if !runqempty(_p_) || sched.runqsize != 0 || atomic.Load(&_p_.pinqsize) != 0 {
 		startm(_p_, false)
 		return
 	}
@@ -2323,7 +3526,7 @@ func handoffp(_p_ *p) {
 			notewakeup(&sched.safePointNote)
 		}
 	}
-	if sched.runqsize != 0 {
+	if sched.runqsize != 0 || atomic.Load(&_p_.pinqsize) != 0 {
 		unlock(&sched.lock)
 		startm(_p_, false)
 		return
@@ -2369,12 +3572,14 @@ func stoplockedm() {
 		_p_ := releasep()
 		handoffp(_p_)
 	}
-	incidlelocked(1)
+	if !takeboundp(_g_.m) {
+		incidlelocked(1)
 
-	// Wait until another thread schedules lockedg again.
-	// M休眠直到被唤醒
-	notesleep(&_g_.m.park)
-	noteclear(&_g_.m.park)
+		// Wait until another thread schedules lockedg again.
+		// M休眠直到被唤醒
+		notesleep(&_g_.m.park)
+		noteclear(&_g_.m.park)
+	}
 	status := readgstatus(_g_.m.lockedg.ptr())
 	if status&^_Gscan != _Grunnable {
 		print("runtime:stoplockedm: g is not Grunnable or Gscanrunnable\n")
@@ -2386,6 +3591,26 @@ func stoplockedm() {
 	_g_.m.nextp = 0
 }
 
+// takeboundp is called by stoplockedm once mp has handed off its P.
+// If startlockedm left mp.lockedg to mp because mp's bound P was
+// still busy, and the P is idle now, takeboundp sets mp.nextp to it
+// and reports true.
+//go:nowritebarrierrec
+func takeboundp(mp *m) bool {
+	if mp.boundp == 0 {
+		return false
+	}
+	lock(&sched.lock)
+	ok := mp.boundready && pidleremove(mp.boundp.ptr())
+	if ok {
+		mp.boundready = false
+		mp.boundkick = false
+		mp.nextp = mp.boundp
+	}
+	unlock(&sched.lock)
+	return ok
+}
+
 // Schedules the locked m to run the locked gp.
 // May run during STW, so write barriers are not allowed.
 //go:nowritebarrierrec
@@ -2400,6 +3625,34 @@ func startlockedm(gp *g) {
 	if mp.nextp != 0 {
 		throw("startlockedm: m has p")
 	}
+	lock(&sched.lock)
+	if pp := mp.boundp.ptr(); pp != nil {
+		if pidleremove(pp) {
+			// mp is bound to an idle P; give it that one and keep ours.
+			mp.boundkick = false
+			unlock(&sched.lock)
+			incidlelocked(-1)
+			mp.nextp.set(pp)
+			notewakeup(&mp.park)
+			return
+		}
+		// mp may only run on its P, which it is still handing off
+		// in stoplockedm, or which is stopped for stop-the-world.
+		// Leave gp to mp: stoplockedm takes the P back once it is
+		// idle, and procresize hands it over when the world starts.
+		mp.boundready = true
+		unlock(&sched.lock)
+		return
+	}
+	if _g_.m.p.ptr().boundm != 0 {
+		// Our P is bound to us. Leave gp for an unbound P, of which
+		// there is always at least one.
+		globrunqput(gp)
+		unlock(&sched.lock)
+		wakep()
+		return
+	}
+	unlock(&sched.lock)
 	// directly handoff current P to the locked m
 	incidlelocked(-1)
 	// 分配p
@@ -2412,14 +3665,12 @@ func startlockedm(gp *g) {
 }
 
 // Stops the current m for stopTheWorld.
-// Returns when the world is restarted.
+// Returns when the world is restarted, or at once if the stop has
+// been abandoned.
 // 为了STW，停止当前的M
 func gcstopm() {
 	_g_ := getg()
 
-	if sched.gcwaiting == 0 {
-		throw("gcstopm: not waiting for gc")
-	}
 	if _g_.m.spinning {
 		_g_.m.spinning = false
 		// OK to just drop nmspinning here,
@@ -2428,8 +3679,14 @@ func gcstopm() {
 			throw("gcstopm: negative nmspinning")
 		}
 	}
-	_p_ := releasep()
 	lock(&sched.lock)
+	if sched.gcwaiting == 0 {
+		// The caller saw gcwaiting, but the stop was abandoned
+		// since (see abandonStopTheWorld). Keep running.
+		unlock(&sched.lock)
+		return
+	}
+	_p_ := releasep()
 	_p_.status = _Pgcstop
 	sched.stopwait--
 	if sched.stopwait == 0 {
@@ -2456,8 +3713,13 @@ func execute(gp *g, inheritTime bool) {
 
 	// 更改gp的状态为_Grunning
 	casgstatus(gp, _Grunnable, _Grunning)
+	gp.schedcount++
 	// 置等待时间为0
 	gp.waitsince = 0
+	gp.runstart = nanotime()
+	if debug.schedlatency != 0 && gp.runnable != 0 {
+		_g_.m.p.ptr().schedlatency[schedLatencyBucket(gp.runstart-gp.runnable)]++
+	}
 	// 置可抢占标志为fasle
 	gp.preempt = false
 	gp.stackguard0 = gp.stack.lo + _StackGuard
@@ -2469,6 +3731,7 @@ func execute(gp *g, inheritTime bool) {
 	_g_.m.curg = gp
 	// gp的M改为当前的M
 	gp.m = _g_.m
+	gp.lastp = _g_.m.p
 
 	// Check whether the profiler needs to be turned on or off.
 	hz := sched.profilehz
@@ -2494,6 +3757,57 @@ func execute(gp *g, inheritTime bool) {
 	gogo(&gp.sched)
 }
 
+// spinningLimit caps the number of Ms that findrunnable lets spin
+// looking for work. 0 means no cap beyond the usual heuristic.
+// Accessed atomically. See SetSpinningLimit.
+var spinningLimit uint32
+
+// SetSpinningLimit limits the number of OS threads that may spin
+// looking for goroutines to steal at the same time. This trades
+// scheduling latency for less CPU burnt on idle threads, which can
+// help programs with low parallelism on machines with many CPUs.
+// A value of 0 or less restores the default, which only limits
+// spinning threads to half the number of busy Ps.
+//
+// The limit is best-effort: threads woken to run newly ready
+// goroutines may briefly exceed it.
+func SetSpinningLimit(n int32) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.Store(&spinningLimit, uint32(n))
+}
+
+// externalRunSource, if non-nil, points to a function that
+// findrunnable asks for a goroutine to run before looking at the
+// global run queue. Accessed atomically. See setExternalRunSource.
+var externalRunSource unsafe.Pointer // *func() *g
+
+// setExternalRunSource registers fn as a source of goroutines for the
+// scheduler, or removes the current source if fn is nil. It is meant
+// for frameworks that keep their own queue of parked goroutines;
+// since g is not exported, packages outside the runtime reach it with
+// a go:linkname directive.
+//
+// When a P runs out of local work, findrunnable calls fn before
+// checking the global run queue. fn returns a goroutine parked by
+// gopark (and so in _Gwaiting), which the scheduler makes runnable
+// and runs, or nil to continue with normal scheduling. fn runs on the
+// system stack of an M that holds a P, so it must not block, allocate,
+// or call into the scheduler, and the same goroutine must not be
+// returned twice.
+//
+// fn is only consulted by Ps looking for work; the runtime does not
+// wake idle Ps when the external queue becomes non-empty.
+func setExternalRunSource(fn func() *g) {
+	var p *func() *g
+	if fn != nil {
+		p = new(func() *g)
+		*p = fn
+	}
+	atomicstorep(unsafe.Pointer(&externalRunSource), unsafe.Pointer(p))
+}
+
 // Finds a runnable goroutine to execute.
 // Tries to steal from other P's, get g from global queue, poll network.
 // 找到一个可以运行的G，不找到就让M休眠，然后等待唤醒，直到找到一个G返回
@@ -2516,7 +3830,7 @@ top:
 		runSafePointFn()
 	}
 	// fing是执行finalizer的goroutine
-	if fingwait && fingwake {
+	if fingwait && fingwake && atomic.Load(&finpaused) == 0 {
 		if gp := wakefing(); gp != nil {
 			ready(gp, 0, true)
 		}
@@ -2525,15 +3839,31 @@ top:
 		asmcgocall(*cgo_yield, nil)
 	}
 
+	// goroutines pinned to this P
+	if gp := pinqget(_p_); gp != nil {
+		return gp, false
+	}
+
 	// local runq
 	// 再尝试从本地队列中获取G
 	if gp, inheritTime := runqget(_p_); gp != nil {
 		return gp, inheritTime
 	}
 
+	// external run source
+	if fn := (*func() *g)(atomic.Loadp(unsafe.Pointer(&externalRunSource))); fn != nil {
+		if gp := (*fn)(); gp != nil {
+			casgstatus(gp, _Gwaiting, _Grunnable)
+			if trace.enabled {
+				traceGoUnpark(gp, 0)
+			}
+			return gp, false
+		}
+	}
+
 	// global runq
 	// 尝试从全局队列中获取G
-	if sched.runqsize != 0 {
+	if !globrunqempty() {
 		lock(&sched.lock)
 		gp := globrunqget(_p_, 0)
 		unlock(&sched.lock)
@@ -2550,7 +3880,7 @@ top:
 	// not set lastpoll yet), this thread will do blocking netpoll below
 	// anyway.
 	// 从网络IO轮询器中找到就绪的G，把这个G变为可运行的G
-	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && atomic.Load64(&sched.lastpoll) != 0 {
+	if netpollEnabled() && atomic.Load(&netpollWaiters) > 0 && atomic.Load64(&sched.lastpoll) != 0 {
 		if gp := netpoll(false); gp != nil { // non-blocking
 			// netpoll returns list of goroutines linked by schedlink.
 			// 如果找到的可运行的网络IO的G列表，则把相关的G插入全局队列
@@ -2574,6 +3904,11 @@ top:
 		// Neither of that submits to local run queues, so no point in stealing.
 		goto stop
 	}
+	if debug.nosteal != 0 || deterministicSched != 0 {
+		// Work stealing is disabled; see GODEBUG=nosteal and
+		// SetDeterministicScheduling.
+		goto stop
+	}
 	// If number of spinning M's >= number of busy P's, block.
 	// This is necessary to prevent excessive CPU consumption
 	// when GOMAXPROCS>>1 but the program parallelism is low.
@@ -2582,6 +3917,10 @@ top:
 	if !_g_.m.spinning && 2*atomic.Load(&sched.nmspinning) >= procs-atomic.Load(&sched.npidle) {
 		goto stop
 	}
+	// Also respect the cap set by SetSpinningLimit, if any.
+	if lim := atomic.Load(&spinningLimit); !_g_.m.spinning && lim > 0 && atomic.Load(&sched.nmspinning) >= lim {
+		goto stop
+	}
 
 	// 如果M为非自旋，那么设置为自旋状态
 	if !_g_.m.spinning {
@@ -2589,14 +3928,23 @@ top:
 		atomic.Xadd(&sched.nmspinning, 1)
 	}
 	// 随机选一个P，尝试从这P中偷取一些G
-	for i := 0; i < 4; i++ { // 尝试四次
-		for enum := stealOrder.start(fastrand()); !enum.done(); enum.next() {
+	for i := 0; i < stealAttempts; i++ { // 默认尝试四次
+		now := nanotime()
+		for enum := stealOrder.start(stealStart(_p_), _p_.numaNode, stealOrder.nodeSnapshot(_p_)); !enum.done(); enum.next() {
 			if sched.gcwaiting != 0 {
 				goto top
 			}
-			stealRunNextG := i > 2 // first look for ready queues with more than 1 g
+			stealRunNextG := i == stealAttempts-1 // first look for ready queues with more than 1 g
+			p2 := allp[enum.position()]
+			// Leave Ps we stole from recently for the last pass, so
+			// that a busy P isn't drained again and again until its
+			// own M runs out of work.
+			if !stealRunNextG && _g_.m.recentVictim(p2.id, now) {
+				continue
+			}
 			// 从allp[enum.position()]偷去一半的G，并返回其中的一个
-			if gp := runqsteal(_p_, allp[enum.position()], stealRunNextG); gp != nil {
+			if gp := runqsteal(_p_, p2, stealRunNextG); gp != nil {
+				_g_.m.noteVictim(p2.id, now)
 				return gp, false
 			}
 		}
@@ -2629,7 +3977,7 @@ stop:
 
 	// return P and block
 	lock(&sched.lock)
-	if sched.gcwaiting != 0 || _p_.runSafePointFn != 0 {
+	if sched.gcwaiting != 0 || _p_.runSafePointFn != 0 || atomic.Load(&_p_.pinqsize) != 0 {
 		unlock(&sched.lock)
 		goto top
 	}
@@ -2679,12 +4027,17 @@ stop:
 
 	// check all runqueues once again
 	// 再次检查所有的P，有没有可以运行的G
+	// With stealing disabled there is no point: we could not take
+	// the work anyway, and would spin acquiring and releasing Ps.
 	for _, _p_ := range allpSnapshot {
+		if debug.nosteal != 0 || deterministicSched != 0 {
+			break
+		}
 		// 如果p的本地队列有G
 		if !runqempty(_p_) {
 			lock(&sched.lock)
 			// 获取另外一个空闲P
-			_p_ = pidleget()
+			_p_ = pidlegetm(_g_.m)
 			unlock(&sched.lock)
 			if _p_ != nil {
 				// 如果P不是nil，将M绑定P
@@ -2704,7 +4057,7 @@ stop:
 	// Check for idle-priority GC work again.
 	if gcBlackenEnabled != 0 && gcMarkWorkAvailable(nil) {
 		lock(&sched.lock)
-		_p_ = pidleget()
+		_p_ = pidlegetm(_g_.m)
 		if _p_ != nil && _p_.gcBgMarkWorker == 0 {
 			pidleput(_p_)
 			_p_ = nil
@@ -2723,7 +4076,7 @@ stop:
 
 	// poll network
 	// 再次检查netpoll
-	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && atomic.Xchg64(&sched.lastpoll, 0) != 0 {
+	if netpollEnabled() && atomic.Load(&netpollWaiters) > 0 && atomic.Xchg64(&sched.lastpoll, 0) != 0 {
 		if _g_.m.p != 0 {
 			throw("findrunnable: netpoll with p")
 		}
@@ -2734,7 +4087,7 @@ stop:
 		atomic.Store64(&sched.lastpoll, uint64(nanotime()))
 		if gp != nil {
 			lock(&sched.lock)
-			_p_ = pidleget()
+			_p_ = pidlegetm(_g_.m)
 			unlock(&sched.lock)
 			if _p_ != nil {
 				acquirep(_p_)
@@ -2754,6 +4107,16 @@ stop:
 	goto top
 }
 
+// netpollOff stops the scheduler from polling the network. It is set
+// from GODEBUG=netpolloff in schedinit and never changes afterwards.
+var netpollOff bool
+
+// netpollEnabled reports whether the scheduler should poll the network:
+// the poller has been initialized and GODEBUG=netpolloff is not set.
+func netpollEnabled() bool {
+	return netpollinited() && !netpollOff
+}
+
 // pollWork returns true if there is non-background work this P could
 // be doing. This is a fairly lightweight check to be used for
 // background work loops, like idle GC. It checks a subset of the
@@ -2770,7 +4133,7 @@ func pollWork() bool {
 		return true
 	}
 	// 如果有网络io的G，返回true
-	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && sched.lastpoll != 0 {
+	if netpollEnabled() && atomic.Load(&netpollWaiters) > 0 && sched.lastpoll != 0 {
 		if gp := netpoll(false); gp != nil {
 			injectglist(gp)
 			return true
@@ -2805,7 +4168,19 @@ func resetspinning() {
 
 // Injects the list of runnable G's into the scheduler.
 // Can run concurrently with GC.
-// 插入G的list到全局队列
+//
+// The G's are shared out in even batches among the idle P's it
+// starts, as many as there are G's, and go straight onto their local
+// run queues instead of all onto the global queue. Only the owner of a
+// P may put G's on its local queue, but an idle P we take off the idle
+// list under sched.lock has no owner until startm hands it to an M,
+// so we fill its queue first (as readyLastP does). Stealing from it in
+// the meantime is fine, as runqsteal doesn't need the owner. A batch
+// is at most half a local queue, which an idle P's empty queue always
+// has room for, so runqput never spills to the global queue and takes
+// sched.lock under us. Whatever is left when idle P's run out goes to
+// the global queue as before.
+// 插入G的list到空闲P的本地队列，剩下的放到全局队列
 func injectglist(glist *g) {
 	if glist == nil {
 		return
@@ -2815,18 +4190,87 @@ func injectglist(glist *g) {
 			traceGoUnpark(gp, 0)
 		}
 	}
+	n := 0
+	for gp := glist; gp != nil; gp = gp.schedlink.ptr() {
+		casgstatus(gp, _Gwaiting, _Grunnable)
+		n++
+	}
+	for glist != nil && atomic.Load(&sched.npidle) != 0 {
+		lock(&sched.lock)
+		pp := pidleget()
+		npidle := int(sched.npidle)
+		unlock(&sched.lock)
+		if pp == nil {
+			break
+		}
+		// Split what's left evenly between pp and the idle P's
+		// we may start after it.
+		ps := npidle + 1
+		if ps > n {
+			ps = n
+		}
+		batch := (n + ps - 1) / ps
+		if batch > len(pp.runq)/2 {
+			batch = len(pp.runq) / 2
+		}
+		for i := 0; i < batch; i++ {
+			gp := glist
+			glist = gp.schedlink.ptr()
+			runqput(pp, gp, false)
+		}
+		n -= batch
+		startm(pp, false)
+	}
+	if glist == nil {
+		return
+	}
 	lock(&sched.lock)
-	var n int
-	for n = 0; glist != nil; n++ {
+	for glist != nil {
 		gp := glist
 		glist = gp.schedlink.ptr()
-		casgstatus(gp, _Gwaiting, _Grunnable)
 		globrunqput(gp)
 	}
 	unlock(&sched.lock)
-	for ; n != 0 && sched.npidle != 0; n-- {
-		startm(nil, false)
+}
+
+// scheduleHook, if non-nil, points to the function set by
+// SetScheduleHook. Accessed atomically.
+var scheduleHook unsafe.Pointer // *func(goid int64, inheritTime bool)
+
+// SetScheduleHook arranges for fn to be called each time the scheduler
+// has picked a goroutine to run, with the goroutine's id and whether
+// it inherits the rest of the current time slice, as it does when
+// another goroutine handed it the processor directly, for example by
+// waking it from a channel operation. Passing nil removes the hook. It
+// is meant for tracing scheduling decisions, for example to compare
+// them with a simulation.
+//
+// fn is called synchronously at the end of the scheduler, on the
+// thread that is about to run the goroutine, immediately before the
+// goroutine starts running. Calls made on one thread therefore arrive
+// in the order that thread runs goroutines, and the goroutine does not
+// run until fn has returned. Calls made on different threads may run
+// concurrently and are not ordered with respect to each other.
+//
+// fn runs on the small system stack of the thread, not on a goroutine,
+// and while the scheduler is in a state where it cannot switch
+// goroutines. It must therefore be very short and simple: it must not
+// allocate, block, use channels, locks or defer, panic, call into the
+// runtime, or need more than a few hundred bytes of stack, and it must
+// be safe to run on several threads at once. Violating these rules can
+// deadlock or crash the program without a useful error. Every
+// scheduling decision waits for fn, so a slow fn slows down the whole
+// program.
+//
+// The race detector cannot follow code that runs outside a goroutine,
+// so when it is enabled SetScheduleHook has no effect.
+func SetScheduleHook(fn func(goid int64, inheritTime bool)) {
+	var p *func(int64, bool)
+	if fn != nil && !raceenabled {
+		p = new(func(int64, bool))
+		*p = fn
 	}
+	atomicstorep(unsafe.Pointer(&scheduleHook), unsafe.Pointer(p))
 }
 
 // One round of scheduler: find a runnable goroutine and execute it.
@@ -2875,6 +4319,9 @@ top:
 
 	var gp *g
 	var inheritTime bool
+	// runtimeG is set if gp is the trace reader or a GC worker, which
+	// run whenever they are picked regardless of label limits.
+	runtimeG := false
 
 	// 开启了tools trace，则标记事件traceEvGoUnblock
 	if trace.enabled || trace.shutdown {
@@ -2882,6 +4329,7 @@ top:
 		if gp != nil {
 			casgstatus(gp, _Gwaiting, _Grunnable)
 			traceGoUnpark(gp, 0)
+			runtimeG = true
 		}
 	}
 
@@ -2889,6 +4337,7 @@ top:
 	// gcBlackenEnabled 在gc mark阶段会被置为1
 	if gp == nil && gcBlackenEnabled != 0 {
 		gp = gcController.findRunnableGCWorker(_g_.m.p.ptr())
+		runtimeG = gp != nil
 	}
 
 	// 以下都是想方设法找到可以运行的g，按照以下的顺序
@@ -2902,12 +4351,21 @@ top:
 		// by constantly respawning each other.
 		// 每隔61次调度，尝试从全局队列种获取G
 		// ? 为何是61次？ https://github.com/golang/go/issues/20168
-		if _g_.m.p.ptr().schedtick%61 == 0 && sched.runqsize > 0 {
-			lock(&sched.lock)
-			gp = globrunqget(_g_.m.p.ptr(), 1)
-			unlock(&sched.lock)
+		if _g_.m.p.ptr().schedtick%schedGlobalEvery == 0 {
+			atomic.Xadd64(&globalSampleChecks, 1)
+			if !globrunqempty() {
+				lock(&sched.lock)
+				gp = globrunqget(_g_.m.p.ptr(), 1)
+				unlock(&sched.lock)
+				if gp != nil {
+					atomic.Xadd64(&globalSampleHits, 1)
+				}
+			}
 		}
 	}
+	if gp == nil {
+		gp = pinqget(_g_.m.p.ptr())
+	}
 	if gp == nil {
 		// 从p的本地队列中获取
 		gp, inheritTime = runqget(_g_.m.p.ptr())
@@ -2929,6 +4387,12 @@ top:
 		resetspinning()
 	}
 
+	// If gp is pinned to another P, hand it over and look again.
+	if gp.pinnedP != 0 && gp.pinnedP.ptr() != _g_.m.p.ptr() {
+		pinqput(gp.pinnedP.ptr(), gp)
+		goto top
+	}
+
 	// 如果找到的G已经锁定M了，dolockOSThread和cgo会将G和M绑定
 	// 则用startlockedm执行，将P和G都交给对方lockedm，唤醒绑定M-lockedm，自己回空闲队列。
 	if gp.lockedm != 0 {
@@ -2939,6 +4403,21 @@ top:
 		goto top
 	}
 
+	// If gp's label group is at its concurrency limit, gp waits for a
+	// running member of the group to stop.
+	if !runtimeG && !labelAdmit(gp) {
+		goto top
+	}
+
+	// Keep the P's NUMA node in step with the M that now holds it.
+	if numaNodes > 1 {
+		procNUMAUpdate(_g_.m, _g_.m.p.ptr())
+	}
+
+	if fn := (*func(int64, bool))(atomic.Loadp(unsafe.Pointer(&scheduleHook))); fn != nil {
+		(*fn)(gp.goid, inheritTime)
+	}
+
 	// println("execute goroutine", gp.goid)
 	// 找到了g，那就执行g上的任务函数
 	execute(gp, inheritTime)
@@ -2963,6 +4442,9 @@ top:
 func dropg() {
 	_g_ := getg()
 
+	if _g_.m.curg.labelGroup != 0 {
+		labelRelease(_g_.m.curg)
+	}
 	setMNoWB(&_g_.m.curg.m, nil)
 	setGNoWB(&_g_.m.curg, nil)
 }
@@ -2980,6 +4462,7 @@ func park_m(gp *g) {
 	if trace.enabled {
 		traceGoPark(_g_.m.waittraceev, _g_.m.waittraceskip)
 	}
+	addCPUTime(gp, nanotime())
 	// 设置当前状态从Grunning-->Gwaiting
 	casgstatus(gp, _Grunning, _Gwaiting)
 	// 当前g放弃m
@@ -3010,6 +4493,7 @@ func goschedImpl(gp *g) {
 		dumpgstatus(gp)
 		throw("bad g status")
 	}
+	addCPUTime(gp, nanotime())
 	// 将gp的状态改为_Grunnable
 	casgstatus(gp, _Grunning, _Grunnable)
 	// 解除与当前M的关联
@@ -3022,6 +4506,13 @@ func goschedImpl(gp *g) {
 	schedule()
 }
 
+// addCPUTime charges gp for the time it has been running since
+// execute or exitsyscall, up to now. Called when gp stops running.
+//go:nosplit
+func addCPUTime(gp *g, now int64) {
+	gp.cputime += now - gp.runstart
+}
+
 // Gosched continuation on g0.
 func gosched_m(gp *g) {
 	if trace.enabled {
@@ -3043,6 +4534,38 @@ func goschedguarded_m(gp *g) {
 	goschedImpl(gp)
 }
 
+// goyieldlocal_m is the YieldN continuation on g0. Like
+// goschedguarded_m it opts out in forbidden states, but it puts gp on
+// the local run queue rather than the global one.
+func goyieldlocal_m(gp *g) {
+	if gp.m.locks != 0 || gp.m.mallocing != 0 || gp.m.preemptoff != "" || gp.m.p.ptr().status != _Prunning {
+		gogo(&gp.sched) // never return
+	}
+
+	if trace.enabled {
+		traceGoSched()
+	}
+	addCPUTime(gp, nanotime())
+	casgstatus(gp, _Grunning, _Grunnable)
+	_p_ := gp.m.p.ptr()
+	dropg()
+	// Even with a priority, gp goes behind the queued goroutines;
+	// in runnext it would run again straight away.
+	runqputnoprio(_p_, gp, false)
+	schedule()
+}
+
+// preemptpoint_m is the PreemptionPoint continuation on g0. Like
+// newstack, it leaves gp running in states where preemption is
+// forbidden; gp.preempt stays set so it is preempted later.
+func preemptpoint_m(gp *g) {
+	if gp.m.locks != 0 || gp.m.mallocing != 0 || gp.m.preemptoff != "" || gp.m.p.ptr().status != _Prunning {
+		gp.stackguard0 = gp.stack.lo + _StackGuard
+		gogo(&gp.sched) // never return
+	}
+	gopreempt_m(gp)
+}
+
 // 和gosched_m的作用是一样的
 func gopreempt_m(gp *g) {
 	if trace.enabled {
@@ -3065,6 +4588,48 @@ func goexit1() {
 	mcall(goexit0)
 }
 
+// goexitHook holds the state for SetGoroutineExitHook. goexit0 records
+// the id of each goroutine that exits in ring.
+var goexitHook struct {
+	h    hookHelper
+	ring hookRing
+	fn   func(goid int64)
+}
+
+// SetGoroutineExitHook arranges for fn to be called with the id of each
+// goroutine that finishes. Goroutines started by the runtime itself are
+// not reported. Passing nil removes the hook.
+//
+// Goroutines finish in contexts where running Go code is not safe, so
+// fn is not called inline. Instead the ids are buffered and delivered
+// in order on a dedicated goroutine, typically within a few
+// milliseconds, by which time the runtime may already have reused the
+// goroutine's resources. Reporting is best-effort: if fn falls behind,
+// the oldest ids are dropped.
+func SetGoroutineExitHook(fn func(goid int64)) {
+	lock(&goexitHook.h.lock)
+	// Don't report exits from before fn was installed.
+	goexitHook.ring.reset()
+	goexitHook.fn = fn
+	goexitHook.h.install(fn != nil, "goroutine exit hook (idle)", goexitHookWork)
+}
+
+// goexitHookWork reports goroutine exits to the hook installed by
+// SetGoroutineExitHook.
+func goexitHookWork() {
+	var evs [len(hookRing{}.buf)]hookEvent
+	goexitHook.h.lockHooks()
+	fn := goexitHook.fn
+	n := goexitHook.ring.get(&evs)
+	unlock(&goexitHook.h.lock)
+	if fn == nil {
+		return
+	}
+	for _, ev := range evs[:n] {
+		fn(ev.a)
+	}
+}
+
 // goexit continuation on g0.
 func goexit0(gp *g) {
 	_g_ := getg()
@@ -3074,6 +4639,9 @@ func goexit0(gp *g) {
 	// 如果runtime内部goroutine ngsys 减1
 	if isSystemGoroutine(gp) {
 		atomic.Xadd(&sched.ngsys, -1)
+	} else if atomic.Load(&goexitHook.h.enabled) != 0 {
+		goexitHook.ring.put(gp.goid, 0, 0)
+		goexitHook.h.notify()
 	}
 	// 状态重置
 	gp.m = nil
@@ -3084,6 +4652,15 @@ func goexit0(gp *g) {
 	_g_.m.lockedg = 0
 
 	gp.paniconfault = false
+	if gp.stackLocked {
+		gp.stackLocked = false
+		sysUnlock(unsafe.Pointer(gp.stack.lo), gp.stack.hi-gp.stack.lo)
+	}
+	gp.priority = 0
+	gp.pinnedP = 0
+	gp.lastp = 0
+	gp.cputime = 0
+	gp.schedcount = 0
 	gp._defer = nil // should be true already but just in case.
 	gp._panic = nil // non-nil for Goexit during panic. points at stack-allocated data.
 	gp.writebuf = nil
@@ -3235,6 +4812,10 @@ func reentersyscall(pc, sp uintptr) {
 	save(pc, sp)
 	_g_.syscallsp = sp
 	_g_.syscallpc = pc
+	// One clock read serves both the CPU and the system call time.
+	now := nanotime()
+	addCPUTime(_g_, now)
+	_g_.m.syscallstart = now
 	// 让G进入_Gsyscall状态，此时G已经被挂起了，直到系统调用结束，才会让G重新进入running
 	casgstatus(_g_, _Grunning, _Gsyscall)
 	// 检查栈是否超出
@@ -3354,6 +4935,9 @@ func entersyscallblock(dummy int32) {
 			throw("entersyscallblock")
 		})
 	}
+	now := nanotime()
+	addCPUTime(_g_, now)
+	_g_.m.syscallstart = now
 	casgstatus(_g_, _Grunning, _Gsyscall)
 	if _g_.syscallsp < _g_.stack.lo || _g_.stack.hi < _g_.syscallsp {
 		systemstack(func() {
@@ -3404,6 +4988,12 @@ func exitsyscall(dummy int32) {
 	}
 
 	_g_.waitsince = 0
+	// As on entry, read the clock once for both times.
+	now := nanotime()
+	if _g_.m.syscallstart != 0 {
+		_g_.m.syscalltime += now - _g_.m.syscallstart
+		_g_.m.syscallstart = 0
+	}
 	oldp := _g_.m.p.ptr()
 	// 快速路径处理，判断
 	if exitsyscallfast() {
@@ -3423,6 +5013,7 @@ func exitsyscall(dummy int32) {
 		// g的状态从syscall变成running，这样M就可以找到这个g来运行，
 		// 正常来说，g很快就能被运行
 		casgstatus(_g_, _Gsyscall, _Grunning)
+		_g_.runstart = now
 
 		// Garbage collector isn't running (since we are),
 		// so okay to clear syscallsp.
@@ -3555,7 +5146,7 @@ func exitsyscallfast_reacquired() {
 
 func exitsyscallfast_pidle() bool {
 	lock(&sched.lock)
-	_p_ := pidleget()
+	_p_ := pidlegetm(getg().m)
 	if _p_ != nil && atomic.Load(&sched.sysmonwait) != 0 {
 		atomic.Store(&sched.sysmonwait, 0)
 		notewakeup(&sched.sysmonnote)
@@ -3578,7 +5169,7 @@ func exitsyscall0(gp *g) {
 	casgstatus(gp, _Gsyscall, _Grunnable)
 	dropg()
 	lock(&sched.lock)
-	_p_ := pidleget()
+	_p_ := pidlegetm(_g_.m)
 	if _p_ == nil {
 		globrunqput(gp)
 	} else if atomic.Load(&sched.sysmonwait) != 0 {
@@ -3738,16 +5329,168 @@ func newproc(siz int32, fn *funcval) {
 	pc := getcallerpc()
 	// 用g0的栈创建G对象
 	systemstack(func() {
-		newproc1(fn, (*uint8)(argp), siz, pc)
+		newproc1(fn, (*uint8)(argp), siz, pc, false, nil, nil)
+	})
+	spawnThrottle()
+}
+
+// startGoroutinesBatch is how many goroutines startGoroutines starts
+// per trip to the system stack, where it cannot be preempted.
+const startGoroutinesBatch = 128
+
+// startGoroutines starts a goroutine for each function in fns, as if
+// by a go statement, but enqueues them in batches and wakes idle Ps
+// only once at the end rather than once per goroutine. It is meant for
+// programs that start many goroutines at once; packages outside the
+// runtime can reach it with a go:linkname directive.
+func startGoroutines(fns []func()) {
+	pc := getcallerpc()
+	for len(fns) > 0 {
+		batch := fns
+		if len(batch) > startGoroutinesBatch {
+			batch = batch[:startGoroutinesBatch]
+		}
+		fns = fns[len(batch):]
+		last := len(fns) == 0
+		systemstack(func() {
+			for _, fn := range batch {
+				newproc1(*(**funcval)(unsafe.Pointer(&fn)), nil, 0, pc, true, nil, nil)
+			}
+			// A single spinning M is enough: when it finds work it
+			// wakes another, until the idle Ps are used up.
+			if last && atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 && mainStarted {
+				wakep()
+			}
+		})
+		if !last {
+			// Let the scheduler and the GC have us between batches.
+			PreemptionPoint()
+		}
+	}
+	spawnThrottle()
+}
+
+// HandoffTo starts a goroutine running fn, as if by a go statement,
+// but queues it directly on the P (logical processor) with the given
+// id, in the range [0, GOMAXPROCS), rather than on the caller's P. It
+// is meant for pipelines whose stages each keep to one P, so that
+// stage N can pass work to the P running stage N+1 without going
+// through the global run queue. HandoffTo returns an error if pid is
+// out of range.
+//
+// The goroutine goes to the target P's incoming queue, the same one
+// that receives goroutines pinned to it by PinToP. Its owner drains
+// that queue in schedule and findrunnable, ahead of its local run
+// queue, and an idle target P is started right away. Pushes take the
+// P's lock and the owner takes it again to pop, so everything the
+// caller did before HandoffTo happens before fn starts; the atomic
+// queue length only lets the owner skip the lock when the queue is
+// empty.
+//
+// Other Ps do not steal from the incoming queue, so the goroutine
+// first runs on the target P, unless a change to GOMAXPROCS removes
+// that P before then, in which case the goroutine is moved to the
+// global run queue. The goroutine is not pinned, though: once it has
+// started it is scheduled like any other goroutine and may later run
+// on other Ps.
+func HandoffTo(pid int, fn func()) error {
+	pc := getcallerpc()
+	var err error
+	systemstack(func() {
+		// GOMAXPROCS cannot change while we are on the system stack.
+		if pid < 0 || pid >= int(gomaxprocs) {
+			err = errorString("HandoffTo: P id out of range")
+			return
+		}
+		newproc1(*(**funcval)(unsafe.Pointer(&fn)), nil, 0, pc, false, allp[pid], nil)
+	})
+	if err == nil {
+		spawnThrottle()
+	}
+	return err
+}
+
+// globalQueueSoftLimit is the limit set by SetGlobalQueueSoftLimit,
+// or 0 if there is none.
+var globalQueueSoftLimit uint32
+
+// SetGlobalQueueSoftLimit sets a soft limit of n on the number of
+// goroutines waiting in the global run queue, where goroutines go
+// when a processor's own queue is full. While the queue is over the
+// limit, a goroutine that starts another goroutine yields its
+// processor afterwards and waits its turn behind the queued work,
+// which slows down storms of goroutine creation until the scheduler
+// catches up. The limit is best-effort: the queue can still grow past
+// it, for example when goroutines are started from code that cannot
+// yield. n <= 0 removes the limit, which is the default.
+func SetGlobalQueueSoftLimit(n int) {
+	if n <= 0 {
+		n = 0
+	} else if n > 1<<31-1 {
+		n = 1<<31 - 1
+	}
+	atomic.Store(&globalQueueSoftLimit, uint32(n))
+}
+
+// spawnThrottle yields the processor after the caller started a
+// goroutine if the global run queue is over the soft limit. The read
+// of sched.runqsize is racy, which is fine for a soft limit.
+//go:nosplit
+func spawnThrottle() {
+	limit := atomic.Load(&globalQueueSoftLimit)
+	if limit == 0 || uint32(sched.runqsize) <= limit {
+		return
+	}
+	// Runtime goroutines may be started on g0.
+	if gp := getg(); gp == gp.m.curg {
+		goschedguarded()
+	}
+}
+
+// injectReady makes the goroutines in gs runnable in one batch,
+// starting Ms for as many idle Ps as there are goroutines. It is meant
+// for frameworks that park goroutines themselves and wake them from an
+// event loop, including from Go code called back from C on a thread
+// the runtime did not create; since g is not exported, packages
+// outside the runtime reach it with a go:linkname directive.
+//
+// Each goroutine must have been parked with gopark (and so be in
+// _Gwaiting) by the caller's framework, which then owns it: nothing
+// else, such as a channel, timer or the network poller, may be able
+// to ready it, and it must appear in gs only once. Once injectReady
+// returns, the goroutines belong to the scheduler again. gs itself is
+// not retained.
+func injectReady(gs []*g) {
+	if len(gs) == 0 {
+		return
+	}
+	systemstack(func() {
+		var glist *g
+		for i := len(gs) - 1; i >= 0; i-- {
+			gp := gs[i]
+			if readgstatus(gp)&^_Gscan != _Gwaiting {
+				dumpgstatus(gp)
+				throw("injectReady: goroutine is not waiting")
+			}
+			gp.schedlink.set(glist)
+			glist = gp
+		}
+		injectglist(glist)
 	})
 }
 
 // Create a new g running fn with narg bytes of arguments starting
 // at argp. callerpc is the address of the go statement that created
 // this. The new g is put on the queue of g's waiting to run.
+// If batch is set, the new g goes to the back of the queue and no
+// idle P is woken for it; the caller is expected to call wakep.
+// If target is not nil, the new g goes to target's incoming queue
+// instead (see HandoffTo). If lockm is not nil, the new g is locked to
+// lockm, as if by lockOSThread, and returned instead of queued; the
+// caller must run it on lockm.
 // 根据函数参数和函数地址，创建一个新的G，然后将这个G加入队列等待运行
 // callerpc是newproc函数的pc
-func newproc1(fn *funcval, argp *uint8, narg int32, callerpc uintptr) {
+func newproc1(fn *funcval, argp *uint8, narg int32, callerpc uintptr, batch bool, target *p, lockm *m) *g {
 	// print("fn=", fn.fn, " argp=", argp, " narg=", narg, " callerpc=", callerpc, "\n")
 	_g_ := getg() // g0
 
@@ -3839,12 +5582,13 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callerpc uintptr) {
 	newg.gopc = callerpc
 	// 任务函数的地址
 	newg.startpc = fn.fn
-	if _g_.m.curg != nil {
-		newg.labels = _g_.m.curg.labels
-	}
 	// 判断g的任务函数是不是runtime系统的任务函数，是则sched.ngsys加1
+	// Runtime goroutines do not inherit labels, so they are never
+	// held back by a label concurrency limit.
 	if isSystemGoroutine(newg) {
 		atomic.Xadd(&sched.ngsys, +1)
+	} else if _g_.m.curg != nil {
+		newg.labels = _g_.m.curg.labels
 	}
 	newg.gcscanvalid = false
 	// 更改当前g的状态为_Grunnable
@@ -3852,11 +5596,11 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callerpc uintptr) {
 
 	if _p_.goidcache == _p_.goidcacheend {
 		// Sched.goidgen is the last allocated id,
-		// this batch must be [sched.goidgen+1, sched.goidgen+GoidCacheBatch].
+		// this batch must be [sched.goidgen+1, sched.goidgen+goidCacheBatch].
 		// At startup sched.goidgen=0, so main goroutine receives goid=1.
-		_p_.goidcache = atomic.Xadd64(&sched.goidgen, _GoidCacheBatch)
-		_p_.goidcache -= _GoidCacheBatch - 1
-		_p_.goidcacheend = _p_.goidcache + _GoidCacheBatch
+		_p_.goidcache = atomic.Xadd64(&sched.goidgen, int64(goidCacheBatch))
+		_p_.goidcache -= goidCacheBatch - 1
+		_p_.goidcacheend = _p_.goidcache + goidCacheBatch
 	}
 	// 生成唯一的goid
 	newg.goid = int64(_p_.goidcache)
@@ -3870,11 +5614,31 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callerpc uintptr) {
 	}
 
 	// println("new goroutine", newg.goid)
+	if lockm != nil {
+		lockm.lockedInt++
+		lockm.lockedg.set(newg)
+		newg.lockedm.set(lockm)
+		_g_.m.locks--
+		if _g_.m.locks == 0 && _g_.preempt {
+			_g_.stackguard0 = stackPreempt
+		}
+		return newg
+	}
+	if target != nil {
+		// pinqput wakes target itself if it is idle.
+		pinqput(target, newg)
+		_g_.m.locks--
+		if _g_.m.locks == 0 && _g_.preempt {
+			_g_.stackguard0 = stackPreempt
+		}
+		return newg
+	}
+
 	// 将当前新生成的g，放入队列
-	runqput(_p_, newg, true)
+	runqput(_p_, newg, !batch)
 
 	// 如果有空闲的p 且 m没有处于自旋状态 且 main goroutine已经启动，那么唤醒某个m来执行任务
-	if atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 && mainStarted {
+	if !batch && atomic.Load(&sched.npidle) != 0 && atomic.Load(&sched.nmspinning) == 0 && mainStarted {
 		// 如果还有空闲P，那么新建M来运行G
 		print("wakeup ", newg.goid, " ", sched.npidle, " ", sched.nmspinning, "\n")
 		wakep()
@@ -3883,6 +5647,7 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callerpc uintptr) {
 	if _g_.m.locks == 0 && _g_.preempt { // restore the preemption request in case we've cleared it in newstack
 		_g_.stackguard0 = stackPreempt
 	}
+	return newg
 }
 
 // Put on gfree list.
@@ -4038,6 +5803,132 @@ func LockOSThread() {
 	dolockOSThread()
 }
 
+// SetGoroutinePriority sets the scheduling priority of the calling
+// goroutine. Level 0, the default, is normal priority; any level
+// above 0 marks the goroutine as latency-critical. Levels above 255
+// are treated as 255.
+//
+// The priority is only a hint to the local run queue: when a
+// high-priority goroutine becomes runnable it is placed in its P's
+// next-to-run slot ahead of normal goroutines, and a lower-priority
+// goroutine never displaces a higher-priority one from that slot.
+// This is not a strict priority scheduler. Goroutines on the global
+// run queue are still picked up periodically, so normal-priority
+// goroutines are not starved indefinitely.
+func SetGoroutinePriority(level int) {
+	if level < 0 {
+		level = 0
+	} else if level > 255 {
+		level = 255
+	}
+	getg().priority = uint8(level)
+}
+
+// PinToP pins the calling goroutine to the P (logical processor) with
+// the given id, in the range [0, GOMAXPROCS). From then on the
+// scheduler only runs the goroutine on that P: if another P picks it
+// up, it hands the goroutine over to the target P instead of running
+// it. A negative pid removes the pin. PinToP returns an error if pid
+// is not less than GOMAXPROCS.
+//
+// This is similar to LockOSThread, but at the granularity of a P
+// rather than an OS thread. A change to GOMAXPROCS that removes the
+// target P clears the pin.
+func PinToP(pid int) error {
+	mp := acquirem() // don't let GOMAXPROCS change underfoot
+	gp := mp.curg
+	if pid < 0 {
+		gp.pinnedP = 0
+		releasem(mp)
+		return nil
+	}
+	if pid >= int(gomaxprocs) {
+		releasem(mp)
+		return errorString("PinToP: P id out of range")
+	}
+	gp.pinnedP.set(allp[pid])
+	moved := mp.p.ptr().id != int32(pid)
+	releasem(mp)
+	if moved {
+		// Reschedule so we end up on the target P.
+		Gosched()
+	}
+	return nil
+}
+
+// PinPToCurrentM binds the P (logical processor) the calling
+// goroutine is running on to the OS thread it is running on, and
+// returns the P's id. From then on only that thread runs the P: if
+// the thread blocks in a system call the P waits for it instead of
+// being handed to another thread, and if the P runs out of work the
+// thread parks holding on to it rather than joining the pool of idle
+// threads. This is like LockOSThread, but for a P rather than a
+// goroutine. Goroutines still move freely between Ps; use
+// LockOSThread or PinToP as well to keep one on the pair.
+//
+// An idle bound P is not woken for new work in general, only for
+// goroutines pinned or handed to it. While its thread is in a system
+// call, goroutines queued on it move to the global run queue.
+//
+// The binding survives stopping the world. Any change to GOMAXPROCS
+// undoes all bindings, as does the thread exiting; UnpinP undoes a
+// single one. PinPToCurrentM returns an error if the thread or the P
+// is already bound elsewhere, or if binding would leave no unbound
+// P, which the runtime needs to hand goroutines locked to other
+// threads over to them.
+func PinPToCurrentM() (int, error) {
+	mp := acquirem()
+	pp := mp.p.ptr()
+	var err error
+	lock(&sched.lock)
+	switch {
+	case mp.boundp.ptr() == pp:
+		// Already bound to each other.
+	case mp.boundp != 0 || pp.boundm != 0:
+		err = errorString("PinPToCurrentM: thread or P already bound")
+	case sched.nboundp+1 >= gomaxprocs:
+		err = errorString("PinPToCurrentM: no unbound P would be left")
+	default:
+		mp.boundp.set(pp)
+		pp.boundm.set(mp)
+		sched.nboundp++
+	}
+	unlock(&sched.lock)
+	releasem(mp)
+	if err != nil {
+		return -1, err
+	}
+	return int(pp.id), nil
+}
+
+// UnpinP undoes PinPToCurrentM for the P with the given id, after
+// which any thread may run it again. It does nothing if the P is not
+// bound, and returns an error if pid is not in the range
+// [0, GOMAXPROCS).
+func UnpinP(pid int) error {
+	mp := acquirem() // don't let GOMAXPROCS change underfoot
+	if pid < 0 || pid >= int(gomaxprocs) {
+		releasem(mp)
+		return errorString("UnpinP: P id out of range")
+	}
+	pp := allp[pid]
+	lock(&sched.lock)
+	if bm := pp.boundm.ptr(); bm != nil {
+		unbindp(bm)
+	}
+	if pp.status == _Pidle && atomic.Load(&pp.pinqsize) != 0 && pidleremove(pp) {
+		// pp may have been left waiting for its thread with pinned
+		// goroutines; any thread will do now.
+		unlock(&sched.lock)
+		startm(pp, false)
+		releasem(mp)
+		return nil
+	}
+	unlock(&sched.lock)
+	releasem(mp)
+	return nil
+}
+
 //go:nosplit
 // lockOSThread 实现 g 和 m 的绑定
 func lockOSThread() {
@@ -4092,6 +5983,17 @@ func unlockOSThread() {
 	dounlockOSThread()
 }
 
+// IsLockedToThread reports whether the calling goroutine is wired to
+// its current operating system thread. It is true from the first
+// LockOSThread call until the matching number of UnlockOSThread calls,
+// however deeply the calls nest. It is also true while the runtime
+// itself keeps the goroutine on its thread, such as during package
+// initialization in the main goroutine and in calls from C to Go.
+//go:nosplit
+func IsLockedToThread() bool {
+	return lockedOSThread()
+}
+
 func badunlockosthread() {
 	throw("runtime: internal error: misuse of lockOSThread/unlockOSThread")
 }
@@ -4114,6 +6016,10 @@ func mcount() int32 {
 	return int32(sched.mnext - sched.nmfreed)
 }
 
+// peakmcount is the largest value mcount has had. Protected by
+// sched.lock; see PeakThreadCount.
+var peakmcount int32
+
 var prof struct {
 	signalLock uint32
 	hz         int32
@@ -4384,7 +6290,100 @@ func setcpuprofilerate(hz int32) {
 		setThreadCPUProfiler(hz)
 	}
 
-	_g_.m.locks--
+	_g_.m.locks--
+}
+
+// newP allocates and initializes a P with the given id. The caller
+// must add it to allp.
+func newP(id int32) *p {
+	pp := new(p)
+	pp.id = id
+	pp.numaNode = -1     // set by procNUMAUpdate
+	pp.status = _Pgcstop // 更改状态
+	pp.sudogcache = make([]*sudog, 0, sudogCacheSize)
+	for i := range pp.deferpool {
+		pp.deferpool[i] = pp.deferpoolbuf[i][:0]
+	}
+	pp.wbBuf.reset()
+	return pp
+}
+
+// preparedPs holds Ps and mcaches allocated by preparePs for the
+// next procresize, indexed by P id. Protected by worldsema.
+var preparedPs struct {
+	ps      []*p
+	mcaches []*mcache
+}
+
+// preparePs allocates the Ps and mcaches that procresize will need to
+// grow to nprocs Ps, so that work is done while the world is still
+// running rather than during the stop. It returns them for the caller
+// to store in preparedPs once it holds worldsema. It must not be
+// called with worldsema held, since allocating can start a GC.
+//
+// allp may change before the caller gets worldsema, so the result is
+// only a hint: procresize allocates whatever is missing and frees
+// mcaches it does not use.
+//
+// Publishing the new Ps still has to happen with the world stopped:
+// the scheduler and the garbage collector read allp and gomaxprocs
+// without locks and rely on them changing only at safe points.
+func preparePs(nprocs int32) (ps []*p, mcaches []*mcache) {
+	lock(&allpLock)
+	n := int32(len(allp))
+	spare := allp[n:cap(allp)]
+	unlock(&allpLock)
+	if nprocs <= n {
+		return nil, nil
+	}
+	ps = make([]*p, nprocs)
+	mcaches = make([]*mcache, nprocs)
+	for i := n; i < nprocs; i++ {
+		// Ps dropped by an earlier shrink are still in allp's
+		// spare capacity and only need a new mcache.
+		if i-n >= int32(len(spare)) || spare[i-n] == nil {
+			ps[i] = newP(i)
+		}
+		systemstack(func() {
+			mcaches[i] = allocmcache()
+		})
+	}
+	return ps, mcaches
+}
+
+// runqputsurvivor puts gp, taken from a P that procresize is
+// destroying, on the local run queue of one of allp[:nprocs], trying
+// them in turn starting at *target. If next is set, gp goes in the
+// runnext slot if it is free, or else at the head of the queue;
+// otherwise it goes at the tail. It reports false if every queue is
+// full. The world must be stopped.
+func runqputsurvivor(gp *g, nprocs int32, target *int32, next bool) bool {
+	if next {
+		for i := int32(0); i < nprocs; i++ {
+			pp := allp[(*target+i)%nprocs]
+			if pp.runnext == 0 {
+				pp.runnext.set(gp)
+				*target = (*target + i + 1) % nprocs
+				return true
+			}
+		}
+	}
+	for i := int32(0); i < nprocs; i++ {
+		pp := allp[*target]
+		*target = (*target + 1) % nprocs
+		if pp.runqtail-pp.runqhead >= uint32(len(pp.runq)) {
+			continue
+		}
+		if next {
+			pp.runqhead--
+			pp.runq[pp.runqhead%uint32(len(pp.runq))].set(gp)
+		} else {
+			pp.runq[pp.runqtail%uint32(len(pp.runq))].set(gp)
+			pp.runqtail++
+		}
+		return true
+	}
+	return false
 }
 
 // Change number of processors. The world is stopped, sched is locked.
@@ -4403,6 +6402,13 @@ func procresize(nprocs int32) *p {
 		traceGomaxprocs(nprocs)
 	}
 
+	if nprocs > 1 {
+		// With a single P there is nothing to steal from, so
+		// don't look up the topology; see procNUMAInit. Do it
+		// before acquirep below records the node of this M.
+		procNUMAInit()
+	}
+
 	// update statistics
 	// 更新全局状态统计
 	now := nanotime()
@@ -4411,6 +6417,32 @@ func procresize(nprocs int32) *p {
 	}
 	sched.procresizetime = now
 
+	// A real change to GOMAXPROCS undoes all P bindings (see
+	// PinPToCurrentM). It also clears pins to the Ps it removes (see
+	// PinToP) and moves goroutines waiting on their pin queues to the
+	// global queue.
+	if nprocs != old {
+		if nprocs < old {
+			lock(&allglock)
+			for _, gp := range allgs {
+				if pp := gp.pinnedP.ptr(); pp != nil && pp.id >= nprocs {
+					gp.pinnedP = 0
+				}
+			}
+			unlock(&allglock)
+			for _, p := range allp[nprocs:] {
+				for gp := pinqget(p); gp != nil; gp = pinqget(p) {
+					globrunqput(gp)
+				}
+			}
+		}
+		for _, p := range allp {
+			if mp := p.boundm.ptr(); mp != nil {
+				unbindp(mp)
+			}
+		}
+	}
+
 	// Grow allp if necessary.
 	if nprocs > int32(len(allp)) {
 		// Synchronize with retake, which could be running
@@ -4435,14 +6467,12 @@ func procresize(nprocs int32) *p {
 		pp := allp[i]
 		// 如果p是nil，进行初始化
 		if pp == nil {
-			pp = new(p)
-			pp.id = i
-			pp.status = _Pgcstop            // 更改状态
-			pp.sudogcache = pp.sudogbuf[:0] //将sudogcache指向sudogbuf的起始地址
-			for i := range pp.deferpool {
-				pp.deferpool[i] = pp.deferpoolbuf[i][:0]
+			if i < int32(len(preparedPs.ps)) && preparedPs.ps[i] != nil {
+				pp = preparedPs.ps[i]
+				preparedPs.ps[i] = nil
+			} else {
+				pp = newP(i)
 			}
-			pp.wbBuf.reset()
 			// 将pp保存到allp数组里, allp[i] = pp
 			atomicstorep(unsafe.Pointer(&allp[i]), unsafe.Pointer(pp))
 		}
@@ -4452,6 +6482,9 @@ func procresize(nprocs int32) *p {
 					throw("missing mcache?")
 				}
 				pp.mcache = getg().m.mcache // bootstrap
+			} else if i < int32(len(preparedPs.mcaches)) && preparedPs.mcaches[i] != nil {
+				pp.mcache = preparedPs.mcaches[i]
+				preparedPs.mcaches[i] = nil
 			} else {
 				pp.mcache = allocmcache()
 			}
@@ -4466,7 +6499,18 @@ func procresize(nprocs int32) *p {
 		}
 	}
 
+	// Anything prepared for a different count is no longer needed.
+	for i, c := range preparedPs.mcaches {
+		if c != nil {
+			freemcache(c)
+			preparedPs.mcaches[i] = nil
+		}
+	}
+	preparedPs.ps = nil
+	preparedPs.mcaches = nil
+
 	// free unused P's
+	var target int32 // next surviving P to give work to
 	for i := nprocs; i < old; i++ {
 		p := allp[i]
 		if trace.enabled && p == getg().m.p.ptr() {
@@ -4475,7 +6519,26 @@ func procresize(nprocs int32) *p {
 			traceGoSched()
 			traceProcStop(p)
 		}
-		// move all runnable goroutines to the global queue
+		// Move runnable goroutines to the local queues of the
+		// surviving Ps, in turn, to keep them near the front.
+		// runnext goes where it will run next; the queue keeps
+		// its order. Whatever does not fit goes to the head of
+		// the global queue, runnext first.
+		var next *g
+		if p.runnext != 0 {
+			next = p.runnext.ptr()
+			p.runnext = 0
+			if runqputsurvivor(next, nprocs, &target, true) {
+				next = nil
+			}
+		}
+		for p.runqhead != p.runqtail {
+			gp := p.runq[p.runqhead%uint32(len(p.runq))].ptr()
+			if !runqputsurvivor(gp, nprocs, &target, false) {
+				break
+			}
+			p.runqhead++
+		}
 		for p.runqhead != p.runqtail {
 			// pop from tail of local queue
 			p.runqtail--
@@ -4483,9 +6546,8 @@ func procresize(nprocs int32) *p {
 			// push onto head of global queue
 			globrunqputhead(gp)
 		}
-		if p.runnext != 0 {
-			globrunqputhead(p.runnext.ptr())
-			p.runnext = 0
+		if next != nil {
+			globrunqputhead(next)
 		}
 		// if there's a background worker, make it runnable and put
 		// it on the global queue so it can clean itself up
@@ -4504,10 +6566,10 @@ func procresize(nprocs int32) *p {
 			wbBufFlush1(p)
 			p.gcw.dispose()
 		}
-		for i := range p.sudogbuf {
-			p.sudogbuf[i] = nil
+		for i := range p.sudogcache {
+			p.sudogcache[i] = nil
 		}
-		p.sudogcache = p.sudogbuf[:0]
+		p.sudogcache = p.sudogcache[:0]
 		for i := range p.deferpool {
 			for j := range p.deferpoolbuf[i] {
 				p.deferpoolbuf[i][j] = nil
@@ -4565,16 +6627,34 @@ func procresize(nprocs int32) *p {
 			continue
 		}
 		p.status = _Pidle
-		if runqempty(p) { // 将空闲p放入空闲链表
+		if mp := p.boundm.ptr(); mp != nil && mp.boundready {
+			// startlockedm left mp's locked goroutine to it while p
+			// was stopped; mp waits in stoplockedm.
+			mp.boundready = false
+			sched.nmidlelocked--
+			p.m.set(mp)
+			p.link.set(runnablePs)
+			runnablePs = p
+		} else if runqempty(p) && atomic.Load(&p.pinqsize) == 0 { // 将空闲p放入空闲链表
 			pidleput(p)
+		} else if mp := p.boundm.ptr(); mp != nil && !mp.boundparked {
+			// The M p is bound to is in a system call.
+			idleboundp(p)
 		} else {
-			p.m.set(mget())
+			if mp != nil {
+				mp.boundparked = false
+				sched.nmidlebound--
+			} else {
+				mp = mget()
+			}
+			p.m.set(mp)
 			// ? 为什么不先runnablePs = p，再p.link.set(runnablePs)，效果应该是一样的
 			p.link.set(runnablePs)
 			runnablePs = p
 		}
 	}
 	stealOrder.reset(uint32(nprocs))
+	stealOrder.resetNodes(allp, numaNodes > 1)
 	var int32p *int32 = &gomaxprocs // make compiler check that gomaxprocs is an int32
 	atomic.Store((*uint32)(unsafe.Pointer(int32p)), uint32(nprocs))
 	return runnablePs
@@ -4680,12 +6760,12 @@ func checkdead() {
 
 	// m个数-没事做的m-被锁的m-runtime自身使用的m（如：sysmon）
 	// 也就是正在运行的m的个数
-	run := mcount() - sched.nmidle - sched.nmidlelocked - sched.nmsys
+	run := mcount() - sched.nmidle - sched.nmidlelocked - sched.nmidlebound - sched.nmsys
 	if run > 0 {
 		return
 	}
 	if run < 0 {
-		print("runtime: checkdead: nmidle=", sched.nmidle, " nmidlelocked=", sched.nmidlelocked, " mcount=", mcount(), " nmsys=", sched.nmsys, "\n")
+		print("runtime: checkdead: nmidle=", sched.nmidle, " nmidlelocked=", sched.nmidlelocked, " nmidlebound=", sched.nmidlebound, " mcount=", mcount(), " nmsys=", sched.nmsys, "\n")
 		throw("checkdead: inconsistent counts")
 	}
 
@@ -4733,10 +6813,53 @@ func checkdead() {
 		return
 	}
 
+	if debug.deadlockdetail > 0 {
+		dumpBlockedGoroutines()
+	}
 	getg().m.throwing = -1 // do not dump full stacks
 	throw("all goroutines are asleep - deadlock!")
 }
 
+// dumpBlockedGoroutines prints one line per user goroutine with its
+// id, status and wait reason. It is used by checkdead when
+// GODEBUG=deadlockdetail=1.
+// Called with sched.lock held, so it only prints and takes allglock,
+// in the same order as checkdead.
+func dumpBlockedGoroutines() {
+	print("runtime: blocked goroutines:\n")
+	lock(&allglock)
+	for _, gp := range allgs {
+		if isSystemGoroutine(gp) {
+			continue
+		}
+		s := readgstatus(gp) &^ _Gscan
+		if s == _Gdead || s == _Gidle {
+			continue
+		}
+		status := "???"
+		if s < uint32(len(gStatusStrings)) {
+			status = gStatusStrings[s]
+		}
+		print("goroutine ", gp.goid, " [", status, "]")
+		if gp.waitreason != "" {
+			print(": ", gp.waitreason)
+		}
+		print("\n")
+	}
+	unlock(&allglock)
+}
+
+// scavengeLimitNS is how long, in nanoseconds, a heap span must go
+// unused after a garbage collection before sysmon returns it to the
+// operating system, unless GODEBUG=scavengelimitns overrides it.
+// sysmon checks for such spans every half of this. Overrides below
+// minScavengeLimitNS are raised to it, to keep sysmon from walking
+// the heap's free lists on every wakeup.
+const (
+	scavengeLimitNS    = 5 * 60 * 1e9
+	minScavengeLimitNS = 1e6
+)
+
 // forcegcperiod is the maximum time in nanoseconds between garbage
 // collections. If we go this long without a garbage collection, one
 // is forced to run.
@@ -4744,6 +6867,53 @@ func checkdead() {
 // This is a variable for testing purposes. It normally doesn't change.
 var forcegcperiod int64 = 2 * 60 * 1e9 // 2min
 
+// sysmonPaused is set by SetSysmonPaused.
+var sysmonPaused uint32
+
+// SetSysmonPaused pauses or resumes the system monitor, a background
+// thread that wakes up every 20 microseconds to 10 milliseconds to
+// look after the scheduler and the heap. It is meant only for
+// controlled experiments, such as microbenchmarks that want to remove
+// the jitter these wakeups cause, and is refused with an error unless
+// the program runs with GODEBUG=sysmonpause=1.
+//
+// While the monitor is paused its thread keeps sleeping in its usual
+// loop, at the longest interval, but does nothing else. In particular:
+//
+//   - Goroutines that run without yielding are never preempted, so a
+//     single busy loop can keep other goroutines off its processor
+//     indefinitely and stall garbage collection, which has to stop
+//     every goroutine.
+//   - A goroutine blocked in a system call or in C code keeps its
+//     processor, so other goroutines cannot run on it until the call
+//     returns.
+//   - Garbage collection is no longer forced every two minutes, and
+//     unused heap memory is no longer returned to the operating system.
+//   - The network poller is only checked when a processor runs out of
+//     work, so network readiness can be noticed late on a busy program.
+//   - Hooks that rely on the monitor to wake them, such as the
+//     callback set by SetIdleCallback, stop being called, and GODEBUG
+//     schedtrace output stops.
+//
+// Deadlock detection ("all goroutines are asleep") does not depend on
+// the monitor and keeps working. The program must not depend on any of
+// the above while the monitor is paused; a program that does can hang.
+//
+// Resuming takes effect within one sleep interval, after which the
+// monitor runs as before. Calls do not nest: one call with paused
+// false undoes any number of calls with paused true.
+func SetSysmonPaused(paused bool) error {
+	if debug.sysmonpause == 0 {
+		return errorString("SetSysmonPaused: requires GODEBUG=sysmonpause=1")
+	}
+	if paused {
+		atomic.Store(&sysmonPaused, 1)
+	} else {
+		atomic.Store(&sysmonPaused, 0)
+	}
+	return nil
+}
+
 // Always runs without a P, so write barriers are not allowed.
 //
 //go:nowritebarrierrec
@@ -4761,8 +6931,9 @@ func sysmon() {
 	unlock(&sched.lock)
 
 	// If a heap span goes unused for 5 minutes after a garbage collection,
-	// we hand it back to the operating system.
-	scavengelimit := int64(5 * 60 * 1e9)
+	// we hand it back to the operating system, unless
+	// GODEBUG=scavengelimitns=X says otherwise.
+	scavengelimit := debug.scavengelimitns
 
 	// 	scavenge: scavenge=1 enables debugging mode of heap scavenger.
 	// 如果设置了scavenge=1，那么开启debugging
@@ -4774,22 +6945,81 @@ func sysmon() {
 
 	lastscavenge := nanotime()
 	nscavenge := 0
+	lastmpool := int64(0)
+	lastreap := int64(0)
+
+	// Sleep bounds, 20us to 10ms unless overridden by
+	// GODEBUG=sysmonminus=X,sysmonmaxus=Y.
+	minDelay, maxDelay := uint32(20), uint32(10*1000)
+	if debug.sysmonminus > 0 {
+		minDelay = uint32(debug.sysmonminus)
+	}
+	if debug.sysmonmaxus > 0 {
+		maxDelay = uint32(debug.sysmonmaxus)
+	}
+	if minDelay > maxDelay {
+		// The ceiling bounds preemption latency, so it wins.
+		minDelay = maxDelay
+	}
 
 	lasttrace := int64(0)
 	idle := 0 // how many cycles in succession we had not wokeup somebody
 	delay := uint32(0)
+	wasBusy := false      // seen busy since the idle callback last ran
+	idleSince := int64(0) // when first seen idle after being busy
 	for {
-		if idle == 0 { // start with 20us sleep...
-			delay = 20
+		if atomic.Load(&sysmonPaused) != 0 {
+			// Skip all the work below; see SetSysmonPaused.
+			usleep(maxDelay)
+			idle = 0
+			continue
+		}
+		if idle == 0 { // start with minDelay (20us) sleep...
+			delay = minDelay
 		} else if idle > 50 { // start doubling the sleep after 1ms...
 			delay *= 2
 		}
-		if delay > 10*1000 { // up to 10ms
-			delay = 10 * 1000
+		if delay > maxDelay { // up to maxDelay (10ms)
+			delay = maxDelay
 		}
 		// 休眠delay us
 		usleep(delay)
-		if debug.schedtrace <= 0 && (sched.gcwaiting != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs)) {
+		// Notice the program going idle for SetIdleCallback. This
+		// must happen before sysmon itself goes to sleep below.
+		// Activity while the callback runs doesn't count as busy,
+		// or the callback would keep retriggering itself. Short
+		// lulls between bursts of work don't count as idle.
+		if atomic.Load(&idleCallback.h.idle) != 0 {
+			if atomic.Load(&sched.npidle) != uint32(gomaxprocs) {
+				wasBusy = true
+				idleSince = 0
+			} else if wasBusy && atomic.Load(&idleCallback.h.enabled) != 0 {
+				now := nanotime()
+				if idleSince == 0 {
+					idleSince = now
+				} else if now-idleSince >= idleCallbackDelay {
+					wasBusy = false
+					idleSince = 0
+					idleCallback.h.notify()
+				}
+			}
+		}
+		// Wake the helpers that have work, such as hooks to call
+		// with events recorded where Go code can't run.
+		for _, h := range hookHelpers {
+			if h.due() {
+				h.wake()
+			}
+		}
+		// Stacks often grow, and goroutines exit, just before a
+		// program goes idle, so stay awake to hand
+		// those reports to the hooks' helpers.
+		hooksPending := atomic.Load(&stackGrowthHook.h.pending) != 0 || atomic.Load(&goexitHook.h.pending) != 0
+		// Likewise while waiting out the idle callback's delay.
+		if wasBusy && atomic.Load(&idleCallback.h.idle) != 0 && atomic.Load(&idleCallback.h.enabled) != 0 {
+			hooksPending = true
+		}
+		if debug.schedtrace <= 0 && (sched.gcwaiting != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs)) && !hooksPending {
 			lock(&sched.lock)
 			if atomic.Load(&sched.gcwaiting) != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs) {
 				atomic.Store(&sched.sysmonwait, 1)
@@ -4819,7 +7049,7 @@ func sysmon() {
 				atomic.Store(&sched.sysmonwait, 0)
 				noteclear(&sched.sysmonnote)
 				idle = 0
-				delay = 20
+				delay = minDelay
 			}
 			unlock(&sched.lock)
 		}
@@ -4832,7 +7062,7 @@ func sysmon() {
 		now := nanotime()
 		// 如果超过10ms都没进行 netpoll ，那么强制执行一次 netpoll，
 		// 并且如果获取到了可运行的G，那么插入全局列表。
-		if netpollinited() && lastpoll != 0 && lastpoll+10*1000*1000 < now {
+		if netpollEnabled() && lastpoll != 0 && lastpoll+10*1000*1000 < now {
 			atomic.Cas64(&sched.lastpoll, uint64(lastpoll), uint64(now))
 			gp := netpoll(false) // non-blocking - returns list of goroutines
 			if gp != nil {
@@ -4861,16 +7091,36 @@ func sysmon() {
 		// 检查是否超过2min未触发gc，如果是，那么强制触发gc
 		if t := (gcTrigger{kind: gcTriggerTime, now: now}); t.test() && atomic.Load(&forcegc.idle) != 0 {
 			lock(&forcegc.lock)
-			forcegc.idle = 0
-			forcegc.g.schedlink = 0
-			// forcegc.g = forcegchelper
-			injectglist(forcegc.g)
+			// Recheck: ForceGCNow may have woken the helper already.
+			if forcegc.idle != 0 {
+				forcegc.idle = 0
+				forcegc.g.schedlink = 0
+				// forcegc.g = forcegchelper
+				injectglist(forcegc.g)
+			}
 			unlock(&forcegc.lock)
 		}
 
+		// top up the GODEBUG=syscallmpool pool of idle Ms, at most
+		// every 10ms so that a pool held short by the thread limit
+		// doesn't wake the helper on every cycle
+		if debug.syscallmpool > 0 && lastmpool+10*1000*1000 < now && sched.nmidle+int32(atomic.Load(&mpool.starting)) < debug.syscallmpool && atomic.Load(&mpool.h.idle) != 0 {
+			lastmpool = now
+			mpool.h.notify()
+		}
+
+		// have Ms look up their NUMA node again, see procNUMAUpdate
+		procNUMATick(now)
+
+		// let go of excess idle Ms, see SetMaxIdleThreads
+		if lastreap+idleMReapPeriod < now && atomic.Load(&maxIdleThreads) != 0 {
+			lastreap = now
+			reapidlem()
+		}
+
 		// scavenge heap once in a while
 		if lastscavenge+scavengelimit/2 < now {
-			mheap_.scavenge(int32(nscavenge), uint64(now), uint64(scavengelimit))
+			mheap_.scavenge(int32(nscavenge), uint64(now), uint64(scavengelimit), sysUnused)
 			lastscavenge = now
 			nscavenge++
 		}
@@ -4883,16 +7133,50 @@ func sysmon() {
 }
 
 type sysmontick struct {
-	schedtick   uint32
+	// schedwhen and schedtick are also read by TimeSinceLastSchedule,
+	// so sysmon updates them atomically. schedwhen comes first to
+	// keep it 8-byte aligned; see check.
 	schedwhen   int64
-	syscalltick uint32
 	syscallwhen int64
+	schedtick   uint32
+	syscalltick uint32
 }
 
-// forcePreemptNS is the time slice given to a G before it is
-// preempted.
+// forcePreemptNS is the default time slice given to a G before it is
+// preempted. It can be changed with GODEBUG=preemptus=X.
 const forcePreemptNS = 10 * 1000 * 1000 // 10ms
 
+// retakeSyscallNS is the default time after which a P blocked in a
+// system call is retaken even if there is no other work for it. It can
+// be changed with GODEBUG=retakesyscallus=X.
+const retakeSyscallNS = 10 * 1000 * 1000 // 10ms
+
+// TimeSinceLastSchedule returns roughly how long, in nanoseconds, the
+// calling goroutine's processor has been running without a scheduling
+// decision, as measured by the background thread that preempts
+// goroutines once this exceeds their time slice (10ms by default, see
+// GODEBUG=preemptus). A CPU-bound goroutine can compare it with the
+// time slice and yield with Gosched at a convenient point before it is
+// preempted.
+//
+// The background thread checks each processor every 20us to 10ms,
+// depending on how busy the program is, and the time is counted from
+// when it first noticed the current time slice, so the result lags
+// behind the true time slice by up to that much. It is 0 if the thread
+// has not yet noticed the current time slice.
+func TimeSinceLastSchedule() int64 {
+	mp := acquirem()
+	pp := mp.p.ptr()
+	var d int64
+	// sysmon stores schedwhen before schedtick, so if schedtick
+	// matches, schedwhen belongs to the current time slice.
+	if pd := &pp.sysmontick; atomic.Load(&pd.schedtick) == pp.schedtick {
+		d = nanotime() - atomic.Loadint64(&pd.schedwhen)
+	}
+	releasem(mp)
+	return d
+}
+
 // 实现go调度系统的抢占
 // retake()函数会遍历所有的P，如果一个P处于执行状态，
 // 且已经连续执行了较长时间，就会被抢占。
@@ -4921,6 +7205,10 @@ func retake(now int64) uint32 {
 		pd := &_p_.sysmontick
 		s := _p_.status
 		if s == _Psyscall {
+			if _p_.boundm != 0 {
+				// Only the M in the syscall may run _p_.
+				continue
+			}
 			// Retake P from syscall if it's there for more than 1 sysmon tick (at least 20us).
 			t := int64(_p_.syscalltick)
 			if int64(pd.syscalltick) != t {
@@ -4931,7 +7219,7 @@ func retake(now int64) uint32 {
 			// On the one hand we don't want to retake Ps if there is no other work to do,
 			// but on the other hand we want to retake them eventually
 			// because they can prevent the sysmon thread from deep sleep.
-			if runqempty(_p_) && atomic.Load(&sched.nmspinning)+atomic.Load(&sched.npidle) > 0 && pd.syscallwhen+10*1000*1000 > now {
+			if runqempty(_p_) && atomic.Load(&sched.nmspinning)+atomic.Load(&sched.npidle) > 0 && pd.syscallwhen+int64(debug.retakesyscallus)*1000 > now {
 				continue
 			}
 			// Drop allpLock so we can take sched.lock.
@@ -4946,6 +7234,9 @@ func retake(now int64) uint32 {
 					traceGoSysBlock(_p_)
 					traceProcStop(_p_)
 				}
+				if atomic.Load(&syscallRetakeHook.h.enabled) != 0 {
+					syscallRetakeHookRecord(_p_, pd.syscallwhen, now)
+				}
 				n++
 				_p_.syscalltick++
 				handoffp(_p_)
@@ -4956,11 +7247,16 @@ func retake(now int64) uint32 {
 			// Preempt G if it's running for too long.
 			t := int64(_p_.schedtick)
 			if int64(pd.schedtick) != t {
-				pd.schedtick = uint32(t)
-				pd.schedwhen = now
+				atomic.Store64((*uint64)(unsafe.Pointer(&pd.schedwhen)), uint64(now))
+				atomic.Store(&pd.schedtick, uint32(t))
+				continue
+			}
+			if pd.schedwhen+int64(debug.preemptus)*1000 > now {
 				continue
 			}
-			if pd.schedwhen+forcePreemptNS > now {
+			if atomic.Load(&deterministicSched) != 0 {
+				// Scheduling is cooperative only; see
+				// SetDeterministicScheduling.
 				continue
 			}
 			preemptone(_p_)
@@ -5023,6 +7319,11 @@ func preemptone(_p_ *p) bool {
 	// gorotuine 中的每个调用都会通过将当前堆栈指针与 gp->stackguard0 进行比较来检查堆栈溢出。
 	// 将 gp->stackguard0 设置为 stackPreempt 会将抢占折叠为正常的堆栈溢出检查。
 	gp.stackguard0 = stackPreempt
+
+	if atomic.Load(&preemptHook.h.enabled) != 0 {
+		preemptHook.ring.put(gp.goid, 0, 0)
+		preemptHook.h.notify()
+	}
 	return true
 }
 
@@ -5110,6 +7411,29 @@ func schedtrace(detailed bool) {
 	unlock(&sched.lock)
 }
 
+// schedDump returns the detailed scheduler state that
+// GODEBUG=schedtrace=X,scheddetail=1 prints, for
+// runtime/debug.DumpSchedState. Like Stack, it captures the print
+// output in a buffer, growing the buffer until the dump fits.
+//go:linkname schedDump runtime/debug.schedDump
+func schedDump() []byte {
+	buf := make([]byte, 4096)
+	for {
+		n := 0
+		systemstack(func() {
+			g0 := getg()
+			g0.writebuf = buf[0:0:len(buf)]
+			schedtrace(true)
+			n = len(g0.writebuf)
+			g0.writebuf = nil
+		})
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
 // Put mp on midle list.
 // Sched must be locked.
 // May run during STW, so write barriers are not allowed.
@@ -5135,6 +7459,58 @@ func mget() *m {
 	return mp
 }
 
+// maxIdleThreads is the number of idle Ms above which sysmon asks
+// the rest to exit. 0 means never. Accessed atomically. See
+// SetMaxIdleThreads.
+var maxIdleThreads uint32
+
+// idleMReapPeriod is how often, at most, sysmon reaps idle Ms beyond
+// maxIdleThreads, so that Ms parked between bursts of work are not
+// destroyed and recreated at once.
+const idleMReapPeriod = 100 * 1000 * 1000 // 100ms
+
+// SetMaxIdleThreads limits the number of idle OS threads the runtime
+// keeps around for running goroutines. Threads are created as needed,
+// for example when goroutines block in system calls, and are normally
+// kept parked forever once the need passes. With a limit of n > 0,
+// the runtime periodically makes threads parked beyond the first n
+// exit, freeing their stacks. A value of 0 or less restores the
+// default of never doing so.
+//
+// The main thread never exits, and a GODEBUG=syscallmpool=N pool of
+// idle threads is kept even if it is larger than n.
+func SetMaxIdleThreads(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.Store(&maxIdleThreads, uint32(n))
+}
+
+// reapidlem takes idle Ms beyond maxIdleThreads off midle and wakes
+// them to exit; see stopm.
+func reapidlem() {
+	max := int32(atomic.Load(&maxIdleThreads))
+	if max == 0 || GOOS == "plan9" { // see goexit0
+		return
+	}
+	if max < debug.syscallmpool {
+		max = debug.syscallmpool
+	}
+	lock(&sched.lock)
+	for pmp := &sched.midle; *pmp != 0 && sched.nmidle > max; {
+		mp := pmp.ptr()
+		if mp == &m0 {
+			pmp = &mp.schedlink
+			continue
+		}
+		*pmp = mp.schedlink
+		sched.nmidle--
+		mp.reaped = true
+		notewakeup(&mp.park)
+	}
+	unlock(&sched.lock)
+}
+
 // Put gp on the global runnable queue.
 // Sched must be locked.
 // May run during STW, so write barriers are not allowed.
@@ -5176,6 +7552,23 @@ func globrunqputbatch(ghead *g, gtail *g, n int32) {
 	sched.runqsize += n
 }
 
+// globrunqempty reports whether the global runnable queue is empty,
+// without taking sched.lock, so that callers can skip the lock when
+// there is nothing to get. Its callers in schedule and findrunnable
+// already made this unlocked check on sched.runqsize before it was
+// factored out here; globrunqempty does not change their behavior.
+//
+// The read is racy. runqsize only changes under sched.lock, and the
+// lock's release orders the update before any later acquisition, so a
+// caller that sees a non-zero size and then locks sees a consistent
+// queue; globrunqget copes with it having been drained in between.
+// A caller that sees a stale zero just misses the new G for now; it
+// will be found by a later schedule round or by findrunnable, which
+// rechecks under the lock before the M parks.
+func globrunqempty() bool {
+	return sched.runqsize == 0
+}
+
 // Try get a batch of G's from the global runnable queue.
 // Sched must be locked.
 // 由findrunnable调用，尝试从全局队列中取出一个G
@@ -5247,7 +7640,17 @@ func pidleput(_p_ *p) {
 // May run during STW, so write barriers are not allowed.
 //go:nowritebarrierrec
 // 从空闲P列表获取一个P，并将sched.npidle减1
+// Ps bound to an M (see PinPToCurrentM) are skipped.
 func pidleget() *p {
+	if sched.nboundp != 0 {
+		for _p_ := sched.pidle.ptr(); _p_ != nil; _p_ = _p_.link.ptr() {
+			if _p_.boundm == 0 {
+				pidleremove(_p_)
+				return _p_
+			}
+		}
+		return nil
+	}
 	_p_ := sched.pidle.ptr()
 	if _p_ != nil {
 		sched.pidle = _p_.link
@@ -5256,6 +7659,86 @@ func pidleget() *p {
 	return _p_
 }
 
+// pidlegetm is pidleget for mp to acquire the P itself. If mp is
+// bound to a P, only that P will do.
+// Sched must be locked.
+// May run during STW, so write barriers are not allowed.
+//go:nowritebarrierrec
+func pidlegetm(mp *m) *p {
+	_p_ := mp.boundp.ptr()
+	if _p_ == nil {
+		return pidleget()
+	}
+	if !pidleremove(_p_) {
+		return nil
+	}
+	mp.boundkick = false
+	return _p_
+}
+
+// pidleremove removes _p_ from the idle P list, reporting whether it
+// was there.
+// Sched must be locked.
+// May run during STW, so write barriers are not allowed.
+//go:nowritebarrierrec
+func pidleremove(_p_ *p) bool {
+	for pp := &sched.pidle; *pp != 0; pp = &pp.ptr().link {
+		if pp.ptr() == _p_ {
+			*pp = _p_.link
+			atomic.Xadd(&sched.npidle, -1) // TODO: fast atomic
+			return true
+		}
+	}
+	return false
+}
+
+// pinqput hands gp over to _p_ by putting it on _p_'s incoming queue.
+// gp is either pinned to _p_ or was started by HandoffTo.
+// It can be called from any P. If _p_ is idle, it starts an M to
+// run it.
+func pinqput(_p_ *p, gp *g) {
+	lock(&_p_.lock)
+	gp.schedlink = 0
+	if _p_.pinqtail != 0 {
+		_p_.pinqtail.ptr().schedlink.set(gp)
+	} else {
+		_p_.pinqhead.set(gp)
+	}
+	_p_.pinqtail.set(gp)
+	atomic.Xadd(&_p_.pinqsize, 1)
+	unlock(&_p_.lock)
+
+	// Nobody looks at the pin queue of an idle P. findrunnable and
+	// handoffp check pinqsize under sched.lock before idling a P,
+	// so either they see gp or we see the P on the idle list.
+	lock(&sched.lock)
+	if _p_.status == _Pidle && pidleremove(_p_) {
+		unlock(&sched.lock)
+		startm(_p_, false)
+		return
+	}
+	unlock(&sched.lock)
+}
+
+// pinqget takes a goroutine from _p_'s pin queue.
+// Executed only by the owner P, or during STW.
+func pinqget(_p_ *p) *g {
+	if atomic.Load(&_p_.pinqsize) == 0 {
+		return nil
+	}
+	lock(&_p_.lock)
+	gp := _p_.pinqhead.ptr()
+	if gp != nil {
+		_p_.pinqhead = gp.schedlink
+		if _p_.pinqhead == 0 {
+			_p_.pinqtail = 0
+		}
+		atomic.Xadd(&_p_.pinqsize, -1)
+	}
+	unlock(&_p_.lock)
+	return gp
+}
+
 // runqempty returns true if _p_ has no Gs on its local run queue.
 // It never returns true spuriously.
 func runqempty(_p_ *p) bool {
@@ -5282,16 +7765,87 @@ func runqempty(_p_ *p) bool {
 // With the randomness here, as long as the tests pass
 // consistently with -race, they shouldn't have latent scheduling
 // assumptions.
-const randomizeScheduler = raceenabled
+// GODEBUG=schedrandom=1 turns it on without -race; it is set in
+// schedinit and otherwise only changes while the world is stopped by
+// SetDeterministicScheduling.
+var randomizeScheduler = raceenabled
+
+// deterministicSched is 1 while SetDeterministicScheduling is on.
+// It only changes while the world is stopped; sysmon reads it
+// atomically.
+var deterministicSched uint32
+
+// deterministicSaved holds the settings SetDeterministicScheduling
+// restores when it is turned off. Protected by worldsema.
+var deterministicSaved struct {
+	procs     int32
+	randomize bool
+}
+
+// SetDeterministicScheduling turns a simulation mode of the scheduler
+// on or off. While it is on, goroutines run one at a time, in an order
+// that depends only on what the program does: GOMAXPROCS is set to 1,
+// idle processors never steal work, the scheduler does not shuffle its
+// run queues even under the race detector (see GODEBUG=schedrandom),
+// and running goroutines are never preempted for having run too long,
+// so each one runs until it blocks, yields with Gosched or makes a
+// call that schedules. Turning it off restores the previous GOMAXPROCS
+// and scheduling behavior.
+//
+// This is meant for deterministic simulation tests and is unsafe
+// anywhere else: a goroutine in a loop that never yields holds the only
+// processor forever, hanging the program, and the garbage collector may
+// wait for it indefinitely. Events from outside the program, such as
+// timers, network I/O and system calls that block long enough for the
+// processor to be handed to another goroutine, still happen when they
+// happen, so inputs must be simulated too for runs to be reproducible.
+// Calling GOMAXPROCS while the mode is on brings back parallelism.
+func SetDeterministicScheduling(on bool) {
+	stopTheWorld("SetDeterministicScheduling")
+	if on == (deterministicSched != 0) {
+		startTheWorld()
+		return
+	}
+	var restore int32
+	if on {
+		deterministicSaved.procs = gomaxprocs
+		deterministicSaved.randomize = randomizeScheduler
+		randomizeScheduler = false
+		atomic.Store(&deterministicSched, 1)
+		// newprocs will be processed by startTheWorld.
+		newprocs = 1
+	} else {
+		randomizeScheduler = deterministicSaved.randomize
+		atomic.Store(&deterministicSched, 0)
+		restore = deterministicSaved.procs
+	}
+	startTheWorld()
+	if restore != 0 {
+		GOMAXPROCS(int(restore))
+	}
+}
 
 // runqput tries to put g on the local runnable queue.
 // If next is false, runqput adds g to the tail of the runnable queue.
 // If next is true, runqput puts g in the _p_.runnext slot.
 // If the run queue is full, runnext puts g on the global queue.
+// If gp has a non-zero priority, runqput always puts it in runnext,
+// and never displaces a higher-priority G already in runnext.
 // Executed only by the owner P.
 // 尝试将G放到P的本地队列
 // 如果next==true，将G直接赋值给runnext
 func runqput(_p_ *p, gp *g, next bool) {
+	if gp.priority > 0 {
+		next = true
+	}
+	runqputnoprio(_p_, gp, next)
+}
+
+// runqputnoprio is runqput without putting goroutines that have a
+// priority in runnext, for goroutines that yield the P and must let
+// the queued ones run first.
+// Executed only by the owner P.
+func runqputnoprio(_p_ *p, gp *g, next bool) {
 	if randomizeScheduler && next && fastrand()%2 == 0 {
 		next = false
 	}
@@ -5301,6 +7855,11 @@ func runqput(_p_ *p, gp *g, next bool) {
 		// ? 为何让newg优先运行？
 		// https://go-review.googlesource.com/c/go/+/9289
 		oldnext := _p_.runnext
+		if oldnext != 0 && oldnext.ptr().priority > gp.priority {
+			// Leave the higher-priority G in runnext and
+			// queue gp normally.
+			goto retry
+		}
 		// 将G赋值给_p_.runnext
 		// 最新的G优先级最高，最可能先被执行。
 		// 剩下的G如果go运行时调度器发现有空闲的core，就会把任务偷走点，
@@ -5369,6 +7928,9 @@ func runqputslow(_p_ *p, gp *g, h, t uint32) bool {
 	// 将拿到的G，添加到全局队列末尾
 	globrunqputbatch(batch[0], batch[n], int32(n+1))
 	unlock(&sched.lock)
+	if trace.enabled {
+		traceGoRunqSpill(_p_, n+1)
+	}
 	return true
 }
 
@@ -5428,9 +7990,12 @@ func runqgrab(_p_ *p, batch *[256]guintptr, batchHead uint32, stealRunNextG bool
 						// schedule runnext. This will avoid thrashing gs
 						// between different Ps.
 						// A sync chan send/recv takes ~50ns as of time of
-						// writing, so 3us gives ~50x overshoot.
+						// writing, so the default of 3us gives ~50x
+						// overshoot; see GODEBUG=stealnextbackoffus.
 						if GOOS != "windows" {
-							usleep(3)
+							if stealNextBackoff > 0 {
+								usleep(stealNextBackoff)
+							}
 						} else {
 							// On windows system timer granularity is
 							// 1-15ms, which is way too much for this
@@ -5441,6 +8006,9 @@ func runqgrab(_p_ *p, batch *[256]guintptr, batchHead uint32, stealRunNextG bool
 					if !_p_.runnext.cas(next, 0) {
 						continue
 					}
+					if trace.enabled {
+						traceGoRunnextSteal(_p_, next.ptr())
+					}
 					batch[batchHead%uint32(len(batch))] = next
 					return 1
 				}
@@ -5470,6 +8038,7 @@ func runqsteal(_p_, p2 *p, stealRunNextG bool) *g {
 	if n == 0 {
 		return nil
 	}
+	atomic.Xadd64(&_p_.nsteal, 1)
 	n--
 	gp := _p_.runq[(t+n)%uint32(len(_p_.runq))].ptr()
 	if n == 0 {
@@ -5584,26 +8153,159 @@ func sync_runtime_canSpin(i int) bool {
 // 函数内部循环调用PAUSE指令。PAUSE指令什么都不做，
 // 但是会消耗CPU时间，在执行PAUSE指令时，
 // CPU不会对他做不必要的优化
-func sync_runtime_doSpin() {
-	procyield(active_spin_cnt)
+func sync_runtime_doSpin(m unsafe.Pointer) {
+	procyield(mutexSpinCount(mutexSpinSlot(m)))
+}
+
+// mutexSpin holds the adaptive spin counts, in procyield iterations,
+// for sync.Mutex. Mutexes are hashed by address into the table, like
+// semaphores into semtable, so collisions merely share history. A zero
+// entry has not adapted yet and uses active_spin_cnt.
+var mutexSpin [semTabSize]uint32
+
+const (
+	mutexSpinMin = active_spin_cnt / 4
+	mutexSpinMax = active_spin_cnt * 8
+)
+
+//go:nosplit
+func mutexSpinSlot(m unsafe.Pointer) *uint32 {
+	return &mutexSpin[(uintptr(m)>>3)%semTabSize]
+}
+
+//go:nosplit
+func mutexSpinCount(slot *uint32) uint32 {
+	if n := atomic.Load(slot); n != 0 {
+		return n
+	}
+	return active_spin_cnt
+}
+
+// sync_runtime_spinResult records whether a round of spinning in
+// sync.Mutex.Lock ended with m acquired or with the goroutine about to
+// park. Spinning that pays off is lengthened, so lightly contended
+// mutexes are more likely to be acquired without parking; spinning
+// that doesn't is shortened, so heavily contended mutexes waste less
+// CPU before parking.
+//go:linkname sync_runtime_spinResult sync.runtime_spinResult
+//go:nosplit
+func sync_runtime_spinResult(m unsafe.Pointer, acquired bool) {
+	slot := mutexSpinSlot(m)
+	old := atomic.Load(slot)
+	n := old
+	if n == 0 {
+		n = active_spin_cnt
+	}
+	if acquired {
+		n += n/8 + 1
+		if n > mutexSpinMax {
+			n = mutexSpinMax
+		}
+	} else {
+		n -= n / 4
+		if n < mutexSpinMin {
+			n = mutexSpinMin
+		}
+	}
+	// The table is shared by every P, so don't dirty its cache line
+	// once a count has settled at a bound.
+	if n != old {
+		atomic.Store(slot, n)
+	}
 }
 
 var stealOrder randomOrder
 
+// stealSeed holds the state for SetStealSeed.
+var stealSeed struct {
+	seed uint32 // 0 means steal in a random order
+	gen  uint32 // incremented by SetStealSeed to restart sequences
+}
+
+// SetStealSeed makes the order in which an idle processor looks at the
+// other processors' run queues for work to steal a function of seed,
+// for reproducing scheduling behavior in tests. Each processor then
+// follows its own fixed sequence of orders, starting from the
+// beginning after each call. Which processor runs out of work when
+// still depends on timing, so this does not make whole programs
+// deterministic. A seed of 0 restores the default random order.
+func SetStealSeed(seed uint32) {
+	atomic.Store(&stealSeed.seed, seed)
+	atomic.Xadd(&stealSeed.gen, 1)
+}
+
+// stealStart returns the position at which pp starts a pass over the
+// other Ps in findrunnable.
+func stealStart(pp *p) uint32 {
+	seed := atomic.Load(&stealSeed.seed)
+	if seed == 0 {
+		return fastrand()
+	}
+	if gen := atomic.Load(&stealSeed.gen); pp.stealGen != gen {
+		pp.stealGen = gen
+		pp.stealSeq = 0
+	}
+	x := seed*0x9e3779b9 + uint32(pp.id)*0x85ebca6b + pp.stealSeq*0xc2b2ae35
+	pp.stealSeq++
+	// Finish with MurmurHash3's mixer so that nearby inputs give
+	// unrelated positions.
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// stealVictimWindow is how long, in nanoseconds, findrunnable avoids
+// stealing again from a P it has stolen from, unless there is no other
+// work; see recentVictim.
+const stealVictimWindow = 1000 * 1000
+
+// recentVictim reports whether mp stole from the P with the given id
+// less than stealVictimWindow before now.
+func (mp *m) recentVictim(id int32, now int64) bool {
+	for i := range mp.stealVictims {
+		v := &mp.stealVictims[i]
+		if v.when != 0 && v.id == id && now-v.when < stealVictimWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// noteVictim records that mp stole from the P with the given id at
+// now, replacing the oldest record.
+func (mp *m) noteVictim(id int32, now int64) {
+	mp.stealVictims[mp.stealVictimNext%uint32(len(mp.stealVictims))] = stealVictim{id, now}
+	mp.stealVictimNext++
+}
+
 // randomOrder/randomEnum are helper types for randomized work stealing.
 // They allow to enumerate all Ps in different pseudo-random orders without repetitions.
 // The algorithm is based on the fact that if we have X such that X and GOMAXPROCS
 // are coprime, then a sequences of (i + X) % GOMAXPROCS gives the required enumeration.
+//
+// If the machine has more than one NUMA node, the enumeration makes two passes
+// over the same sequence: first it yields only the Ps on the caller's node,
+// then only the remaining ones, so that work is stolen across nodes last.
+// A P's node follows its M (see procNUMAUpdate), so each enumeration works
+// on a snapshot of the nodes that does not change under it; see nodeSnapshot.
 type randomOrder struct {
 	count    uint32
 	coprimes []uint32
+	nodes    []int32 // NUMA node of each P or -1, or empty if not NUMA-aware; protected by sched.lock
+	nodesgen uint32  // incremented whenever nodes changes; updated atomically
 }
 
 type randomEnum struct {
-	i     uint32
-	count uint32
-	pos   uint32
-	inc   uint32
+	i      uint32
+	count  uint32
+	pos    uint32
+	inc    uint32
+	nodes  []int32
+	node   int32
+	remote bool // second pass, over Ps not on node
 }
 
 func (ord *randomOrder) reset(count uint32) {
@@ -5616,12 +8318,60 @@ func (ord *randomOrder) reset(count uint32) {
 	}
 }
 
-func (ord *randomOrder) start(i uint32) randomEnum {
-	return randomEnum{
+// resetNodes records the NUMA node of each of ps if numa is set, and
+// otherwise disables node-aware ordering. It also gives each of ps an
+// up-to-date snapshot. The world must be stopped.
+func (ord *randomOrder) resetNodes(ps []*p, numa bool) {
+	ord.nodes = ord.nodes[:0]
+	if numa {
+		for _, pp := range ps {
+			ord.nodes = append(ord.nodes, pp.numaNode)
+		}
+	}
+	ord.nodesgen++
+	for _, pp := range ps {
+		pp.stealnodes = append(pp.stealnodes[:0], ord.nodes...)
+		pp.stealnodesgen = ord.nodesgen
+	}
+}
+
+// setNode records that the P with the given id is now on node.
+// sched.lock must be held.
+func (ord *randomOrder) setNode(id, node int32) {
+	if int(id) < len(ord.nodes) && ord.nodes[id] != node {
+		ord.nodes[id] = node
+		atomic.Xadd(&ord.nodesgen, 1)
+	}
+}
+
+// nodeSnapshot returns _p_'s copy of nodes, first bringing it up to
+// date if a node changed since the copy was taken. Only _p_'s M uses
+// the copy, so it cannot change during an enumeration.
+func (ord *randomOrder) nodeSnapshot(_p_ *p) []int32 {
+	if atomic.Load(&ord.nodesgen) != _p_.stealnodesgen {
+		lock(&sched.lock)
+		copy(_p_.stealnodes, ord.nodes)
+		_p_.stealnodesgen = ord.nodesgen
+		unlock(&sched.lock)
+	}
+	return _p_.stealnodes
+}
+
+// start begins an enumeration at pseudo-random position i. If nodes
+// gives the NUMA node of every P, Ps on node are enumerated first,
+// unless node is -1.
+func (ord *randomOrder) start(i uint32, node int32, nodes []int32) randomEnum {
+	enum := randomEnum{
 		count: ord.count,
 		pos:   i % ord.count,
 		inc:   ord.coprimes[i%uint32(len(ord.coprimes))],
 	}
+	if node >= 0 && len(nodes) == int(ord.count) {
+		enum.nodes = nodes
+		enum.node = node
+		enum.skip()
+	}
+	return enum
 }
 
 func (enum *randomEnum) done() bool {
@@ -5629,14 +8379,35 @@ func (enum *randomEnum) done() bool {
 }
 
 func (enum *randomEnum) next() {
-	enum.i++
-	enum.pos = (enum.pos + enum.inc) % enum.count
+	enum.advance()
+	enum.skip()
 }
 
 func (enum *randomEnum) position() uint32 {
 	return enum.pos
 }
 
+func (enum *randomEnum) advance() {
+	enum.i++
+	enum.pos = (enum.pos + enum.inc) % enum.count
+	if enum.i == enum.count && enum.nodes != nil && !enum.remote {
+		// Finished the local pass. The sequence has come back
+		// to where it started; go around again for the rest.
+		enum.remote = true
+		enum.i = 0
+	}
+}
+
+// skip advances past Ps that do not belong to the current pass.
+func (enum *randomEnum) skip() {
+	if enum.nodes == nil {
+		return
+	}
+	for !enum.done() && (enum.nodes[enum.pos] != enum.node) != enum.remote {
+		enum.advance()
+	}
+}
+
 func gcd(a, b uint32) uint32 {
 	for b != 0 {
 		a, b = b, a%b
@@ -14,7 +14,7 @@ func RunStealOrderTest() {
 			panic("too few coprimes")
 		}
 		for co := 0; co < len(ord.coprimes); co++ {
-			enum := ord.start(uint32(co))
+			enum := ord.start(uint32(co), -1, nil)
 			checked := make([]bool, procs)
 			for p := 0; p < procs; p++ {
 				x := enum.position()
@@ -31,3 +31,38 @@ func RunStealOrderTest() {
 		}
 	}
 }
+
+func RunStealOrderNUMATest() {
+	var ord randomOrder
+	nodes := []int32{0, 1, 1, 0, 2, 1, 0, 0, 2}
+	procs := len(nodes)
+	ord.reset(uint32(procs))
+	for i := 0; i < 100; i++ {
+		for node := int32(0); node < 3; node++ {
+			enum := ord.start(uint32(i), node, nodes)
+			checked := make([]bool, procs)
+			remote := false
+			for p := 0; p < procs; p++ {
+				if enum.done() {
+					panic("done too early")
+				}
+				x := enum.position()
+				if checked[x] {
+					println("procs:", procs, "inc:", enum.inc)
+					panic("duplicate during enumeration")
+				}
+				checked[x] = true
+				if nodes[x] != node {
+					remote = true
+				} else if remote {
+					println("node:", node, "p:", x)
+					panic("local P enumerated after remote P")
+				}
+				enum.next()
+			}
+			if !enum.done() {
+				panic("not done")
+			}
+		}
+	}
+}
@@ -5,8 +5,11 @@
 package runtime_test
 
 import (
+	"fmt"
+	"internal/race"
 	"math"
 	"net"
+	"reflect"
 	"runtime"
 	"runtime/debug"
 	"strings"
@@ -15,6 +18,7 @@ import (
 	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 var stop = make(chan bool, 1)
@@ -52,6 +56,65 @@ func TestStopTheWorldDeadlock(t *testing.T) {
 	runtime.GOMAXPROCS(maxprocs)
 }
 
+func TestGOMAXPROCSGrowShrink(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var sink [][]byte
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				sink = append(sink, make([]byte, 1024))
+				if len(sink) > 1000 {
+					sink = nil
+				}
+			}
+		}()
+	}
+	prev := 1
+	for i := 0; i < 100; i++ {
+		n := []int{2, 8, 3, 16, 1}[i%5]
+		if got := runtime.GOMAXPROCS(n); got != prev {
+			t.Fatalf("GOMAXPROCS(%d) returned %d, want %d", n, got, prev)
+		}
+		prev = n
+		if i%10 == 0 {
+			runtime.GC()
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestGOMAXPROCSShrinkRunnable(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	for i := 0; i < 20; i++ {
+		runtime.GOMAXPROCS(8)
+		release := make(chan bool)
+		var wg sync.WaitGroup
+		for j := 0; j < 1000; j++ {
+			wg.Add(1)
+			go func() {
+				<-release
+				runtime.Gosched()
+				wg.Done()
+			}()
+		}
+		// Shrink while the goroutines sit in the run queues of
+		// the Ps being removed.
+		close(release)
+		runtime.GOMAXPROCS(1 + i%3)
+		wg.Wait()
+	}
+}
+
 func TestYieldProgress(t *testing.T) {
 	testYieldProgress(false)
 }
@@ -82,6 +145,97 @@ func testYieldProgress(locked bool) {
 	<-cack
 }
 
+func TestYieldN(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// The new goroutine is queued on our P, so a single local yield
+	// lets it run.
+	var ran uint32
+	go func() {
+		atomic.StoreUint32(&ran, 1)
+	}()
+	runtime.YieldN(1)
+	if atomic.LoadUint32(&ran) == 0 {
+		t.Fatal("queued goroutine did not run during YieldN(1)")
+	}
+
+	// So does a yield by a goroutine with a priority, which would
+	// otherwise go back to runnext ahead of the new goroutine.
+	runtime.SetGoroutinePriority(1)
+	defer runtime.SetGoroutinePriority(0)
+	atomic.StoreUint32(&ran, 0)
+	go func() {
+		atomic.StoreUint32(&ran, 1)
+	}()
+	runtime.YieldN(1)
+	if atomic.LoadUint32(&ran) == 0 {
+		t.Fatal("queued goroutine did not run during YieldN(1) by a goroutine with a priority")
+	}
+
+	// With nothing queued YieldN returns after a single Gosched.
+	runtime.YieldN(1 << 30)
+}
+
+func TestYieldNLocked(t *testing.T) {
+	ping := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		go func() {
+			ping <- true
+		}()
+		for {
+			select {
+			case <-ping:
+				done <- true
+				return
+			default:
+				runtime.YieldN(10)
+			}
+		}
+	}()
+	<-done
+}
+
+func TestPreemptionPoint(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// The loop makes no calls other than PreemptionPoint, so only
+	// PreemptionPoint lets sysmon's preemption take the P back and
+	// wake this goroutine to set stop. The iteration limit keeps a
+	// broken PreemptionPoint from hanging the test.
+	var stop uint32
+	done := make(chan bool)
+	go func() {
+		stopped := false
+		for i := int64(0); i < 1<<32; i++ {
+			if atomic.LoadUint32(&stop) != 0 {
+				stopped = true
+				break
+			}
+			runtime.PreemptionPoint()
+		}
+		done <- stopped
+	}()
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreUint32(&stop, 1)
+	if !<-done {
+		t.Fatal("spinning goroutine was not preempted at PreemptionPoint")
+	}
+}
+
+func TestSetExtraMPoolSize(t *testing.T) {
+	if _, ok := runtime.ExtraMCount(); !ok {
+		t.Skip("no extra Ms without cgo")
+	}
+	defer runtime.SetExtraMPoolSize(0)
+	runtime.SetExtraMPoolSize(4)
+	if n, _ := runtime.ExtraMCount(); n < 4 {
+		t.Errorf("ExtraMCount = %d after SetExtraMPoolSize(4), want at least 4", n)
+	}
+}
+
 func TestYieldLocked(t *testing.T) {
 	const N = 10
 	c := make(chan bool)
@@ -255,132 +409,1874 @@ func TestTimerFairness(t *testing.T) {
 	}
 }
 
-func TestTimerFairness2(t *testing.T) {
-	done := make(chan bool)
-	c := make(chan bool)
-	for i := 0; i < 2; i++ {
-		go func() {
-			timer := time.After(20 * time.Millisecond)
-			var buf [1]byte
-			for {
-				syscall.Read(0, buf[0:0])
-				select {
-				case c <- true:
-				case <-c:
-				case <-timer:
-					done <- true
-					return
-				}
-			}
-		}()
+func TestTimerFairness2(t *testing.T) {
+	done := make(chan bool)
+	c := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			timer := time.After(20 * time.Millisecond)
+			var buf [1]byte
+			for {
+				syscall.Read(0, buf[0:0])
+				select {
+				case c <- true:
+				case <-c:
+				case <-timer:
+					done <- true
+					return
+				}
+			}
+		}()
+	}
+	<-done
+	<-done
+}
+
+// The function is used to test preemption at split stack checks.
+// Declaring a var avoids inlining at the call site.
+var preempt = func() int {
+	var a [128]int
+	sum := 0
+	for _, v := range a {
+		sum += v
+	}
+	return sum
+}
+
+func TestPreemption(t *testing.T) {
+	// Test that goroutines are preempted at function calls.
+	N := 5
+	if testing.Short() {
+		N = 2
+	}
+	c := make(chan bool)
+	var x uint32
+	for g := 0; g < 2; g++ {
+		go func(g int) {
+			for i := 0; i < N; i++ {
+				for atomic.LoadUint32(&x) != uint32(g) {
+					preempt()
+				}
+				atomic.StoreUint32(&x, uint32(1-g))
+			}
+			c <- true
+		}(g)
+	}
+	<-c
+	<-c
+}
+
+func TestPreemptionGC(t *testing.T) {
+	// Test that pending GC preempts running goroutines.
+	P := 5
+	N := 10
+	if testing.Short() {
+		P = 3
+		N = 2
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P + 1))
+	var stop uint32
+	for i := 0; i < P; i++ {
+		go func() {
+			for atomic.LoadUint32(&stop) == 0 {
+				preempt()
+			}
+		}()
+	}
+	for i := 0; i < N; i++ {
+		runtime.Gosched()
+		runtime.GC()
+	}
+	atomic.StoreUint32(&stop, 1)
+}
+
+func TestGCFairness(t *testing.T) {
+	output := runTestProg(t, "testprog", "GCFairness")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestGCFairness2(t *testing.T) {
+	output := runTestProg(t, "testprog", "GCFairness2")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestNumGoroutine(t *testing.T) {
+	output := runTestProg(t, "testprog", "NumGoroutine")
+	want := "1\n"
+	if output != want {
+		t.Fatalf("want %q, got %q", want, output)
+	}
+
+	buf := make([]byte, 1<<20)
+
+	// Try up to 10 times for a match before giving up.
+	// This is a fundamentally racy check but it's important
+	// to notice if NumGoroutine and Stack are _always_ out of sync.
+	for i := 0; ; i++ {
+		// Give goroutines about to exit a chance to exit.
+		// The NumGoroutine and Stack below need to see
+		// the same state of the world, so anything we can do
+		// to keep it quiet is good.
+		runtime.Gosched()
+
+		n := runtime.NumGoroutine()
+		buf = buf[:runtime.Stack(buf, true)]
+
+		nstk := strings.Count(string(buf), "goroutine ")
+		if n == nstk {
+			break
+		}
+		if i >= 10 {
+			t.Fatalf("NumGoroutine=%d, but found %d goroutines in stack dump: %s", n, nstk, buf)
+		}
+	}
+}
+
+func TestUserGoroutineCount(t *testing.T) {
+	// Try up to 10 times for a match with NumGoroutine, as above.
+	for i := 0; ; i++ {
+		runtime.Gosched()
+		n, want := runtime.UserGoroutineCount(), runtime.NumGoroutine()
+		if n == want {
+			break
+		}
+		if i >= 10 {
+			t.Fatalf("UserGoroutineCount=%d, but NumGoroutine=%d", n, want)
+		}
+	}
+
+	const N = 10
+	block := make(chan bool)
+	var started sync.WaitGroup
+	started.Add(N)
+	for i := 0; i < N; i++ {
+		go func() {
+			started.Done()
+			<-block
+		}()
+	}
+	started.Wait()
+	// Count the blocked goroutines and this one.
+	if n := runtime.UserGoroutineCount(); n < N+1 {
+		t.Errorf("UserGoroutineCount=%d with %d goroutines blocked, want at least %d", n, N, N+1)
+	}
+	close(block)
+}
+
+func TestNoSteal(t *testing.T) {
+	output := runTestProg(t, "testprog", "NoSteal", "GODEBUG=nosteal=1")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %q, got %q", want, output)
+	}
+}
+
+func TestExternalRunSource(t *testing.T) {
+	done := make(chan bool)
+	go func() {
+		runtime.RunExternalRunSourceTest()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("goroutine from external run source did not run")
+	}
+}
+
+func TestStealAttempts(t *testing.T) {
+	for _, n := range []string{"0", "1", "16"} {
+		output := runTestProg(t, "testprog", "NoSteal", "GODEBUG=stealattempts="+n)
+		want := "OK\n"
+		if output != want {
+			t.Errorf("stealattempts=%s: want %q, got %q", n, want, output)
+		}
+	}
+}
+
+func TestStealNextBackoff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stealnextbackoffus has no effect on Windows")
+	}
+	// Without a backoff, an idle P steals runnext from a busy P
+	// right away; with a 20ms backoff it waits past the 5ms the P
+	// stays busy. Negative values mean no backoff.
+	for _, tt := range []struct{ n, want string }{
+		{"-1", "stolen\n"},
+		{"0", "stolen\n"},
+		{"20000", "kept\n"},
+	} {
+		output := runTestProg(t, "testprog", "StealNext", "GODEBUG=stealnextbackoffus="+tt.n)
+		if output != tt.want {
+			t.Errorf("stealnextbackoffus=%s: want %q, got %q", tt.n, tt.want, output)
+		}
+	}
+}
+
+func TestSudogCacheSize(t *testing.T) {
+	for _, n := range []string{"0", "1", "3", "1000", "2147483647"} {
+		output := runTestProg(t, "testprog", "SudogChurn", "GODEBUG=sudogcache="+n)
+		want := "OK\n"
+		if output != want {
+			t.Errorf("sudogcache=%s: want %q, got %q", n, want, output)
+		}
+	}
+}
+
+func TestSyscallMPool(t *testing.T) {
+	output := runTestProg(t, "testprog", "SyscallMPool", "GODEBUG=syscallmpool=8")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestThreadInitMPool(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc/self/task")
+	}
+	output := runTestProg(t, "testprog", "ThreadInitMPool", "GODEBUG=syscallmpool=8")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestInjectReady(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	runtime.RunInjectReadyTest(100)
+	// More than the idle Ps' local queues take, so some go to the
+	// global queue.
+	runtime.RunInjectReadyTest(1000)
+}
+
+func TestScavengeLimit(t *testing.T) {
+	output := runTestProg(t, "testprog", "ScavengeLimit", "GODEBUG=scavengelimitns=100000000")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestSchedRandom(t *testing.T) {
+	output := runTestProg(t, "testprog", "SchedRandom", "GODEBUG=schedrandom=1")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestGoidCacheBatch(t *testing.T) {
+	output := runTestProg(t, "testprog", "GoidCacheBatch", "GODEBUG=goidcachebatch=1000")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestSchedLatencyBuckets(t *testing.T) {
+	output := runTestProg(t, "testprog", "SchedLatency", "GODEBUG=schedlatency=1")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestSchedLatencyBucket(t *testing.T) {
+	tests := []struct {
+		d    int64
+		want int
+	}{
+		{0, 0},
+		{1023, 0},
+		{1024, 1},
+		{2047, 1},
+		{2048, 2},
+		{1 << 32, 23},
+		{1 << 62, 23},
+	}
+	for _, tt := range tests {
+		if got := runtime.SchedLatencyBucket(tt.d); got != tt.want {
+			t.Errorf("SchedLatencyBucket(%d) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestNetpollOff(t *testing.T) {
+	output := runTestProg(t, "testprog", "NetpollOff", "GODEBUG=netpolloff=1")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestSTWLog(t *testing.T) {
+	output := runTestProg(t, "testprog", "STWLog", "GODEBUG=stwlog=1")
+	if !strings.Contains(output, "STW read mem stats: ") {
+		t.Fatalf("output does not report ReadMemStats stop:\n%s", output)
+	}
+	if !strings.HasSuffix(output, "OK\n") {
+		t.Fatalf("want output ending in OK, got:\n%s", output)
+	}
+}
+
+func TestNumSpinningIdleM(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for i := 0; i < 100; i++ {
+		if n := runtime.NumSpinningM(); n < 0 || n > 4 {
+			t.Fatalf("NumSpinningM=%d, want in [0, 4]", n)
+		}
+		if n := runtime.NumIdleM(); n < 0 {
+			t.Fatalf("NumIdleM=%d, want >= 0", n)
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestScheduleHook(t *testing.T) {
+	if race.Enabled {
+		t.Skip("SetScheduleHook has no effect with the race detector")
+	}
+	var want, calls int64
+	atomic.StoreInt64(&want, -1)
+	runtime.SetScheduleHook(func(goid int64, inheritTime bool) {
+		if goid == atomic.LoadInt64(&want) {
+			atomic.AddInt64(&calls, 1)
+		}
+	})
+	defer runtime.SetScheduleHook(nil)
+
+	done := make(chan int64)
+	go func() {
+		atomic.StoreInt64(&want, runtime.Goid())
+		for i := int64(1); i <= 10; i++ {
+			runtime.Gosched()
+			// The hook runs before the goroutine resumes, so
+			// this schedule has already been counted.
+			if n := atomic.LoadInt64(&calls); n < i {
+				done <- n
+				return
+			}
+		}
+		done <- -1
+	}()
+	if n := <-done; n >= 0 {
+		t.Errorf("goroutine resumed after a schedule hook saw it %d times, want the call to come first", n)
+	}
+}
+
+func TestGoroutineExitHook(t *testing.T) {
+	const N = 10
+	var ids [N]int64
+	var exited [N]uint32
+	runtime.SetGoroutineExitHook(func(goid int64) {
+		for i := range ids {
+			if atomic.LoadInt64(&ids[i]) == goid {
+				atomic.StoreUint32(&exited[i], 1)
+			}
+		}
+	})
+	defer runtime.SetGoroutineExitHook(nil)
+
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			atomic.StoreInt64(&ids[i], runtime.Goid())
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+	// The goroutines may not have finished exiting when Wait returns.
+	deadline := time.Now().Add(5 * time.Second)
+	for i := range exited {
+		for atomic.LoadUint32(&exited[i]) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("exit of goroutine %d was not reported", atomic.LoadInt64(&ids[i]))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestLabelConcurrencyLimit(t *testing.T) {
+	if race.Enabled {
+		t.Skip("the race detector makes the spin loops preemptible")
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(3))
+	// The spinning goroutines cannot be preempted, so a GC would
+	// deadlock.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	runtime.SetLabelConcurrencyLimit("test", "limited", 1)
+	defer runtime.SetLabelConcurrencyLimit("test", "limited", 0)
+
+	var started, stop uint32
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		runtime.SetCurrentGoroutineLabels(map[string]string{"test": "limited"})
+		for i := 0; i < 2; i++ {
+			go func() {
+				atomic.AddUint32(&started, 1)
+				for atomic.LoadUint32(&stop) == 0 {
+				}
+				wg.Done()
+			}()
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadUint32(&started); n != 1 {
+		t.Errorf("%d goroutines of the group started with a limit of 1, want 1", n)
+	}
+	atomic.StoreUint32(&stop, 1)
+	wg.Wait()
+	if n := atomic.LoadUint32(&started); n != 2 {
+		t.Errorf("%d goroutines of the group started in total, want 2", n)
+	}
+}
+
+func TestLabelConcurrencyLimitGC(t *testing.T) {
+	output := runTestProg(t, "testprog", "GCLabelLimit")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestMutexSpinAdapts(t *testing.T) {
+	// Other mutexes may share m's slot, so only check the direction
+	// the spin count moves in.
+	m := unsafe.Pointer(new(sync.Mutex))
+	for i := 0; i < 100; i++ {
+		runtime.MutexSpinResult(m, false)
+	}
+	if n := runtime.MutexSpin(m); n >= runtime.DefaultMutexSpin {
+		t.Errorf("spin count is %d after spinning kept failing, want less than %d", n, runtime.DefaultMutexSpin)
+	}
+	for i := 0; i < 100; i++ {
+		runtime.MutexSpinResult(m, true)
+	}
+	if n := runtime.MutexSpin(m); n <= runtime.DefaultMutexSpin {
+		t.Errorf("spin count is %d after spinning kept succeeding, want more than %d", n, runtime.DefaultMutexSpin)
+	}
+}
+
+func TestObserveGStatusTransitions(t *testing.T) {
+	const (
+		running = 2
+		waiting = 4
+		dead    = 6
+	)
+	var mu sync.Mutex
+	var want int64 = -1
+	var seen []uint32 // new statuses of goroutine want
+	runtime.ObserveGStatusTransitions(func(goid int64, old, new uint32) {
+		mu.Lock()
+		if goid == want {
+			seen = append(seen, new)
+		}
+		mu.Unlock()
+	})
+	defer runtime.ObserveGStatusTransitions(nil)
+
+	c := make(chan int64)
+	go func() {
+		c <- runtime.Goid()
+		<-c
+		time.Sleep(time.Millisecond) // always parks
+	}()
+	mu.Lock()
+	want = <-c
+	mu.Unlock()
+	c <- 0
+
+	// Wait until the goroutine's exit has been reported.
+	var got []uint32
+	for i := 0; i < 500; i++ {
+		mu.Lock()
+		got = append(got[:0], seen...)
+		mu.Unlock()
+		if len(got) > 0 && got[len(got)-1] == dead {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var sawWait, sawDead bool
+	for i, s := range got {
+		if s == waiting && i > 0 && got[i-1] == running {
+			sawWait = true
+		}
+		if s == dead {
+			sawDead = true
+		}
+	}
+	if !sawWait || !sawDead {
+		t.Fatalf("transitions to %v, want running, waiting and dead", got)
+	}
+}
+
+func TestStealSeed(t *testing.T) {
+	defer runtime.SetStealSeed(0)
+
+	runtime.SetStealSeed(42)
+	a := runtime.StealSequence(1, 8, 10)
+	b := runtime.StealSequence(1, 8, 10)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("same seed gave different steal orders:\n%v\n%v", a, b)
+	}
+	if c := runtime.StealSequence(2, 8, 10); reflect.DeepEqual(a, c) {
+		t.Errorf("Ps 1 and 2 have the same steal order %v", a)
+	}
+	runtime.SetStealSeed(43)
+	if c := runtime.StealSequence(1, 8, 10); reflect.DeepEqual(a, c) {
+		t.Errorf("seeds 42 and 43 give the same steal order %v", a)
+	}
+
+	// Each pass still visits every P once.
+	for i := 0; i < len(a); i += 8 {
+		var seen [8]bool
+		for _, pos := range a[i : i+8] {
+			if seen[pos] {
+				t.Fatalf("pass %v visits P %d twice", a[i:i+8], pos)
+			}
+			seen[pos] = true
+		}
+	}
+
+	runtime.SetStealSeed(0)
+	if c, d := runtime.StealSequence(1, 8, 10), runtime.StealSequence(1, 8, 10); reflect.DeepEqual(c, d) {
+		t.Errorf("random steal orders are the same: %v", c)
+	}
+}
+
+func TestGlobalQueueSoftLimit(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	runtime.SetGlobalQueueSoftLimit(10)
+	defer runtime.SetGlobalQueueSoftLimit(0)
+
+	// Overflowing the local run queue moves half of it, 128
+	// goroutines, to the global queue at a time, so the queue can
+	// exceed the limit by that much before the spawner yields.
+	const n = 5000
+	done := make(chan bool)
+	defer close(done)
+	max := 0
+	for i := 0; i < n; i++ {
+		go func() {
+			<-done
+		}()
+		if q := runtime.GlobalRunQueueSize(); q > max {
+			max = q
+		}
+	}
+	if max > 10+129 {
+		t.Errorf("global run queue reached %d goroutines with a soft limit of 10", max)
+	}
+}
+
+func TestForEachP(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// Keep some Ps busy so that fn runs both on running and on
+	// idle Ps.
+	stop := make(chan bool)
+	defer close(stop)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}()
+	}
+
+	var calls [4]int32
+	for i := 0; i < 10; i++ {
+		runtime.ForEachP(func(pid int) {
+			atomic.AddInt32(&calls[pid], 1)
+		})
+	}
+	for pid, n := range calls {
+		if n != 10 {
+			t.Errorf("fn called %d times for P %d, want 10", n, pid)
+		}
+	}
+}
+
+func TestGlobalRunQueueSize(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// Gosched puts goroutines on the global run queue. Whichever
+	// goroutine the scheduler takes from it first may find it empty,
+	// as the rest are moved to the local queue, so sample it from
+	// all of them.
+	var seen int32
+	sample := func() {
+		if runtime.GlobalRunQueueSize() > 0 {
+			atomic.StoreInt32(&seen, 1)
+		}
+	}
+	stop := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					runtime.Gosched()
+					sample()
+				}
+			}
+		}()
+	}
+	defer close(stop)
+
+	for i := 0; i < 100 && atomic.LoadInt32(&seen) == 0; i++ {
+		runtime.Gosched()
+		sample()
+	}
+	if atomic.LoadInt32(&seen) == 0 {
+		t.Errorf("GlobalRunQueueSize stayed 0 with goroutines calling Gosched")
+	}
+}
+
+func TestNetpollWaiterCount(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	// Other tests may leave connections behind, so only look for
+	// the reader's wait coming and going.
+	base := runtime.NetpollWaiterCount()
+	done := make(chan bool)
+	go func() {
+		var b [1]byte
+		c2.Read(b[:])
+		done <- true
+	}()
+	waitFor := func(cond func(n int32) bool, what string) {
+		for i := 0; i < 1000; i++ {
+			if cond(runtime.NetpollWaiterCount()) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("NetpollWaiterCount = %d, want %s", runtime.NetpollWaiterCount(), what)
+	}
+	waitFor(func(n int32) bool { return n > base }, fmt.Sprintf("> %d with a blocked reader", base))
+	if _, err := c1.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	waitFor(func(n int32) bool { return n <= base }, fmt.Sprintf("<= %d after the reader returned", base))
+}
+
+func TestCountRunnableGoroutines(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// The caller is running, so the count is at least 1.
+	if n := runtime.CountRunnableGoroutines(); n < 1 {
+		t.Errorf("CountRunnableGoroutines = %d, want at least 1", n)
+	}
+
+	// With one P, goroutines started here wait in the run queue
+	// until the caller blocks.
+	const N = 10
+	done := make(chan bool)
+	for i := 0; i < N; i++ {
+		go func() {
+			done <- true
+		}()
+	}
+	n := runtime.CountRunnableGoroutines()
+	for i := 0; i < N; i++ {
+		<-done
+	}
+	if n < N {
+		t.Errorf("CountRunnableGoroutines = %d with %d goroutines queued, want at least %d", n, N, N)
+	}
+}
+
+func TestStartGoroutines(t *testing.T) {
+	const N = 1000
+	var wg sync.WaitGroup
+	goids := make([]int64, N)
+	fns := make([]func(), N)
+	for i := range fns {
+		i := i
+		fns[i] = func() {
+			goids[i] = runtime.Goid()
+			wg.Done()
+		}
+	}
+	wg.Add(N)
+	runtime.StartGoroutines(fns)
+	wg.Wait()
+
+	seen := make(map[int64]bool)
+	for i, id := range goids {
+		if id == 0 || seen[id] {
+			t.Fatalf("goroutine %d has goid %d, want unique nonzero id", i, id)
+		}
+		seen[id] = true
+	}
+	runtime.StartGoroutines(nil)
+}
+
+func TestReadPStats(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	stats := make([]runtime.PStat, 8)
+	n := runtime.ReadPStats(stats)
+	if n != 4 {
+		t.Fatalf("ReadPStats returned %d entries, want 4", n)
+	}
+	for i, s := range stats[:n] {
+		if s.ID != i {
+			t.Errorf("stats[%d].ID = %d, want %d", i, s.ID, i)
+		}
+		if s.RunqSize < 0 || s.RunqSize > 256 {
+			t.Errorf("stats[%d].RunqSize = %d, want in [0, 256]", i, s.RunqSize)
+		}
+	}
+	if n := runtime.ReadPStats(stats[:2]); n != 2 {
+		t.Fatalf("ReadPStats with short slice returned %d entries, want 2", n)
+	}
+}
+
+func TestGlobalQueueSamplingStats(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// Goroutines calling Gosched keep the global run queue busy
+	// and the scheduler going round.
+	checks0, hits0 := runtime.GlobalQueueSamplingStats()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				runtime.Gosched()
+			}
+		}()
+	}
+	wg.Wait()
+	checks1, hits1 := runtime.GlobalQueueSamplingStats()
+	if checks1 <= checks0 {
+		t.Errorf("global queue checks went from %d to %d, want an increase", checks0, checks1)
+	}
+	if hits1 <= hits0 {
+		t.Errorf("global queue hits went from %d to %d, want an increase", hits0, hits1)
+	}
+	if hits1-hits0 > checks1-checks0 {
+		t.Errorf("%d global queue hits in %d checks", hits1-hits0, checks1-checks0)
+	}
+}
+
+func TestStealCount(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	// The busy P cannot be preempted, so a GC would deadlock.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+	before := runtime.StealCount()
+
+	// Queue goroutines on one P and keep that P busy until they have
+	// all run, so the idle Ps have to steal them.
+	const n = 100
+	var ran uint32
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			go func() {
+				atomic.AddUint32(&ran, 1)
+			}()
+		}
+		for atomic.LoadUint32(&ran) < n {
+		}
+		done <- true
+	}()
+	<-done
+	if after := runtime.StealCount(); after <= before {
+		t.Fatalf("StealCount did not increase: %d -> %d", before, after)
+	}
+}
+
+//go:noinline
+func deferOne(x *int) {
+	defer func() { *x++ }()
+}
+
+func TestDeferPoolStats(t *testing.T) {
+	var x int
+	before := runtime.DeferPoolStats()
+	for i := 0; i < 100; i++ {
+		deferOne(&x)
+	}
+	if after := runtime.DeferPoolStats(); after != before {
+		t.Fatalf("DeferPoolStats changed without GODEBUG=deferpoolstats=1: %v -> %v", before, after)
+	}
+
+	defer runtime.SetDebugDeferPoolStats(runtime.SetDebugDeferPoolStats(1))
+	before = runtime.DeferPoolStats()
+	for i := 0; i < 100; i++ {
+		deferOne(&x)
+	}
+	after := runtime.DeferPoolStats()
+	var hits, misses uint64
+	for i := range after {
+		if after[i].Hits < before[i].Hits || after[i].Misses < before[i].Misses {
+			t.Fatalf("size class %d went backwards: %+v -> %+v", i, before[i], after[i])
+		}
+		hits += after[i].Hits - before[i].Hits
+		misses += after[i].Misses - before[i].Misses
+	}
+	// Other goroutines may have deferred too.
+	if hits+misses < 100 {
+		t.Errorf("DeferPoolStats counted %d defers, want at least 100", hits+misses)
+	}
+	// Each defer returns its record to the pool before the next one
+	// takes it, so nearly all should hit.
+	if hits < 90 {
+		t.Errorf("DeferPoolStats counted %d hits and %d misses, want at least 90 hits", hits, misses)
+	}
+}
+
+func TestTryStopTheWorld(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	// A garbage collection could not finish while the loop below
+	// spins.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	if !runtime.TryStopTheWorld(int64(time.Second)) {
+		t.Fatal("TryStopTheWorld failed with no goroutines spinning")
+	}
+
+	var state int32
+	go func() {
+		atomic.StoreInt32(&state, 1)
+		for atomic.LoadInt32(&state) == 1 {
+		}
+	}()
+	for atomic.LoadInt32(&state) == 0 {
+		runtime.Gosched()
+	}
+	if runtime.TryStopTheWorld(int64(10 * time.Millisecond)) {
+		t.Error("TryStopTheWorld succeeded while a goroutine spun without preemption points")
+	}
+	// The world must be running again: other goroutines make progress.
+	done := make(chan bool)
+	go func() { done <- true }()
+	<-done
+	atomic.StoreInt32(&state, 2)
+
+	if !runtime.TryStopTheWorld(int64(time.Second)) {
+		t.Fatal("TryStopTheWorld failed after the spinning goroutine exited")
+	}
+}
+
+func TestReadyOnLastP(t *testing.T) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("needs two CPUs")
+	}
+	defer runtime.SetDebugReadyLastP(runtime.SetDebugReadyLastP(1))
+	// With two Ps, the goroutine's old P is the only idle one when it
+	// is woken, and the waker spins instead of blocking so that its P
+	// can't steal the goroutine from the old P first.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+
+	// A goroutine blocks on one P and is woken from another while its
+	// old P is idle. It should resume on its old P.
+	eligible, resumed := 0, 0
+	for i := 0; i < 20; i++ {
+		var before, after int32 = -1, -1
+		wake := make(chan bool)
+		go func() {
+			atomic.StoreInt32(&before, int32(runtime.CurrentP()))
+			<-wake
+			atomic.StoreInt32(&after, int32(runtime.CurrentP()))
+		}()
+		// Spin rather than block, so that the goroutine is picked
+		// up by the other P, and give it time to block and its P
+		// time to go idle.
+		for atomic.LoadInt32(&before) < 0 {
+		}
+		start := time.Now()
+		for time.Since(start) < 5*time.Millisecond {
+		}
+		mine := runtime.CurrentP()
+		wake <- true
+		for atomic.LoadInt32(&after) < 0 {
+		}
+		if int(before) == mine {
+			continue
+		}
+		eligible++
+		if atomic.LoadInt32(&after) == before {
+			resumed++
+		}
+	}
+	if eligible > 0 && resumed < eligible/2 {
+		t.Errorf("goroutine resumed on its last P %d of %d times", resumed, eligible)
+	}
+}
+
+func TestPinToP(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	if err := runtime.PinToP(4); err == nil {
+		t.Fatal("PinToP(4) with GOMAXPROCS=4 succeeded, want error")
+	}
+
+	// Keep the other Ps busy so the pinned goroutine is picked up
+	// elsewhere from time to time.
+	stop := make(chan bool)
+	for i := 0; i < 8; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	defer close(stop)
+
+	// Wake the pinned goroutine from other goroutines, which puts it
+	// on their P first.
+	ping := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case ping <- true:
+			}
+		}
+	}()
+
+	done := make(chan error)
+	go func() {
+		if err := runtime.PinToP(2); err != nil {
+			done <- err
+			return
+		}
+		defer runtime.PinToP(-1)
+		for i := 0; i < 1000; i++ {
+			if id := runtime.CurrentP(); id != 2 {
+				done <- fmt.Errorf("iteration %d: running on P %d, want P 2", i, id)
+				return
+			}
+			switch {
+			case i%100 == 99:
+				// A GC stops and restarts the world, which
+				// must leave the pin alone.
+				runtime.GC()
+			case i%2 == 0:
+				<-ping
+			default:
+				runtime.Gosched()
+			}
+		}
+		done <- nil
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPinToPAcrossGC(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// Stop the world over and over, so that some stops find the
+	// pinned goroutine on P 2's pin queue.
+	stop := make(chan bool)
+	gcDone := make(chan bool)
+	go func() {
+		defer close(gcDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-gcDone
+	}()
+
+	for i := 0; i < 200; i++ {
+		wake := make(chan bool)
+		ran := make(chan error)
+		go func() {
+			if err := runtime.PinToP(2); err != nil {
+				ran <- err
+				return
+			}
+			defer runtime.PinToP(-1)
+			<-wake
+			ran <- nil
+		}()
+		wake <- true
+		select {
+		case err := <-ran:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("iteration %d: pinned goroutine did not run", i)
+		}
+	}
+}
+
+func TestHandoffTo(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	if err := runtime.HandoffTo(4, func() {}); err == nil {
+		t.Fatal("HandoffTo(4) with GOMAXPROCS=4 succeeded, want error")
+	}
+	if err := runtime.HandoffTo(-1, func() {}); err == nil {
+		t.Fatal("HandoffTo(-1) succeeded, want error")
+	}
+
+	// Each stage hands the next one to the following P.
+	const n = 100
+	done := make(chan error)
+	var stage func(i int)
+	stage = func(i int) {
+		if id, want := runtime.CurrentP(), i%4; id != want {
+			done <- fmt.Errorf("stage %d: started on P %d, want P %d", i, id, want)
+			return
+		}
+		if i == n {
+			done <- nil
+			return
+		}
+		if err := runtime.HandoffTo((i+1)%4, func() { stage(i + 1) }); err != nil {
+			done <- err
+		}
+	}
+	if err := runtime.HandoffTo(0, func() { stage(0) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetSysmonPaused(t *testing.T) {
+	if err := runtime.SetSysmonPaused(true); err == nil {
+		runtime.SetSysmonPaused(false)
+		t.Fatal("SetSysmonPaused without GODEBUG=sysmonpause=1 succeeded, want error")
+	}
+	defer runtime.SetDebugSysmonPause(runtime.SetDebugSysmonPause(1))
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	// spin runs a goroutine that keeps the only P busy for d unless
+	// it is preempted, and reports whether the test goroutine got to
+	// run before it finished.
+	spin := func(d time.Duration) bool {
+		var started, other uint32
+		done := make(chan bool)
+		go func() {
+			atomic.StoreUint32(&started, 1)
+			start := time.Now()
+			for time.Since(start) < d && atomic.LoadUint32(&other) == 0 {
+			}
+			done <- atomic.LoadUint32(&other) != 0
+		}()
+		for atomic.LoadUint32(&started) == 0 {
+			runtime.Gosched()
+		}
+		atomic.StoreUint32(&other, 1)
+		return <-done
+	}
+
+	if err := runtime.SetSysmonPaused(true); err != nil {
+		t.Fatal(err)
+	}
+	preempted := spin(50 * time.Millisecond)
+	if err := runtime.SetSysmonPaused(false); err != nil {
+		t.Fatal(err)
+	}
+	if preempted {
+		t.Error("goroutine was preempted while sysmon was paused")
+	}
+	if !spin(5 * time.Second) {
+		t.Error("goroutine was not preempted after sysmon was resumed")
+	}
+}
+
+func TestSetDeterministicScheduling(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	// A GC would preempt the spinning goroutine below.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	runtime.SetDeterministicScheduling(true)
+	if n := runtime.GOMAXPROCS(-1); n != 1 {
+		t.Errorf("GOMAXPROCS = %d with deterministic scheduling, want 1", n)
+	}
+	// Spin well past the time slice; nothing else may run meanwhile.
+	var ran uint32
+	go atomic.StoreUint32(&ran, 1)
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		if atomic.LoadUint32(&ran) != 0 {
+			runtime.SetDeterministicScheduling(false)
+			t.Fatal("spinning goroutine was preempted")
+		}
+	}
+	runtime.Gosched()
+	if atomic.LoadUint32(&ran) == 0 {
+		t.Error("goroutine did not run after Gosched")
+	}
+
+	runtime.SetDeterministicScheduling(false)
+	if n := runtime.GOMAXPROCS(-1); n != 4 {
+		t.Errorf("GOMAXPROCS = %d after turning deterministic scheduling off, want 4", n)
+	}
+}
+
+func TestTimeSinceLastSchedule(t *testing.T) {
+	// Spin until the preemptor has been watching our time slice for
+	// a while. Being preempted starts a new time slice, so this can
+	// take a few tries.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d := runtime.TimeSinceLastSchedule()
+		if d < 0 || d > int64(5*time.Second) {
+			t.Fatalf("TimeSinceLastSchedule() = %d", d)
+		}
+		if d >= int64(time.Millisecond) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("TimeSinceLastSchedule never reached 1ms while spinning")
+		}
+	}
+}
+
+func TestPreemptHook(t *testing.T) {
+	var want int64
+	found := make(chan bool, 1)
+	runtime.SetPreemptHook(func(goid int64) {
+		if goid == atomic.LoadInt64(&want) {
+			select {
+			case found <- true:
+			default:
+			}
+		}
+	})
+	defer runtime.SetPreemptHook(nil)
+
+	// Spin until sysmon asks the goroutine to yield and the request
+	// is reported.
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		atomic.StoreInt64(&want, runtime.Goid())
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	select {
+	case <-found:
+	case <-time.After(10 * time.Second):
+		t.Fatal("preemption of spinning goroutine was not reported")
+	}
+}
+
+func TestMParkCallback(t *testing.T) {
+	parked := make(chan int64, 1)
+	unparked := make(chan int64, 1)
+	runtime.SetMParkCallback(func(mid int64) {
+		select {
+		case parked <- mid:
+		default:
+		}
+	})
+	defer runtime.SetMParkCallback(nil)
+	runtime.SetMUnparkCallback(func(mid int64) {
+		select {
+		case unparked <- mid:
+		default:
+		}
+	})
+	defer runtime.SetMUnparkCallback(nil)
+
+	// Threads park whenever there is nothing to run, which sleeping
+	// arranges; keep at it until both kinds of event are reported.
+	deadline := time.Now().Add(10 * time.Second)
+	var sawPark, sawUnpark bool
+	for !sawPark || !sawUnpark {
+		if time.Now().After(deadline) {
+			t.Fatalf("park reported: %v, unpark reported: %v", sawPark, sawUnpark)
+		}
+		time.Sleep(time.Millisecond)
+		select {
+		case mid := <-parked:
+			if mid < 0 {
+				t.Fatalf("park reported bad M id %d", mid)
+			}
+			sawPark = true
+		case mid := <-unparked:
+			if mid < 0 {
+				t.Fatalf("unpark reported bad M id %d", mid)
+			}
+			sawUnpark = true
+		default:
+		}
+	}
+}
+
+func TestIdleCallback(t *testing.T) {
+	called := make(chan bool, 1)
+	runtime.SetIdleCallback(func() {
+		select {
+		case called <- true:
+		default:
+		}
+	})
+	defer runtime.SetIdleCallback(nil)
+
+	// Keep a P busy long enough for sysmon to notice. The GC wakes
+	// sysmon in case it was sleeping because everything was idle.
+	runtime.GC()
+	for start := time.Now(); time.Since(start) < 30*time.Millisecond; {
+	}
+	select {
+	case <-called:
+	case <-time.After(10 * time.Second):
+		t.Fatal("idle callback not called")
+	}
+}
+
+func TestSetSpinningLimit(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	runtime.SetSpinningLimit(1)
+	defer runtime.SetSpinningLimit(0)
+
+	// The scheduler must still make progress with a tight limit.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				runtime.Gosched()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := runtime.NumSpinningM(); n < 0 || n > 4 {
+		t.Fatalf("NumSpinningM=%d, want in [0, 4]", n)
+	}
+}
+
+func TestWaitReasonCounts(t *testing.T) {
+	const n = 10
+	c := make(chan bool)
+	var started sync.WaitGroup
+	started.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			started.Done()
+			<-c
+		}()
+	}
+	started.Wait()
+	// Wait for all of them to block.
+	for i := 0; i < 1000; i++ {
+		if runtime.WaitReasonCounts()["chan receive"] >= n {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	counts := runtime.WaitReasonCounts()
+	close(c)
+	if counts["chan receive"] < n {
+		t.Fatalf("WaitReasonCounts()[%q] = %d, want >= %d (counts: %v)", "chan receive", counts["chan receive"], n, counts)
+	}
+}
+
+func parkOnChan(c chan bool, started *sync.WaitGroup) {
+	started.Done()
+	<-c
+}
+
+func TestParkedByFunction(t *testing.T) {
+	const n = 10
+	c := make(chan bool)
+	defer close(c)
+	var started sync.WaitGroup
+	started.Add(n)
+	for i := 0; i < n; i++ {
+		go parkOnChan(c, &started)
+	}
+	started.Wait()
+	pc := reflect.ValueOf(parkOnChan).Pointer()
+	// Wait for all of them to block.
+	var counts map[uintptr]int
+	for i := 0; i < 1000; i++ {
+		if counts = runtime.ParkedByFunction(); counts[pc] >= n {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if counts[pc] != n {
+		t.Fatalf("ParkedByFunction()[parkOnChan] = %d, want %d", counts[pc], n)
+	}
+	if f := runtime.FuncForPC(pc); f == nil || !strings.HasSuffix(f.Name(), ".parkOnChan") {
+		t.Errorf("FuncForPC(%#x) = %v, want parkOnChan", pc, f)
+	}
+	for pc, k := range counts {
+		if k == 0 {
+			t.Errorf("ParkedByFunction()[%#x] = 0", pc)
+		}
+	}
+}
+
+func TestGoroutineStates(t *testing.T) {
+	c := make(chan bool)
+	started := make(chan bool)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		started <- true
+		<-c
+	}()
+	<-started
+	defer close(c)
+
+	states := make([]runtime.GState, runtime.NumGoroutine()+10)
+	n := runtime.GoroutineStates(states)
+	if n == 0 {
+		t.Fatal("GoroutineStates returned no goroutines")
+	}
+	var running, locked int
+	for _, s := range states[:n] {
+		if s.Goid <= 0 || s.StartPC == 0 {
+			t.Errorf("bad goroutine state %+v", s)
+		}
+		if s.Status == "running" {
+			running++
+		}
+		if s.LockedM {
+			locked++
+			if s.Status == "waiting" && s.WaitReason == "" {
+				t.Errorf("waiting goroutine with empty WaitReason: %+v", s)
+			}
+		}
+	}
+	if running == 0 {
+		t.Errorf("no running goroutine reported")
+	}
+	if locked == 0 {
+		t.Errorf("no locked goroutine reported")
+	}
+	if n := runtime.GoroutineStates(states[:1]); n != 1 {
+		t.Errorf("GoroutineStates with short slice returned %d, want 1", n)
+	}
+}
+
+func TestGoroutineLabels(t *testing.T) {
+	want := map[string]string{"request": "42"}
+	goid := make(chan int64)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		runtime.SetCurrentGoroutineLabels(want)
+		// Children inherit the labels.
+		go func() {
+			goid <- runtime.Goid()
+			<-done
+		}()
+		goid <- runtime.Goid()
+		<-done
+	}()
+	for i := 0; i < 2; i++ {
+		id := <-goid
+		got, ok := runtime.GoroutineLabels(id)
+		if !ok {
+			t.Fatalf("GoroutineLabels(%d) did not find goroutine", id)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GoroutineLabels(%d) = %v, want %v", id, got, want)
+		}
+	}
+	if got, ok := runtime.GoroutineLabels(runtime.Goid()); !ok || got != nil {
+		t.Errorf("GoroutineLabels of unlabeled goroutine = %v, %v, want nil, true", got, ok)
+	}
+	if _, ok := runtime.GoroutineLabels(-1); ok {
+		t.Errorf("GoroutineLabels(-1) found a goroutine")
+	}
+}
+
+func TestGoroutineCPUTime(t *testing.T) {
+	goid := make(chan int64)
+	done := make(chan time.Duration)
+	go func() {
+		goid <- runtime.Goid()
+		start := time.Now()
+		for time.Since(start) < 20*time.Millisecond {
+		}
+		elapsed := time.Since(start)
+		done <- elapsed
+		<-done
+	}()
+	id := <-goid
+	elapsed := <-done
+	defer close(done)
+
+	cpu, ok := runtime.GoroutineCPUTime(id)
+	if !ok {
+		t.Fatalf("GoroutineCPUTime(%d) did not find goroutine", id)
+	}
+	if cpu < int64(10*time.Millisecond) || cpu > int64(elapsed+time.Second) {
+		t.Errorf("GoroutineCPUTime = %v, want about %v", time.Duration(cpu), elapsed)
+	}
+	if _, ok := runtime.GoroutineCPUTime(-1); ok {
+		t.Errorf("GoroutineCPUTime(-1) found a goroutine")
+	}
+}
+
+func useStackKB(n int) int {
+	var buf [1024]byte
+	if n == 0 {
+		return len(buf)
+	}
+	return useStackKB(n-1) + int(buf[n%len(buf)])
+}
+
+func TestGoroutineStackSize(t *testing.T) {
+	goid := make(chan int64)
+	done := make(chan bool)
+	go func() {
+		useStackKB(64)
+		goid <- runtime.Goid()
+		<-done
+	}()
+	id := <-goid
+	defer close(done)
+
+	size, ok := runtime.GoroutineStackSize(id)
+	if !ok {
+		t.Fatalf("GoroutineStackSize(%d) did not find goroutine", id)
+	}
+	if size < 64<<10 {
+		t.Errorf("GoroutineStackSize = %d after using 64kB of stack, want at least %d", size, 64<<10)
+	}
+	if _, ok := runtime.GoroutineStackSize(-1); ok {
+		t.Errorf("GoroutineStackSize(-1) found a goroutine")
+	}
+}
+
+func TestGoroutineSchedCount(t *testing.T) {
+	goid := make(chan int64)
+	done := make(chan bool)
+	go func() {
+		goid <- runtime.Goid()
+		for i := 0; i < 10; i++ {
+			runtime.Gosched()
+		}
+		done <- true
+		<-done
+	}()
+	id := <-goid
+	<-done
+	defer close(done)
+
+	n, ok := runtime.GoroutineSchedCount(id)
+	if !ok {
+		t.Fatalf("GoroutineSchedCount(%d) did not find goroutine", id)
+	}
+	if n < 11 {
+		t.Errorf("GoroutineSchedCount = %d, want at least 11", n)
+	}
+	if _, ok := runtime.GoroutineSchedCount(-1); ok {
+		t.Errorf("GoroutineSchedCount(-1) found a goroutine")
+	}
+}
+
+// preemptSpinStep is recursive so that it is not a leaf function,
+// which would skip the stack check that notices preemption requests.
+//go:noinline
+func preemptSpinStep(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return preemptSpinStep(n-1) + 1
+}
+
+func TestPreemptGoroutine(t *testing.T) {
+	if runtime.PreemptGoroutine(runtime.Goid()) {
+		t.Error("PreemptGoroutine of the caller succeeded")
+	}
+	if runtime.PreemptGoroutine(-1) {
+		t.Error("PreemptGoroutine(-1) succeeded")
+	}
+	blocked := make(chan int64)
+	go func() {
+		blocked <- runtime.Goid()
+		<-blocked
+	}()
+	id := <-blocked
+	defer close(blocked)
+	if runtime.PreemptGoroutine(id) {
+		t.Error("PreemptGoroutine of a blocked goroutine succeeded")
+	}
+
+	// Keep sysmon from preempting the spinning goroutine, so that
+	// only PreemptGoroutine can.
+	defer runtime.SetDebugSysmonPause(runtime.SetDebugSysmonPause(1))
+	if err := runtime.SetSysmonPaused(true); err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.SetSysmonPaused(false)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	var stop uint32
+	goid := make(chan int64)
+	done := make(chan bool)
+	go func() {
+		goid <- runtime.Goid()
+		x := 0
+		for atomic.LoadUint32(&stop) == 0 {
+			x += preemptSpinStep(1)
+		}
+		done <- true
+	}()
+	id = <-goid
+	defer func() {
+		atomic.StoreUint32(&stop, 1)
+		<-done
+	}()
+
+	before, _ := runtime.GoroutineSchedCount(id)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if runtime.PreemptGoroutine(id) {
+			// The goroutine is scheduled again once it stops.
+			for time.Now().Before(deadline) {
+				if n, _ := runtime.GoroutineSchedCount(id); n > before {
+					return
+				}
+				runtime.Gosched()
+			}
+			t.Fatal("goroutine was not rescheduled after PreemptGoroutine")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("PreemptGoroutine of a running goroutine never succeeded")
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestWaitEdges(t *testing.T) {
+	recv := make(chan int)
+	send := make(chan int)
+	sel1, sel2 := make(chan int), make(chan int)
+	idRecv, idSend, idSelect := make(chan int64, 1), make(chan int64, 1), make(chan int64, 1)
+	go func() {
+		idRecv <- runtime.Goid()
+		<-recv
+	}()
+	go func() {
+		idSend <- runtime.Goid()
+		send <- 1
+	}()
+	go func() {
+		idSelect <- runtime.Goid()
+		select {
+		case <-sel1:
+		case sel2 <- 1:
+		}
+	}()
+	defer func() {
+		close(recv)
+		<-send
+		close(sel1)
+	}()
+	r, s, sel := <-idRecv, <-idSend, <-idSelect
+	want := []runtime.WaitEdge{
+		{Goid: r, Chan: reflect.ValueOf(recv).Pointer(), Send: false},
+		{Goid: s, Chan: reflect.ValueOf(send).Pointer(), Send: true},
+		{Goid: sel, Chan: reflect.ValueOf(sel1).Pointer(), Send: false},
+		{Goid: sel, Chan: reflect.ValueOf(sel2).Pointer(), Send: true},
+	}
+
+	// The goroutines may not have blocked yet.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := make(map[runtime.WaitEdge]bool)
+		for _, e := range runtime.WaitEdges() {
+			got[e] = true
+		}
+		var missing []runtime.WaitEdge
+		for _, w := range want {
+			if !got[w] {
+				missing = append(missing, w)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("WaitEdges is missing %+v", missing)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWaitEdgesManyWaiters(t *testing.T) {
+	const n = 1000
+	c := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			<-c
+			wg.Done()
+		}()
+	}
+	defer func() {
+		close(c)
+		wg.Wait()
+	}()
+
+	// The goroutines may not have blocked yet.
+	want := reflect.ValueOf(c).Pointer()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := 0
+		for _, e := range runtime.WaitEdges() {
+			if e.Chan == want {
+				if e.Send {
+					t.Fatalf("WaitEdges reports %+v as a send", e)
+				}
+				got++
+			}
+		}
+		if got == n {
+			break
+		}
+		if got > n || time.Now().After(deadline) {
+			t.Fatalf("WaitEdges reports %d edges to the channel, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// processStart is when the test package was initialized, some time
+// after the runtime started.
+var processStart = time.Now()
+
+func TestRuntimeStartTime(t *testing.T) {
+	start := runtime.RuntimeStartTime()
+	if start <= 0 {
+		t.Fatalf("RuntimeStartTime() = %d, want > 0", start)
+	}
+	uptime := time.Duration(runtime.RuntimeNanotime() - start)
+	if uptime <= 0 {
+		t.Fatalf("uptime from RuntimeStartTime is %v, want > 0", uptime)
+	}
+	if since := time.Since(processStart); uptime < since {
+		t.Errorf("uptime from RuntimeStartTime is %v, want at least %v", uptime, since)
 	}
-	<-done
-	<-done
 }
 
-// The function is used to test preemption at split stack checks.
-// Declaring a var avoids inlining at the call site.
-var preempt = func() int {
-	var a [128]int
-	sum := 0
-	for _, v := range a {
-		sum += v
+func TestOldestBlockedGoroutine(t *testing.T) {
+	block := make(chan bool)
+	defer close(block)
+	started := make(chan bool)
+	go func() {
+		started <- true
+		<-block
+	}()
+	<-started
+	// Blocked goroutines are timed from the collection that finds
+	// them blocked.
+	runtime.GC()
+	const wait = 50 * time.Millisecond
+	time.Sleep(wait)
+
+	// Other goroutines in the test binary may have been blocked
+	// longer, but none can have been blocked for less.
+	goid, age := runtime.OldestBlockedGoroutine()
+	if goid == 0 {
+		t.Fatalf("OldestBlockedGoroutine found no blocked goroutine")
+	}
+	if age < int64(wait) {
+		t.Errorf("OldestBlockedGoroutine age = %v, want at least %v", time.Duration(age), wait)
 	}
-	return sum
 }
 
-func TestPreemption(t *testing.T) {
-	// Test that goroutines are preempted at function calls.
-	N := 5
-	if testing.Short() {
-		N = 2
+func creationSiteTarget(goid chan int64, done chan bool) {
+	goid <- runtime.Goid()
+	<-done
+}
+
+func TestGoroutineCreationSite(t *testing.T) {
+	goid := make(chan int64)
+	done := make(chan bool)
+	go creationSiteTarget(goid, done)
+	id := <-goid
+
+	startPC, goPC, ok := runtime.GoroutineCreationSite(id)
+	close(done)
+	if !ok {
+		t.Fatalf("GoroutineCreationSite(%d) did not find goroutine", id)
 	}
-	c := make(chan bool)
-	var x uint32
-	for g := 0; g < 2; g++ {
-		go func(g int) {
-			for i := 0; i < N; i++ {
-				for atomic.LoadUint32(&x) != uint32(g) {
-					preempt()
-				}
-				atomic.StoreUint32(&x, uint32(1-g))
-			}
-			c <- true
-		}(g)
+	if name := runtime.FuncForPC(startPC).Name(); name != "runtime_test.creationSiteTarget" {
+		t.Errorf("start function is %q, want runtime_test.creationSiteTarget", name)
+	}
+	if name := runtime.FuncForPC(goPC).Name(); name != "runtime_test.TestGoroutineCreationSite" {
+		t.Errorf("go statement is in %q, want runtime_test.TestGoroutineCreationSite", name)
+	}
+	if _, _, ok := runtime.GoroutineCreationSite(-1); ok {
+		t.Errorf("GoroutineCreationSite(-1) found a goroutine")
 	}
-	<-c
-	<-c
 }
 
-func TestPreemptionGC(t *testing.T) {
-	// Test that pending GC preempts running goroutines.
-	P := 5
-	N := 10
-	if testing.Short() {
-		P = 3
-		N = 2
-	}
-	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P + 1))
-	var stop uint32
-	for i := 0; i < P; i++ {
+func TestThreadCreateHook(t *testing.T) {
+	var created int32
+	runtime.SetThreadCreateHook(func(mid int64) {
+		atomic.AddInt32(&created, 1)
+	})
+	defer runtime.SetThreadCreateHook(nil)
+
+	// Each goroutine holds its own thread while blocked, so with
+	// more of them than existing threads the runtime has to create
+	// new ones.
+	nthreads, _ := runtime.ThreadCreateProfile(nil)
+	n := nthreads + 2
+	var wg sync.WaitGroup
+	release := make(chan bool)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
 		go func() {
-			for atomic.LoadUint32(&stop) == 0 {
-				preempt()
-			}
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			wg.Done()
+			<-release
 		}()
 	}
-	for i := 0; i < N; i++ {
+	wg.Wait()
+	// Keep a P busy so sysmon stays awake to wake the hook.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&created) == 0 && time.Now().Before(deadline) {
 		runtime.Gosched()
-		runtime.GC()
 	}
-	atomic.StoreUint32(&stop, 1)
+	close(release)
+	if atomic.LoadInt32(&created) == 0 {
+		t.Fatal("thread create hook was not called")
+	}
 }
 
-func TestGCFairness(t *testing.T) {
-	output := runTestProg(t, "testprog", "GCFairness")
-	want := "OK\n"
-	if output != want {
-		t.Fatalf("want %s, got %s\n", want, output)
+func TestHookRing(t *testing.T) {
+	r := new(runtime.HookRing)
+	r.Put(1)
+	slot := r.Claim()
+	r.Put(3)
+	// The claimed slot isn't written yet, so delivery stops there.
+	if got := r.Get(); !reflect.DeepEqual(got, []int64{1}) {
+		t.Fatalf("Get with a claimed slot = %v, want [1]", got)
+	}
+	r.Write(slot, 2)
+	if got := r.Get(); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Fatalf("Get after writing the slot = %v, want [2 3]", got)
 	}
-}
 
-func TestGCFairness2(t *testing.T) {
-	output := runTestProg(t, "testprog", "GCFairness2")
-	want := "OK\n"
-	if output != want {
-		t.Fatalf("want %s, got %s\n", want, output)
+	// When the ring wraps, the oldest events are dropped.
+	for i := int64(0); i < 300; i++ {
+		r.Put(i)
+	}
+	got := r.Get()
+	if len(got) != 256 || got[0] != 300-256 || got[255] != 299 {
+		t.Fatalf("Get after wrapping returned %d events from %v to %v, want 256 from 44 to 299", len(got), got[0], got[len(got)-1])
 	}
 }
 
-func TestNumGoroutine(t *testing.T) {
-	output := runTestProg(t, "testprog", "NumGoroutine")
-	want := "1\n"
-	if output != want {
-		t.Fatalf("want %q, got %q", want, output)
+func TestHookHelpersAreSystemGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	runtime.SetThreadCreateHook(func(int64) {})
+	runtime.SetPreemptHook(func(int64) {})
+	runtime.SetMParkCallback(func(int64) {})
+	runtime.ObserveGStatusTransitions(func(int64, uint32, uint32) {})
+	runtime.SetStackGrowthHook(func(int64, uintptr, uintptr) {})
+	runtime.SetSyscallRetakeHook(func(int64, int64) {})
+	runtime.SetIdleCallback(func() {})
+	runtime.SetGoroutineExitHook(func(int64) {})
+	runtime.SetThreadCreateHook(nil)
+	runtime.SetPreemptHook(nil)
+	runtime.SetMParkCallback(nil)
+	runtime.ObserveGStatusTransitions(nil)
+	runtime.SetStackGrowthHook(nil)
+	runtime.SetSyscallRetakeHook(nil)
+	runtime.SetIdleCallback(nil)
+	runtime.SetGoroutineExitHook(nil)
+
+	if after := runtime.NumGoroutine(); after != before {
+		t.Errorf("NumGoroutine = %d after installing hooks, want %d", after, before)
 	}
-
 	buf := make([]byte, 1<<20)
+	if stk := string(buf[:runtime.Stack(buf, true)]); strings.Contains(stk, "hookHelperMain") {
+		t.Errorf("hook helpers appear in traceback:\n%s", stk)
+	}
+}
 
-	// Try up to 10 times for a match before giving up.
-	// This is a fundamentally racy check but it's important
-	// to notice if NumGoroutine and Stack are _always_ out of sync.
-	for i := 0; ; i++ {
-		// Give goroutines about to exit a chance to exit.
-		// The NumGoroutine and Stack below need to see
-		// the same state of the world, so anything we can do
-		// to keep it quiet is good.
-		runtime.Gosched()
-
-		n := runtime.NumGoroutine()
-		buf = buf[:runtime.Stack(buf, true)]
-
-		nstk := strings.Count(string(buf), "goroutine ")
-		if n == nstk {
-			break
-		}
-		if i >= 10 {
-			t.Fatalf("NumGoroutine=%d, but found %d goroutines in stack dump: %s", n, nstk, buf)
-		}
+func TestPeakThreadCount(t *testing.T) {
+	// Hold more threads than currently exist, each locked to a
+	// blocked goroutine.
+	nthreads, _ := runtime.ThreadCreateProfile(nil)
+	n := nthreads + 2
+	var wg sync.WaitGroup
+	release := make(chan bool)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			wg.Done()
+			<-release
+		}()
+	}
+	wg.Wait()
+	peak := runtime.PeakThreadCount()
+	close(release)
+	if peak < int32(n) {
+		t.Errorf("PeakThreadCount = %d with %d threads locked, want at least %d", peak, n, n)
+	}
+	if after := runtime.PeakThreadCount(); after < peak {
+		t.Errorf("PeakThreadCount decreased from %d to %d", peak, after)
 	}
 }
 
@@ -564,6 +2460,13 @@ func TestSchedLocalQueueSteal(t *testing.T) {
 	runtime.RunSchedLocalQueueStealTest()
 }
 
+func TestSchedLocalQueuePriority(t *testing.T) {
+	if race.Enabled {
+		t.Skip("scheduling order is randomized in race mode")
+	}
+	runtime.RunSchedLocalQueuePriorityTest()
+}
+
 func TestSchedLocalQueueEmpty(t *testing.T) {
 	if runtime.NumCPU() == 1 {
 		// Takes too long and does not trigger the race.
@@ -765,6 +2668,99 @@ func BenchmarkWakeupParallelSyscall(b *testing.B) {
 	})
 }
 
+func TestTotalSyscallTime(t *testing.T) {
+	if sysNanosleep == nil {
+		t.Skipf("skipping on %v; sysNanosleep not defined", runtime.GOOS)
+	}
+	before := runtime.TotalSyscallTime()
+	sysNanosleep(20 * time.Millisecond)
+	if d := time.Duration(runtime.TotalSyscallTime() - before); d < 15*time.Millisecond {
+		t.Errorf("TotalSyscallTime grew by %v across a 20ms system call", d)
+	}
+}
+
+func TestSyscallRetakeHook(t *testing.T) {
+	if sysNanosleep == nil {
+		t.Skipf("skipping on %v; sysNanosleep not defined", runtime.GOOS)
+	}
+	type retake struct{ pid, ns int64 }
+	retaken := make(chan retake, 1)
+	runtime.SetSyscallRetakeHook(func(pid int64, durationNs int64) {
+		select {
+		case retaken <- retake{pid, durationNs}:
+		default:
+		}
+	})
+	defer runtime.SetSyscallRetakeHook(nil)
+
+	// A system call well past the retake threshold loses its P.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sysNanosleep(50 * time.Millisecond)
+		select {
+		case r := <-retaken:
+			if procs := int64(runtime.GOMAXPROCS(-1)); r.pid < 0 || r.pid >= procs {
+				t.Errorf("retake reported P %d with GOMAXPROCS=%d", r.pid, procs)
+			}
+			if r.ns <= 0 {
+				t.Errorf("retake reported duration %dns", r.ns)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("retake of P blocked in system call was not reported")
+		}
+	}
+}
+
+func TestSetMaxIdleThreads(t *testing.T) {
+	if sysNanosleep == nil {
+		t.Skipf("skipping on %v; sysNanosleep not defined", runtime.GOOS)
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	defer runtime.SetMaxIdleThreads(0)
+
+	// Goroutines blocked in system calls have their Ps handed to new
+	// threads, which are left idle afterwards.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sysNanosleep(20 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	time.Sleep(10 * time.Millisecond)
+	if n := runtime.NumIdleM(); n <= 1 {
+		t.Skipf("only %d idle threads after blocking in system calls", n)
+	}
+
+	// Keep one P busy so sysmon doesn't go to sleep, leaving the
+	// other for exiting threads to release.
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				runtime.Gosched()
+			}
+		}
+	}()
+	runtime.SetMaxIdleThreads(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumIdleM() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle threads after SetMaxIdleThreads(1)", runtime.NumIdleM())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 type Matrix [][]float64
 
 func BenchmarkMatmult(b *testing.B) {
@@ -833,6 +2829,10 @@ func TestStealOrder(t *testing.T) {
 	runtime.RunStealOrderTest()
 }
 
+func TestStealOrderNUMA(t *testing.T) {
+	runtime.RunStealOrderNUMATest()
+}
+
 func TestLockOSThreadNesting(t *testing.T) {
 	go func() {
 		e, i := runtime.LockOSCounts()
@@ -857,6 +2857,29 @@ func TestLockOSThreadNesting(t *testing.T) {
 	}()
 }
 
+func TestIsLockedToThread(t *testing.T) {
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		if runtime.IsLockedToThread() {
+			t.Error("new goroutine is locked to its thread")
+			return
+		}
+		runtime.LockOSThread()
+		runtime.LockOSThread()
+		runtime.UnlockOSThread()
+		if !runtime.IsLockedToThread() {
+			t.Error("not locked after LockOSThread twice and UnlockOSThread once")
+			return
+		}
+		runtime.UnlockOSThread()
+		if runtime.IsLockedToThread() {
+			t.Error("still locked after matching UnlockOSThread calls")
+		}
+	}()
+	<-done
+}
+
 func TestLockOSThreadExit(t *testing.T) {
 	testLockOSThreadExit(t, "testprog")
 }
@@ -873,3 +2896,148 @@ func testLockOSThreadExit(t *testing.T, prog string) {
 		t.Errorf("want %s, got %s\n", want, output)
 	}
 }
+
+func TestThreadLimitCallback(t *testing.T) {
+	output := runTestProg(t, "testprog", "ThreadLimitCallback")
+	want := "OK\n"
+	if output != want {
+		t.Errorf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestStealVictims(t *testing.T) {
+	var v runtime.StealVictims
+	const now = 1e9
+	if v.Recent(0, now) {
+		t.Fatalf("P 0 is a recent victim before any steal")
+	}
+	v.Note(3, now)
+	if !v.Recent(3, now+runtime.StealVictimWindow-1) {
+		t.Errorf("P 3 is not a recent victim within the window")
+	}
+	if v.Recent(3, now+runtime.StealVictimWindow) {
+		t.Errorf("P 3 is still a recent victim after the window")
+	}
+	if v.Recent(4, now) {
+		t.Errorf("P 4 is a recent victim without a steal")
+	}
+	// Older records are replaced once the set is full.
+	for id := int32(10); id < 20; id++ {
+		v.Note(id, now)
+	}
+	if v.Recent(3, now) {
+		t.Errorf("P 3 is still a recent victim after many other steals")
+	}
+	if !v.Recent(19, now) {
+		t.Errorf("P 19 is not a recent victim")
+	}
+}
+
+func TestPinPToCurrentM(t *testing.T) {
+	// Changing GOMAXPROCS back also undoes any binding left behind.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	// Keep the other Ps coming and going, so that the bound
+	// goroutine is readied on any of them.
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				time.Sleep(20 * time.Microsecond)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(stop)
+
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		pid, err := runtime.PinPToCurrentM()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer runtime.UnpinP(pid)
+		if again, err := runtime.PinPToCurrentM(); again != pid || err != nil {
+			done <- fmt.Errorf("PinPToCurrentM again = %d, %v, want %d, nil", again, err, pid)
+			return
+		}
+		for i := 0; i < 200; i++ {
+			switch i % 3 {
+			case 0:
+				time.Sleep(50 * time.Microsecond)
+			case 1:
+				runtime.Gosched()
+			case 2:
+				if i%30 == 2 {
+					runtime.GC()
+				}
+			}
+			if got := runtime.CurrentP(); got != pid {
+				done <- fmt.Errorf("iteration %d: running on P %d, want bound P %d", i, got, pid)
+				return
+			}
+		}
+		done <- nil
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runtime.UnpinP(-1); err == nil {
+		t.Errorf("UnpinP(-1) succeeded")
+	}
+	if err := runtime.UnpinP(4); err == nil {
+		t.Errorf("UnpinP(GOMAXPROCS) succeeded")
+	}
+}
+
+func TestPinPToCurrentMLastP(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	pid, err := runtime.PinPToCurrentM()
+	if err != nil {
+		t.Fatalf("PinPToCurrentM: %v", err)
+	}
+	defer runtime.UnpinP(pid)
+
+	// Binding the other P would leave none unbound.
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		_, err := runtime.PinPToCurrentM()
+		done <- err
+	}()
+	if err := <-done; err == nil {
+		t.Errorf("PinPToCurrentM bound the last unbound P")
+	}
+
+	// After unbinding, the other P may be bound instead.
+	runtime.UnpinP(pid)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		pid, err := runtime.PinPToCurrentM()
+		if err == nil {
+			runtime.UnpinP(pid)
+		}
+		done <- err
+	}()
+	if err := <-done; err != nil {
+		t.Errorf("PinPToCurrentM after UnpinP: %v", err)
+	}
+}
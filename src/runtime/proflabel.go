@@ -4,7 +4,10 @@
 
 package runtime
 
-import "unsafe"
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
 
 var labelSync uintptr
 
@@ -38,3 +41,206 @@ func runtime_setProfLabel(labels unsafe.Pointer) {
 func runtime_getProfLabel() unsafe.Pointer {
 	return getg().labels
 }
+
+// SetCurrentGoroutineLabels sets the labels of the calling goroutine
+// to a copy of m, replacing any it had; a nil m removes them. These are
+// the same labels that runtime/pprof attaches to profile samples, so
+// this also overrides labels set with pprof.Do or
+// pprof.SetGoroutineLabels. Goroutines started by the calling goroutine
+// afterwards inherit the labels.
+func SetCurrentGoroutineLabels(m map[string]string) {
+	var labels unsafe.Pointer
+	if m != nil {
+		c := new(map[string]string)
+		*c = make(map[string]string, len(m))
+		for k, v := range m {
+			(*c)[k] = v
+		}
+		labels = unsafe.Pointer(c)
+	}
+	runtime_setProfLabel(labels)
+}
+
+// GoroutineLabels returns a copy of the labels of the goroutine with
+// the given id, and whether such a goroutine exists. The labels are
+// those set by SetCurrentGoroutineLabels or runtime/pprof, including
+// ones inherited from the goroutine's creator.
+func GoroutineLabels(goid int64) (map[string]string, bool) {
+	var labels unsafe.Pointer
+	found := false
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp.goid != goid {
+			continue
+		}
+		if s := readgstatus(gp) &^ _Gscan; s != _Gidle && s != _Gdead {
+			labels = gp.labels
+			found = true
+		}
+		break
+	}
+	unlock(&allglock)
+	if labels == nil {
+		return nil, found
+	}
+	// Label maps are never modified once set, so it is safe to copy
+	// this one outside allglock.
+	m := *(*map[string]string)(labels)
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c, found
+}
+
+// labelLimit caps how many goroutines in a label group may run at
+// once; see SetLabelConcurrencyLimit.
+type labelLimit struct {
+	key, value string
+	max        int32    // 0 if the limit was removed
+	running    int32    // goroutines admitted by schedule and not yet descheduled
+	waithead   guintptr // runnable goroutines held back by the limit
+	waittail   guintptr
+}
+
+var labelLimits struct {
+	lock mutex
+	n    uint32 // number of limits with max > 0; read atomically
+	// list holds every limit ever set. Entries are never removed,
+	// since g.labelGroup refers to them by index.
+	list []*labelLimit
+}
+
+// SetLabelConcurrencyLimit limits the number of goroutines whose
+// labels map key to value that the scheduler runs simultaneously to
+// max. A max of zero or less removes the limit. Labels are those set by
+// SetCurrentGoroutineLabels or runtime/pprof.
+//
+// The limit is a coarse form of admission control: when the scheduler
+// picks a goroutine whose group is at its limit, it sets the goroutine
+// aside until a running member of the group blocks, yields or exits.
+// A goroutine in a system call still counts as running. If a goroutine
+// matches several limits, only the first one set applies.
+//
+// While any limit is set, scheduling a goroutine that has labels costs
+// a lock acquisition and a map lookup per limit.
+func SetLabelConcurrencyLimit(key, value string, max int) {
+	if max < 0 {
+		max = 0
+	} else if max > 1<<30 {
+		max = 1 << 30
+	}
+	lock(&labelLimits.lock)
+	var l *labelLimit
+	for _, x := range labelLimits.list {
+		if x.key == key && x.value == value {
+			l = x
+			break
+		}
+	}
+	if l == nil {
+		if max == 0 {
+			unlock(&labelLimits.lock)
+			return
+		}
+		l = &labelLimit{key: key, value: value}
+		labelLimits.list = append(labelLimits.list, l)
+	}
+	l.max = int32(max)
+	n := uint32(0)
+	for _, x := range labelLimits.list {
+		if x.max > 0 {
+			n++
+		}
+	}
+	atomic.Store(&labelLimits.n, n)
+	// Let every waiting goroutine try again under the new limit.
+	glist := l.waithead.ptr()
+	l.waithead = 0
+	l.waittail = 0
+	unlock(&labelLimits.lock)
+	if glist != nil {
+		systemstack(func() {
+			labelReady(glist)
+		})
+	}
+}
+
+// labelAdmit reports whether schedule may run gp. If gp's label group
+// is at its limit, labelAdmit queues gp on the group and returns
+// false; otherwise it counts gp against its group, if any, until
+// dropg calls labelRelease. Runtime goroutines are always admitted.
+func labelAdmit(gp *g) bool {
+	if atomic.Load(&labelLimits.n) == 0 || gp.labels == nil || isSystemGoroutine(gp) {
+		return true
+	}
+	// Label maps are never modified once set.
+	m := *(*map[string]string)(gp.labels)
+	lock(&labelLimits.lock)
+	for i, l := range labelLimits.list {
+		if l.max == 0 {
+			continue
+		}
+		if v, ok := m[l.key]; !ok || v != l.value {
+			continue
+		}
+		if l.running >= l.max {
+			gp.schedlink = 0
+			if l.waittail != 0 {
+				l.waittail.ptr().schedlink.set(gp)
+			} else {
+				l.waithead.set(gp)
+			}
+			l.waittail.set(gp)
+			unlock(&labelLimits.lock)
+			return false
+		}
+		l.running++
+		gp.labelGroup = int32(i + 1)
+		break
+	}
+	unlock(&labelLimits.lock)
+	return true
+}
+
+// labelRelease stops counting gp, which is no longer running, against
+// its label group and lets one waiting member of the group try again.
+func labelRelease(gp *g) {
+	lock(&labelLimits.lock)
+	l := labelLimits.list[gp.labelGroup-1]
+	gp.labelGroup = 0
+	l.running--
+	var next *g
+	if l.running < l.max || l.max == 0 {
+		if next = l.waithead.ptr(); next != nil {
+			l.waithead = next.schedlink
+			if l.waithead == 0 {
+				l.waittail = 0
+			}
+			next.schedlink = 0
+		}
+	}
+	unlock(&labelLimits.lock)
+	if next != nil {
+		labelReady(next)
+	}
+}
+
+// labelReady puts the runnable goroutines in glist, linked through
+// schedlink, on the global run queue and starts Ms for idle Ps.
+// It must run on the system stack.
+//
+//go:systemstack
+func labelReady(glist *g) {
+	lock(&sched.lock)
+	var n int
+	for n = 0; glist != nil; n++ {
+		gp := glist
+		glist = gp.schedlink.ptr()
+		globrunqput(gp)
+	}
+	unlock(&sched.lock)
+	for ; n != 0 && sched.npidle != 0; n-- {
+		startm(nil, false)
+	}
+}
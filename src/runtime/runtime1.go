@@ -204,6 +204,15 @@ func check() {
 	if unsafe.Sizeof(y1) != 2 {
 		throw("bad unsafe.Sizeof y1")
 	}
+	if unsafe.Offsetof(p{}.gcFractionalMarkTime)%8 != 0 {
+		throw("bad offsetof p.gcFractionalMarkTime")
+	}
+	if unsafe.Offsetof(p{}.nsteal)%8 != 0 {
+		throw("bad offsetof p.nsteal")
+	}
+	if unsafe.Offsetof(p{}.sysmontick)%8 != 0 {
+		throw("bad offsetof p.sysmontick")
+	}
 
 	if timediv(12345*1000000000+54321, 1000000000, &e) != 12345 || e != 54321 {
 		throw("bad timediv")
@@ -316,6 +325,8 @@ type dbgVar struct {
 var debug struct {
 	allocfreetrace   int32
 	cgocheck         int32
+	deadlockdetail   int32
+	deferpoolstats   int32
 	efence           int32
 	gccheckmark      int32
 	gcpacertrace     int32
@@ -323,22 +334,47 @@ var debug struct {
 	gcrescanstacks   int32
 	gcstoptheworld   int32
 	gctrace          int32
+	goidcachebatch   int32
 	invalidptr       int32
+	madvdontneed     int32
+	maxstackmb       int32
+	netpolloff       int32
+	nosteal          int32
+	preemptus        int32
+	readylastp       int32
+	retakesyscallus  int32
 	// add GODEBUG=sbrk=1 to bypass memory allocator (and GC)
 	// To reduce lock contention in this mode, makes persistent allocation state per-P,
 	// which means at most 64 kB overhead x $GOMAXPROCS, which should be
 	// completely tolerable.
 	// 添加GODEBUG = sbrk = 1以绕过内存分配器（和GC）为了减少此模式下的锁争用，使per-P持久分配状态，
 	// 这意味着最多64 kB开销x $ GOMAXPROCS，这应该是完全可以容忍的。
-	sbrk        int32
-	scavenge    int32
-	scheddetail int32
-	schedtrace  int32
+	sbrk               int32
+	scavenge           int32
+	scheddetail        int32
+	schedglobalevery   int32
+	schedlatency       int32
+	schedrandom        int32
+	schedtrace         int32
+	stealattempts      int32
+	stealnextbackoffus int32
+	stwlog             int32
+	sudogcache         int32
+	syscallmpool       int32
+	sysmonmaxus        int32
+	sysmonminus        int32
+	sysmonpause        int32
+
+	// scavengelimitns is an int64, so like memprofilerate it is
+	// parsed separately from the int32 variables in dbgvars.
+	scavengelimitns int64
 }
 
 var dbgvars = []dbgVar{
 	{"allocfreetrace", &debug.allocfreetrace},
 	{"cgocheck", &debug.cgocheck},
+	{"deadlockdetail", &debug.deadlockdetail},
+	{"deferpoolstats", &debug.deferpoolstats},
 	{"efence", &debug.efence},
 	{"gccheckmark", &debug.gccheckmark},
 	{"gcpacertrace", &debug.gcpacertrace},
@@ -346,17 +382,44 @@ var dbgvars = []dbgVar{
 	{"gcrescanstacks", &debug.gcrescanstacks},
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
+	{"goidcachebatch", &debug.goidcachebatch},
 	{"invalidptr", &debug.invalidptr},
+	{"madvdontneed", &debug.madvdontneed},
+	{"maxstackmb", &debug.maxstackmb},
+	{"netpolloff", &debug.netpolloff},
+	{"nosteal", &debug.nosteal},
+	{"preemptus", &debug.preemptus},
+	{"readylastp", &debug.readylastp},
+	{"retakesyscallus", &debug.retakesyscallus},
 	{"sbrk", &debug.sbrk},
 	{"scavenge", &debug.scavenge},
 	{"scheddetail", &debug.scheddetail},
+	{"schedglobalevery", &debug.schedglobalevery},
+	{"schedlatency", &debug.schedlatency},
+	{"schedrandom", &debug.schedrandom},
 	{"schedtrace", &debug.schedtrace},
+	{"stealattempts", &debug.stealattempts},
+	{"stealnextbackoffus", &debug.stealnextbackoffus},
+	{"stwlog", &debug.stwlog},
+	{"sudogcache", &debug.sudogcache},
+	{"syscallmpool", &debug.syscallmpool},
+	{"sysmonmaxus", &debug.sysmonmaxus},
+	{"sysmonminus", &debug.sysmonminus},
+	{"sysmonpause", &debug.sysmonpause},
 }
 
 func parsedebugvars() {
 	// defaults
 	debug.cgocheck = 1
+	debug.goidcachebatch = _GoidCacheBatch
 	debug.invalidptr = 1
+	debug.schedglobalevery = 61
+	debug.stealattempts = 4
+	debug.stealnextbackoffus = 3
+	debug.sudogcache = 128
+	debug.preemptus = forcePreemptNS / 1000
+	debug.retakesyscallus = retakeSyscallNS / 1000
+	debug.scavengelimitns = scavengeLimitNS
 
 	for p := gogetenv("GODEBUG"); p != ""; {
 		field := ""
@@ -379,6 +442,13 @@ func parsedebugvars() {
 			if n, ok := atoi(value); ok {
 				MemProfileRate = n
 			}
+		} else if key == "scavengelimitns" {
+			if n, ok := atoi64(value); ok && n > 0 {
+				if n < minScavengeLimitNS {
+					n = minScavengeLimitNS
+				}
+				debug.scavengelimitns = n
+			}
 		} else {
 			for _, v := range dbgvars {
 				if v.name == key {
@@ -390,10 +460,35 @@ func parsedebugvars() {
 		}
 	}
 
+	// The retake thresholds must be positive; keep them within
+	// what sysmon can usefully act on.
+	debug.preemptus = clampus(debug.preemptus, forcePreemptNS/1000)
+	debug.retakesyscallus = clampus(debug.retakesyscallus, retakeSyscallNS/1000)
+
+	if debug.sudogcache < 1 {
+		debug.sudogcache = 1
+	} else if debug.sudogcache > maxSudogCache {
+		debug.sudogcache = maxSudogCache
+	}
+
 	setTraceback(gogetenv("GOTRACEBACK"))
 	traceback_env = traceback_cache
 }
 
+// clampus returns the microsecond duration v limited to [100µs, 1s],
+// or def if v is not positive.
+func clampus(v, def int32) int32 {
+	switch {
+	case v <= 0:
+		return def
+	case v < 100:
+		return 100
+	case v > 1000*1000:
+		return 1000 * 1000
+	}
+	return v
+}
+
 //go:linkname setTraceback runtime/debug.SetTraceback
 func setTraceback(level string) {
 	var t uint32
@@ -317,9 +317,12 @@ type sudog struct {
 	// isSelect indicates g is participating in a select, so
 	// g.selectDone must be CAS'd to win the wake-up race.
 	isSelect bool
-	next     *sudog
-	prev     *sudog
-	elem     unsafe.Pointer // data element (may point to stack)
+	// isSend indicates the sudog is queued on c.sendq rather than
+	// c.recvq.
+	isSend bool
+	next   *sudog
+	prev   *sudog
+	elem   unsafe.Pointer // data element (may point to stack)
 
 	// The following fields are never accessed concurrently.
 	// For channels, waitlink is only accessed by g.
@@ -392,6 +395,10 @@ type g struct {
 	// g被阻塞的大体时间
 	waitsince  int64  // approx time when the g become blocked
 	waitreason string // if status==Gwaiting
+	runstart   int64  // nanotime when the g last started running
+	runnable   int64  // nanotime when the g last became runnable, if GODEBUG=schedlatency=1
+	cputime    int64  // time spent running before runstart; see GoroutineCPUTime
+	schedcount uint64 // number of times the g has been scheduled; see GoroutineSchedCount
 	schedlink  guintptr
 	// 标记是否可抢占
 	preempt        bool     // preemption signal, duplicates stackguard0 = stackpreempt
@@ -400,6 +407,8 @@ type g struct {
 	gcscandone     bool     // g has scanned stack; protected by _Gscan bit in status
 	gcscanvalid    bool     // false at start of gc cycle, true if G has not run since last scan; TODO: remove?
 	throwsplit     bool     // must not split stack
+	stackLocked    bool     // stack is locked in memory; see LockCurrentStack
+	priority       uint8    // scheduling priority hint; see SetGoroutinePriority
 	raceignore     int8     // ignore race detection events
 	sysblocktraced bool     // StartTrace has emitted EvGoInSyscall about this goroutine
 	sysexitticks   int64    // cputicks when syscall has returned (for tracing)
@@ -407,6 +416,8 @@ type g struct {
 	tracelastp     puintptr // last P emitted an event for this goroutine
 	// G被锁定只在这个m上运行
 	lockedm  muintptr
+	pinnedP  puintptr // P this G must run on; see PinToP
+	lastp    puintptr // P this G last ran on; see readyLastP
 	sig      uint32
 	writebuf []byte
 	sigcode0 uintptr
@@ -420,6 +431,7 @@ type g struct {
 	waiting    *sudog         // sudog structures this g is waiting on (that have a valid elem ptr); in lock order
 	cgoCtxt    []uintptr      // cgo traceback context
 	labels     unsafe.Pointer // profiler labels
+	labelGroup int32          // 1 + index in labelLimits.list of the limit this G counts against
 	timer      *timer         // cached timer for time.Sleep
 	selectDone uint32         // are we participating in a select and did someone win the race?
 
@@ -511,9 +523,31 @@ type m struct {
 	waittraceskip int
 	startingtrace bool
 	syscalltick   uint32
+	syscallstart  int64   // nanotime when the m entered its current system call, or 0
+	syscalltime   int64   // time spent in system calls; see TotalSyscallTime
 	thread        uintptr // thread handle
 	freelink      *m      // on sched.freem
 
+	// NUMA node of the CPU this m was last seen running on, or -1,
+	// and the numaEpoch in which it was looked up; see procNUMAUpdate.
+	numaNode  int32
+	numaEpoch uint32
+
+	// Ps this m recently stole goroutines from, and when; see
+	// recentVictim.
+	stealVictims    [4]stealVictim
+	stealVictimNext uint32
+
+	// The P this m is bound to by PinPToCurrentM, if any. An m
+	// with a bound P parks outside midle while the P is idle.
+	// All four are protected by sched.lock.
+	boundp      puintptr
+	boundparked bool // parked in stopm waiting for boundp
+	boundkick   bool // boundp was left idle with work while not parked
+	boundready  bool // lockedg is runnable but boundp was busy; see startlockedm
+
+	reaped bool // taken off midle by reapidlem to exit
+
 	// these are here because they are too large to be on the stack
 	// of low-level NOSPLIT functions.
 	libcall   libcall
@@ -525,6 +559,13 @@ type m struct {
 	mOS
 }
 
+// stealVictim records a successful steal by an m from the P with the
+// given id at nanotime when.
+type stealVictim struct {
+	id   int32
+	when int64
+}
+
 type p struct {
 	lock mutex
 
@@ -535,6 +576,8 @@ type p struct {
 	link puintptr
 	// 每调度一次加1
 	schedtick uint32 // incremented on every scheduler call
+	stealGen  uint32 // stealSeed.gen when stealSeq was last reset
+	stealSeq  uint32 // steal passes started since SetStealSeed
 	// 每一次系统调用加1
 	syscalltick uint32     // incremented on every system call
 	sysmontick  sysmontick // last tick observed by sysmon
@@ -546,6 +589,12 @@ type p struct {
 	deferpool    [5][]*_defer // pool of available defer structs of different sizes (see panic.go)
 	deferpoolbuf [5][32]*_defer
 
+	// Defers of each size class taken from deferpool and allocated
+	// because it was empty, if GODEBUG=deferpoolstats=1; see
+	// DeferPoolStats.
+	deferhits   [5]uint64
+	defermisses [5]uint64
+
 	// Cache of goroutine ids, amortizes accesses to runtime·sched.goidgen.
 	// goroutine的ID的缓存
 	goidcache    uint64
@@ -572,8 +621,7 @@ type p struct {
 	gfree    *g
 	gfreecnt int32
 
-	sudogcache []*sudog
-	sudogbuf   [128]*sudog
+	sudogcache []*sudog // capacity is sudogCacheSize
 
 	tracebuf traceBufPtr
 
@@ -590,8 +638,14 @@ type p struct {
 	// Per-P GC state
 	gcAssistTime         int64 // Nanoseconds in assistAlloc
 	gcFractionalMarkTime int64 // Nanoseconds in fractional mark worker
-	gcBgMarkWorker       guintptr
-	gcMarkWorkerMode     gcMarkWorkerMode
+
+	// nsteal counts successful runqsteal calls by this P; see
+	// StealCount. It is accessed atomically, so it must stay 8-byte
+	// aligned; see check.
+	nsteal uint64
+
+	gcBgMarkWorker   guintptr
+	gcMarkWorkerMode gcMarkWorkerMode
 
 	// gcMarkWorkerStartTime is the nanotime() at which this mark
 	// worker started.
@@ -608,10 +662,45 @@ type p struct {
 	wbBuf wbBuf
 
 	runSafePointFn uint32 // if 1, run sched.safePointFn at next safe point
+	numaNode       int32  // NUMA node of the M last seen holding this P, or -1; see procNUMAUpdate
+
+	// This P's copy of stealOrder.nodes as of stealOrder.nodesgen
+	// stealnodesgen; see nodeSnapshot. Only used by this P's M.
+	stealnodes    []int32
+	stealnodesgen uint32
+
+	// Incoming queue: goroutines pinned to this P (see PinToP) that
+	// were picked up by another P, and goroutines handed to this P by
+	// HandoffTo. Unlike runq this may be pushed to by any P, so
+	// it is protected by lock.
+	pinqhead guintptr
+	pinqtail guintptr
+	pinqsize uint32 // accessed atomically
+
+	// Ring of goroutine status transitions for
+	// ObserveGStatusTransitions. Only the M that owns this P writes
+	// it; gstatusHook's helper reads it.
+	gstatusBuf  [128]gstatusEvent
+	gstatusHead uint32 // written atomically
+	gstatusTail uint32 // protected by gstatusHook.h.lock
+
+	// The m this P is bound to by PinPToCurrentM, if any. Only that
+	// m runs this P. Protected by sched.lock.
+	boundm muintptr
+
+	// Histogram of scheduling latencies of goroutines this P has
+	// run, if GODEBUG=schedlatency=1; see SchedLatencyBuckets.
+	schedlatency [schedLatencyBuckets]uint64
 
 	pad [sys.CacheLineSize]byte
 }
 
+// gstatusEvent records a successful casgstatus.
+type gstatusEvent struct {
+	goid           int64
+	oldval, newval uint32
+}
+
 type schedt struct {
 	// accessed atomically. keep at top to ensure alignment on 32-bit systems.
 	goidgen  uint64
@@ -619,8 +708,8 @@ type schedt struct {
 
 	lock mutex
 
-	// When increasing nmidle, nmidlelocked, nmsys, or nmfreed, be
-	// sure to call checkdead().
+	// When increasing nmidle, nmidlelocked, nmsys, nmfreed, or
+	// nmidlebound, be sure to call checkdead().
 	// idle状态的m
 	midle muintptr // idle m's waiting for work
 	// idle状态的m个数
@@ -633,6 +722,9 @@ type schedt struct {
 	nmsys     int32 // number of system m's not counted for deadlock
 	nmfreed   int64 // cumulative number of freed m's
 
+	nboundp     int32 // number of P's bound to an m; see PinPToCurrentM
+	nmidlebound int32 // number of m's parked waiting for their bound P
+
 	// 系统中goroutine的数目，会自动更新
 	ngsys uint32 // number of system goroutines; updated atomically
 
@@ -686,6 +778,8 @@ type schedt struct {
 
 	procresizetime int64 // nanotime() of last change to gomaxprocs
 	totaltime      int64 // ∫gomaxprocs dt up to procresizetime
+
+	syscalltime int64 // syscalltime of Ms that have exited
 }
 
 // Values for the flags field of a sigTabT.
@@ -742,6 +836,7 @@ type forcegcstate struct {
 	lock mutex
 	g    *g
 	idle uint32
+	now  bool // woken by ForceGCNow rather than the forcegcperiod timer
 }
 
 // startup_random_data holds random bytes initialized at startup. These come from
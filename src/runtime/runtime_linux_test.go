@@ -5,7 +5,12 @@
 package runtime_test
 
 import (
+	"bufio"
+	"os"
 	. "runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -52,3 +57,115 @@ func TestMincoreErrorSign(t *testing.T) {
 		t.Errorf("mincore = %v, want %v", v, -EINVAL)
 	}
 }
+
+func TestSetThreadInitFn(t *testing.T) {
+	// fn runs on a goroutine, so it may allocate and take locks.
+	var mu sync.Mutex
+	inited := make(map[int]bool)
+	SetThreadInitFn(func() {
+		mu.Lock()
+		inited[syscall.Gettid()] = true
+		mu.Unlock()
+	})
+	defer SetThreadInitFn(nil)
+
+	// Each goroutine holds its own thread while blocked, so with
+	// more of them than existing threads the runtime has to create
+	// new ones.
+	nthreads, _ := ThreadCreateProfile(nil)
+	n := nthreads + 2
+	tids := make(chan int, n)
+	release := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			LockOSThread()
+			defer UnlockOSThread()
+			tids <- syscall.Gettid()
+			<-release
+		}()
+	}
+	seen := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		seen[<-tids] = true
+	}
+	close(release)
+	wg.Wait()
+
+	found := 0
+	mu.Lock()
+	for tid := range seen {
+		if inited[tid] {
+			found++
+		}
+	}
+	mu.Unlock()
+	if found == 0 {
+		t.Fatalf("thread init function did not run on any of the %d new threads", n-nthreads)
+	}
+}
+
+func TestSetHugePagePolicy(t *testing.T) {
+	if HugePageSize == 0 {
+		t.Skip("no huge pages on this platform")
+	}
+	defer SetHugePagePolicy(true)
+
+	// Reusing released memory marks its whole huge pages with
+	// MADV_HUGEPAGE, which shows as "hg" in smaps, unless the
+	// policy is off.
+	SetHugePagePolicy(true)
+	if !hugePageAdvised(t) {
+		t.Skip("MADV_HUGEPAGE not reported in /proc/self/smaps")
+	}
+	SetHugePagePolicy(false)
+	if hugePageAdvised(t) {
+		t.Fatal("memory reused with huge pages disabled was marked MADV_HUGEPAGE")
+	}
+}
+
+// hugePageAdvised maps fresh memory, releases and reuses two aligned
+// huge pages of it as the heap does, and reports whether the kernel
+// then lists them as advised to use huge pages.
+func hugePageAdvised(t *testing.T) bool {
+	b, err := syscall.Mmap(-1, 0, 4*HugePageSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		t.Fatalf("mmap: %v", err)
+	}
+	defer syscall.Munmap(b)
+	start := (uintptr(unsafe.Pointer(&b[0])) + HugePageSize - 1) &^ (HugePageSize - 1)
+	SysUnusedUsed(unsafe.Pointer(start), 2*HugePageSize)
+
+	f, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		t.Skipf("cannot read smaps: %v", err)
+	}
+	defer f.Close()
+	in := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if r := strings.SplitN(fields[0], "-", 2); len(r) == 2 {
+			lo, err1 := strconv.ParseUint(r[0], 16, 64)
+			hi, err2 := strconv.ParseUint(r[1], 16, 64)
+			if err1 == nil && err2 == nil {
+				in = uint64(start) >= lo && uint64(start) < hi
+				continue
+			}
+		}
+		if in && fields[0] == "VmFlags:" {
+			for _, fl := range fields[1:] {
+				if fl == "hg" {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
@@ -366,6 +366,7 @@ loop:
 		sg := acquireSudog()
 		sg.g = gp
 		sg.isSelect = true
+		sg.isSend = cas.kind == caseSend
 		// No stack splits between assigning elem and enqueuing
 		// sg on gp.waiting where copystack can find it.
 		sg.elem = cas.elem
@@ -993,6 +993,17 @@ func copystack(gp *g, newsize uintptr, sync bool) {
 	if stackPoisonCopy != 0 {
 		fillstack(old, 0xfc)
 	}
+	// Keep the stack locked in memory if LockCurrentStack asked for
+	// it. Locked stacks have pages of their own, so unlocking the
+	// old one leaves other stacks locked. If the kernel refuses to
+	// lock the new one, the stack stays unlocked from now on.
+	if gp.stackLocked {
+		sysUnlock(unsafe.Pointer(old.lo), old.hi-old.lo)
+		if sysLock(unsafe.Pointer(new.lo), new.hi-new.lo) != 0 {
+			gp.stackLocked = false
+		}
+	}
+
 	// 释放旧的栈
 	stackfree(old)
 }
@@ -1181,6 +1192,9 @@ func newstack() {
 	// 由于gp处于Gcopystack状态，因此当我们进行复制时，并发GC不会扫描堆栈。
 	// 完成栈的拷贝
 	copystack(gp, newsize, true)
+	if atomic.Load(&stackGrowthHook.h.enabled) != 0 {
+		stackGrowthHookRecord(gp, oldsize, newsize)
+	}
 	if stackDebug >= 1 {
 		print("stack grow done\n")
 	}
@@ -1241,6 +1255,11 @@ func shrinkstack(gp *g) {
 	if debug.gcshrinkstackoff > 0 {
 		return
 	}
+	if gp.stackLocked {
+		// A smaller stack could share its pages with other stacks;
+		// see LockCurrentStack.
+		return
+	}
 	f := findfunc(gp.startpc)
 	if f.valid() && f.funcID == funcID_gcBgMarkWorker {
 		// We're not allowed to shrink the gcBgMarkWorker
@@ -1327,3 +1346,153 @@ func morestackc() {
 		throw("attempt to execute system stack code on user stack")
 	})
 }
+
+// LockCurrentStack locks the calling goroutine's stack into memory,
+// faulting it in now so that running on it does not page fault later.
+// The runtime keeps the stack locked when it grows, does not shrink
+// it, and unlocks it when the goroutine exits. It is meant for
+// real-time goroutines, together with LockOSThread.
+//
+// The stack is first grown to at least a physical page, so that the
+// pages locked belong to this stack alone. LockCurrentStack returns an
+// error if the kernel refuses the lock, typically because the process
+// would exceed RLIMIT_MEMLOCK (ulimit -l). If the kernel refuses to
+// lock the stack after it grows later, it stays unlocked; calling
+// LockCurrentStack again retries. Only Linux supports locking stacks;
+// elsewhere it always returns an error.
+func LockCurrentStack() error {
+	if GOOS != "linux" {
+		return errorString("LockCurrentStack: not supported on this system")
+	}
+	if physPageSize > _PageSize {
+		// Stacks are not aligned to pages this large.
+		return errorString("LockCurrentStack: not supported with this page size")
+	}
+	gp := getg()
+	var errno int32
+	for done := false; !done; {
+		// Stacks of at least a page are page-aligned.
+		GrowStack(physPageSize)
+		// Lock on the system stack so gp's stack can't move underfoot.
+		systemstack(func() {
+			if gp.stack.hi-gp.stack.lo < physPageSize {
+				// A GC shrank the stack again; retry.
+				return
+			}
+			done = true
+			errno = sysLock(unsafe.Pointer(gp.stack.lo), gp.stack.hi-gp.stack.lo)
+			if errno == 0 {
+				gp.stackLocked = true
+			}
+		})
+	}
+	if errno != 0 {
+		return errorString("LockCurrentStack: mlock failed; the stack may exceed RLIMIT_MEMLOCK")
+	}
+	return nil
+}
+
+// GrowStack grows the calling goroutine's stack to at least n bytes
+// right away, the same way a call needing more stack would, so that
+// the deep calls that follow run without repeated stack copies. It
+// does nothing if the stack is already that large. Asking for more
+// than the maximum stack size (see debug.SetMaxStack) crashes the
+// program with a stack overflow, as running out of stack does.
+//
+// The stack may shrink again at a later garbage collection if most
+// of it goes unused.
+func GrowStack(n uintptr) {
+	// Everything the closure needs is read before the stack moves,
+	// as the closure itself lives on the old stack.
+	systemstack(func() {
+		growstack(getg().m.curg, n)
+	})
+}
+
+// growstack is the system stack half of GrowStack. gp must be the
+// running user goroutine of the calling M.
+func growstack(gp *g, n uintptr) {
+	oldsize := gp.stack.hi - gp.stack.lo
+	if oldsize >= n {
+		return
+	}
+	newsize := oldsize
+	for newsize < n {
+		newsize *= 2
+		if newsize > maxstacksize {
+			print("runtime: goroutine stack exceeds ", maxstacksize, "-byte limit\n")
+			throw("stack overflow")
+		}
+	}
+	casgstatus(gp, _Grunning, _Gcopystack)
+	copystack(gp, newsize, true)
+	if atomic.Load(&stackGrowthHook.h.enabled) != 0 {
+		stackGrowthHookRecord(gp, oldsize, newsize)
+	}
+	casgstatus(gp, _Gcopystack, _Grunning)
+	if gp.preempt {
+		// copystack clobbered the preemption request.
+		gp.stackguard0 = stackPreempt
+	}
+}
+
+// stackGrowthHook holds the state for SetStackGrowthHook. Each growth
+// is recorded in ring, with the goroutine id in a and the old and new
+// stack sizes in b and c.
+var stackGrowthHook struct {
+	h    hookHelper
+	ring hookRing
+	fn   func(goid int64, oldSize, newSize uintptr)
+}
+
+// SetStackGrowthHook arranges for fn to be called each time a
+// goroutine's stack is grown, with the goroutine's id and the old and
+// new sizes of its stack in bytes. Growing a stack copies it, so a
+// goroutine that grows its stack again and again, for example because
+// it repeatedly starts deep recursion from a fresh goroutine, spends
+// time copying that GrowStack could avoid. Passing nil removes the hook.
+//
+// Stacks are grown while the goroutine is half way through a call, so
+// fn is not called inline. Instead the events are buffered and
+// delivered in order on a dedicated goroutine, typically within a few
+// milliseconds. Reporting is best-effort: if fn falls behind, the
+// oldest events are dropped. Growth of the goroutine that delivers the
+// events is not reported. Nothing is recorded while no hook is
+// installed.
+func SetStackGrowthHook(fn func(goid int64, oldSize, newSize uintptr)) {
+	lock(&stackGrowthHook.h.lock)
+	// Don't report growths from before fn was installed.
+	stackGrowthHook.ring.reset()
+	stackGrowthHook.fn = fn
+	stackGrowthHook.h.install(fn != nil, "stack growth hook (idle)", stackGrowthHookWork)
+}
+
+// stackGrowthHookRecord records that gp's stack grew from oldsize to
+// newsize bytes. It runs on the system stack in the middle of newstack,
+// so it must not have write barriers.
+//go:nowritebarrierrec
+func stackGrowthHookRecord(gp *g, oldsize, newsize uintptr) {
+	if gp == stackGrowthHook.h.g {
+		// Don't report the helper growing its stack to deliver
+		// reports.
+		return
+	}
+	stackGrowthHook.ring.put(gp.goid, int64(oldsize), int64(newsize))
+	stackGrowthHook.h.notify()
+}
+
+// stackGrowthHookWork reports stack growths to the hook installed by
+// SetStackGrowthHook.
+func stackGrowthHookWork() {
+	var evs [len(hookRing{}.buf)]hookEvent
+	stackGrowthHook.h.lockHooks()
+	fn := stackGrowthHook.fn
+	n := stackGrowthHook.ring.get(&evs)
+	unlock(&stackGrowthHook.h.lock)
+	if fn == nil {
+		return
+	}
+	for _, ev := range evs[:n] {
+		fn(ev.a, uintptr(ev.b), uintptr(ev.c))
+	}
+}
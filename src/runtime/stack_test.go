@@ -7,8 +7,10 @@ package runtime_test
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	. "runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -231,6 +233,64 @@ func growStackWithCallback(cb func()) {
 	}
 }
 
+// lockedMemoryKB returns the amount of memory the process has locked,
+// as reported by Linux.
+func lockedMemoryKB(t *testing.T) int {
+	b, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[0] == "VmLck:" {
+			n, err := strconv.Atoi(f[1])
+			if err != nil {
+				t.Fatalf("parsing %q: %v", line, err)
+			}
+			return n
+		}
+	}
+	t.Skip("no VmLck in /proc/self/status")
+	return 0
+}
+
+func TestLockCurrentStack(t *testing.T) {
+	if GOOS != "linux" {
+		if err := LockCurrentStack(); err == nil {
+			t.Errorf("LockCurrentStack succeeded on %s", GOOS)
+		}
+		return
+	}
+	before := lockedMemoryKB(t)
+	errc := make(chan error)
+	grown := make(chan bool)
+	exit := make(chan bool)
+	go func() {
+		err := LockCurrentStack()
+		errc <- err
+		if err != nil {
+			return
+		}
+		useStackKB(64)
+		grown <- true
+		<-exit
+	}()
+	if err := <-errc; err != nil {
+		t.Skip(err)
+	}
+	<-grown
+	if n := lockedMemoryKB(t); n < before+64 {
+		t.Errorf("%d kB locked after growing a locked stack past 64 kB, want at least %d", n, before+64)
+	}
+	close(exit)
+	// Wait for the goroutine to exit and unlock its stack.
+	for i := 0; lockedMemoryKB(t) > before; i++ {
+		if i == 100 {
+			t.Fatalf("%d kB still locked after goroutine exit, want %d", lockedMemoryKB(t), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // TestDeferPtrs tests the adjustment of Defer's argument pointers (p aka &y)
 // during a stack copy.
 func set(p *int, x int) {
@@ -807,3 +867,79 @@ func TestTracebackSystemstack(t *testing.T) {
 		t.Fatalf("expected 5 calls to TracebackSystemstack and 1 call to TestTracebackSystemstack, got:%s", tb.String())
 	}
 }
+
+func TestGrowStack(t *testing.T) {
+	const size = 256 << 10
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		x := 42
+		p := &x
+		before, _ := GoroutineStackSize(Goid())
+		if before >= size {
+			t.Errorf("new goroutine already has a %d-byte stack", before)
+			return
+		}
+		GrowStack(size)
+		after, _ := GoroutineStackSize(Goid())
+		if after < size {
+			t.Errorf("stack is %d bytes after GrowStack(%d)", after, size)
+		}
+		if p != &x || *p != 42 {
+			t.Errorf("pointer to stack variable not adjusted")
+		}
+		// Using most of it needs no more growth.
+		useStackKB(size / 1024 / 2)
+		if again, _ := GoroutineStackSize(Goid()); again != after {
+			t.Errorf("stack went from %d to %d bytes using half of it", after, again)
+		}
+		// Asking for less does nothing.
+		GrowStack(1)
+		if again, _ := GoroutineStackSize(Goid()); again != after {
+			t.Errorf("GrowStack(1) changed stack size from %d to %d", after, again)
+		}
+	}()
+	<-done
+}
+
+func TestStackGrowthHook(t *testing.T) {
+	type growth struct {
+		goid     int64
+		old, new uintptr
+	}
+	grew := make(chan growth, 100)
+	SetStackGrowthHook(func(goid int64, oldSize, newSize uintptr) {
+		select {
+		case grew <- growth{goid, oldSize, newSize}:
+		default:
+		}
+	})
+	defer SetStackGrowthHook(nil)
+
+	// A new goroutine using 64KB of stack grows from 2KB or so to at
+	// least 64KB, doubling each time.
+	goids := make(chan int64)
+	go func() {
+		useStack(64)
+		goids <- Goid()
+	}()
+	goid := <-goids
+	var largest uintptr
+	timeout := time.After(10 * time.Second)
+	for largest < 64<<10 {
+		select {
+		case g := <-grew:
+			if g.goid != goid {
+				continue
+			}
+			if g.new != 2*g.old {
+				t.Errorf("stack grew from %d to %d bytes, want doubling", g.old, g.new)
+			}
+			if g.new > largest {
+				largest = g.new
+			}
+		case <-timeout:
+			t.Fatalf("largest reported stack growth was to %d bytes, want at least %d", largest, 64<<10)
+		}
+	}
+}
@@ -390,6 +390,53 @@ func atoi32(s string) (int32, bool) {
 	return 0, false
 }
 
+// atoi64 is like atoi but for integers that fit into an int64, so
+// that it can parse durations in nanoseconds on 32-bit systems too.
+func atoi64(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	neg := false
+	if s[0] == '-' {
+		neg = true
+		s = s[1:]
+	}
+
+	un := uint64(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		if un > ^uint64(0)/10 {
+			// overflow
+			return 0, false
+		}
+		un *= 10
+		un1 := un + uint64(c) - '0'
+		if un1 < un {
+			// overflow
+			return 0, false
+		}
+		un = un1
+	}
+
+	if !neg && un > 1<<63-1 {
+		return 0, false
+	}
+	if neg && un > 1<<63 {
+		return 0, false
+	}
+
+	n := int64(un)
+	if neg {
+		n = -n
+	}
+
+	return n, true
+}
+
 //go:nosplit
 func findnull(s *byte) int {
 	if s == nil {
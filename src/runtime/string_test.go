@@ -388,3 +388,14 @@ func TestAtoi32(t *testing.T) {
 		}
 	}
 }
+
+func TestAtoi64(t *testing.T) {
+	for i := range atoi64tests {
+		test := &atoi64tests[i]
+		out, ok := runtime.Atoi64(test.in)
+		if test.out != out || test.ok != ok {
+			t.Errorf("atoi64(%q) = (%v, %v) want (%v, %v)",
+				test.in, out, ok, test.out, test.ok)
+		}
+	}
+}
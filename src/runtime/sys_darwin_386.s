@@ -115,7 +115,7 @@ ok:
 TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVL	$75, AX
 	INT	$0x80
-	// ignore failure - maybe pages are locked
+	MOVL	AX, ret+12(FP)
 	RET
 
 TEXT runtime·munmap(SB),NOSPLIT,$0
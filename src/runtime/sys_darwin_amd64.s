@@ -116,7 +116,7 @@ TEXT runtime·madvise(SB), NOSPLIT, $0
 	MOVL	flags+16(FP), DX		// arg 3 advice
 	MOVL	$(0x2000000+75), AX	// syscall entry madvise
 	SYSCALL
-	// ignore failure - maybe pages are locked
+	MOVL	AX, ret+24(FP)
 	RET
 
 // OS X comm page time offsets
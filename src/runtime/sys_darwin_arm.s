@@ -165,6 +165,7 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVW	$SYS_madvise, R12
 	SWI	$0x80
 	BL.CS	notok<>(SB)
+	MOVW	R0, ret+12(FP)
 	RET
 
 TEXT runtime·setitimer(SB),NOSPLIT,$0
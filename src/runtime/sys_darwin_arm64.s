@@ -161,6 +161,7 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	SVC	$0x80
 	BCC	2(PC)
 	BL	notok<>(SB)
+	MOVW	R0, ret+24(FP)
 	RET
 
 TEXT runtime·setitimer(SB),NOSPLIT,$0
@@ -280,7 +280,7 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVW flags+8(FP), R2		// arg 3 flags
 	MOVW $SYS_madvise, R7
 	SWI $0
-	// ignore failure - maybe pages are locked
+	MOVW R0, ret+12(FP)
 	RET
 	
 TEXT runtime·sigaltstack(SB),NOSPLIT,$-8
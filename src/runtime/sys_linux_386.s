@@ -47,10 +47,13 @@
 #define SYS_mmap2		192
 #define SYS_mincore		218
 #define SYS_madvise		219
+#define SYS_mlock		150
+#define SYS_munlock		151
 #define SYS_gettid		224
 #define SYS_tkill		238
 #define SYS_futex		240
 #define SYS_sched_getaffinity	242
+#define SYS_getcpu		318
 #define SYS_set_thread_area	243
 #define SYS_exit_group		252
 #define SYS_epoll_create	254
@@ -425,7 +428,23 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVL	n+4(FP), CX
 	MOVL	flags+8(FP), DX
 	INVOKE_SYSCALL
-	// ignore failure - maybe pages are locked
+	MOVL	AX, ret+12(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT,$0
+	MOVL	$SYS_mlock, AX
+	MOVL	addr+0(FP), BX
+	MOVL	n+4(FP), CX
+	INVOKE_SYSCALL
+	MOVL	AX, ret+8(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT,$0
+	MOVL	$SYS_munlock, AX
+	MOVL	addr+0(FP), BX
+	MOVL	n+4(FP), CX
+	INVOKE_SYSCALL
+	MOVL	AX, ret+8(FP)
 	RET
 
 // int32 futex(int32 *uaddr, int32 op, int32 val,
@@ -649,6 +668,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$0
 	MOVL	AX, ret+12(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT,$0
+	MOVL	$SYS_getcpu, AX
+	MOVL	cpu+0(FP), BX
+	MOVL	node+4(FP), CX
+	MOVL	cache+8(FP), DX
+	INVOKE_SYSCALL
+	MOVL	AX, ret+12(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$0
 	MOVL    $SYS_epoll_create, AX
@@ -24,6 +24,8 @@
 #define SYS_sched_yield 	24
 #define SYS_mincore		27
 #define SYS_madvise		28
+#define SYS_mlock		149
+#define SYS_munlock		150
 #define SYS_setittimer		38
 #define SYS_getpid		39
 #define SYS_socket		41
@@ -39,6 +41,7 @@
 #define SYS_tkill		200
 #define SYS_futex		202
 #define SYS_sched_getaffinity	204
+#define SYS_getcpu		309
 #define SYS_epoll_create	213
 #define SYS_exit_group		231
 #define SYS_epoll_ctl		233
@@ -514,7 +517,23 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVL	flags+16(FP), DX
 	MOVQ	$SYS_madvise, AX
 	SYSCALL
-	// ignore failure - maybe pages are locked
+	MOVL	AX, ret+24(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT,$0
+	MOVQ	addr+0(FP), DI
+	MOVQ	n+8(FP), SI
+	MOVQ	$SYS_mlock, AX
+	SYSCALL
+	MOVL	AX, ret+16(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT,$0
+	MOVQ	addr+0(FP), DI
+	MOVQ	n+8(FP), SI
+	MOVQ	$SYS_munlock, AX
+	SYSCALL
+	MOVL	AX, ret+16(FP)
 	RET
 
 // int64 futex(int32 *uaddr, int32 op, int32 val,
@@ -630,6 +649,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$0
 	MOVL	AX, ret+24(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT,$0
+	MOVQ	cpu+0(FP), DI
+	MOVQ	node+8(FP), SI
+	MOVQ	cache+16(FP), DX
+	MOVL	$SYS_getcpu, AX
+	SYSCALL
+	MOVL	AX, ret+24(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$0
 	MOVL    size+0(FP), DI
@@ -31,6 +31,8 @@
 #define SYS_exit_group (SYS_BASE + 248)
 #define SYS_munmap (SYS_BASE + 91)
 #define SYS_madvise (SYS_BASE + 220)
+#define SYS_mlock (SYS_BASE + 150)
+#define SYS_munlock (SYS_BASE + 151)
 #define SYS_setitimer (SYS_BASE + 104)
 #define SYS_mincore (SYS_BASE + 219)
 #define SYS_gettid (SYS_BASE + 224)
@@ -39,6 +41,7 @@
 #define SYS_pselect6 (SYS_BASE + 335)
 #define SYS_ugetrlimit (SYS_BASE + 191)
 #define SYS_sched_getaffinity (SYS_BASE + 242)
+#define SYS_getcpu (SYS_BASE + 345)
 #define SYS_clock_gettime (SYS_BASE + 263)
 #define SYS_epoll_create (SYS_BASE + 250)
 #define SYS_epoll_ctl (SYS_BASE + 251)
@@ -198,7 +201,23 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVW	flags+8(FP), R2
 	MOVW	$SYS_madvise, R7
 	SWI	$0
-	// ignore failure - maybe pages are locked
+	MOVW	R0, ret+12(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT,$0
+	MOVW	addr+0(FP), R0
+	MOVW	n+4(FP), R1
+	MOVW	$SYS_mlock, R7
+	SWI	$0
+	MOVW	R0, ret+8(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT,$0
+	MOVW	addr+0(FP), R0
+	MOVW	n+4(FP), R1
+	MOVW	$SYS_munlock, R7
+	SWI	$0
+	MOVW	R0, ret+8(FP)
 	RET
 
 TEXT runtime·setitimer(SB),NOSPLIT,$0
@@ -451,6 +470,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$0
 	MOVW	R0, ret+12(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT,$0
+	MOVW	cpu+0(FP), R0
+	MOVW	node+4(FP), R1
+	MOVW	cache+8(FP), R2
+	MOVW	$SYS_getcpu, R7
+	SWI	$0
+	MOVW	R0, ret+12(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size)
 TEXT runtime·epollcreate(SB),NOSPLIT,$0
 	MOVW	size+0(FP), R0
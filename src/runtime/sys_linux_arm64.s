@@ -31,6 +31,8 @@
 #define SYS_sigaltstack		132
 #define SYS_getrlimit		163
 #define SYS_madvise		233
+#define SYS_mlock		228
+#define SYS_munlock		229
 #define SYS_mincore		232
 #define SYS_getpid		172
 #define SYS_gettid		178
@@ -38,6 +40,7 @@
 #define SYS_tkill		130
 #define SYS_futex		98
 #define SYS_sched_getaffinity	123
+#define SYS_getcpu		168
 #define SYS_exit_group		94
 #define SYS_epoll_create1	20
 #define SYS_epoll_ctl		21
@@ -305,7 +308,23 @@ TEXT runtime·madvise(SB),NOSPLIT,$-8
 	MOVW	flags+16(FP), R2
 	MOVD	$SYS_madvise, R8
 	SVC
-	// ignore failure - maybe pages are locked
+	MOVW	R0, ret+24(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT,$-8
+	MOVD	addr+0(FP), R0
+	MOVD	n+8(FP), R1
+	MOVD	$SYS_mlock, R8
+	SVC
+	MOVW	R0, ret+16(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT,$-8
+	MOVD	addr+0(FP), R0
+	MOVD	n+8(FP), R1
+	MOVD	$SYS_munlock, R8
+	SVC
+	MOVW	R0, ret+16(FP)
 	RET
 
 // int64 futex(int32 *uaddr, int32 op, int32 val,
@@ -417,6 +436,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$-8
 	MOVW	R0, ret+24(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT,$-8
+	MOVD	cpu+0(FP), R0
+	MOVD	node+8(FP), R1
+	MOVD	cache+16(FP), R2
+	MOVD	$SYS_getcpu, R8
+	SVC
+	MOVW	R0, ret+24(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$-8
 	MOVW	$0, R0
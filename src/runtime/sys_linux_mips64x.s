@@ -35,11 +35,14 @@
 #define SYS_sigaltstack		5129
 #define SYS_getrlimit		5095
 #define SYS_madvise		5027
+#define SYS_mlock		5146
+#define SYS_munlock		5147
 #define SYS_mincore		5026
 #define SYS_gettid		5178
 #define SYS_tkill		5192
 #define SYS_futex		5194
 #define SYS_sched_getaffinity	5196
+#define SYS_getcpu		5271
 #define SYS_exit_group		5205
 #define SYS_epoll_create	5207
 #define SYS_epoll_ctl		5208
@@ -297,7 +300,23 @@ TEXT runtime·madvise(SB),NOSPLIT,$-8
 	MOVW	flags+16(FP), R6
 	MOVV	$SYS_madvise, R2
 	SYSCALL
-	// ignore failure - maybe pages are locked
+	MOVW	R2, ret+24(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT,$-8
+	MOVV	addr+0(FP), R4
+	MOVV	n+8(FP), R5
+	MOVV	$SYS_mlock, R2
+	SYSCALL
+	MOVW	R2, ret+16(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT,$-8
+	MOVV	addr+0(FP), R4
+	MOVV	n+8(FP), R5
+	MOVV	$SYS_munlock, R2
+	SYSCALL
+	MOVW	R2, ret+16(FP)
 	RET
 
 // int64 futex(int32 *uaddr, int32 op, int32 val,
@@ -398,6 +417,17 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$-8
 	MOVW	R2, ret+24(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT,$-8
+	MOVV	cpu+0(FP), R4
+	MOVV	node+8(FP), R5
+	MOVV	cache+16(FP), R6
+	MOVV	$SYS_getcpu, R2
+	SYSCALL
+	BEQ	R7, 2(PC)
+	MOVW	$-1, R2
+	MOVW	R2, ret+24(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$-8
 	MOVW    size+0(FP), R4
@@ -34,11 +34,14 @@
 #define SYS_sigaltstack		    4206
 #define SYS_getrlimit		    4076
 #define SYS_madvise		        4218
+#define SYS_mlock		        4154
+#define SYS_munlock		        4155
 #define SYS_mincore		        4217
 #define SYS_gettid		        4222
 #define SYS_tkill		        4236
 #define SYS_futex		        4238
 #define SYS_sched_getaffinity	4240
+#define SYS_getcpu		    4312
 #define SYS_exit_group		    4246
 #define SYS_epoll_create	    4248
 #define SYS_epoll_ctl		    4249
@@ -309,13 +312,29 @@ TEXT runtime·munmap(SB),NOSPLIT,$0-8
 	UNDEF	// crash
 	RET
 
-TEXT runtime·madvise(SB),NOSPLIT,$0-12
+TEXT runtime·madvise(SB),NOSPLIT,$0-16
 	MOVW	addr+0(FP), R4
 	MOVW	n+4(FP), R5
 	MOVW	flags+8(FP), R6
 	MOVW	$SYS_madvise, R2
 	SYSCALL
-	// ignore failure - maybe pages are locked
+	MOVW	R2, ret+12(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT,$0-12
+	MOVW	addr+0(FP), R4
+	MOVW	n+4(FP), R5
+	MOVW	$SYS_mlock, R2
+	SYSCALL
+	MOVW	R2, ret+8(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT,$0-12
+	MOVW	addr+0(FP), R4
+	MOVW	n+4(FP), R5
+	MOVW	$SYS_munlock, R2
+	SYSCALL
+	MOVW	R2, ret+8(FP)
 	RET
 
 // int32 futex(int32 *uaddr, int32 op, int32 val, struct timespec *timeout, int32 *uaddr2, int32 val2);
@@ -433,6 +452,17 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT,$0-16
 	MOVW	R2, ret+12(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT,$0-16
+	MOVW	cpu+0(FP), R4
+	MOVW	node+4(FP), R5
+	MOVW	cache+8(FP), R6
+	MOVW	$SYS_getcpu, R2
+	SYSCALL
+	BEQ	R7, 2(PC)
+	MOVW	$-1, R2
+	MOVW	R2, ret+12(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT,$0-8
 	MOVW	size+0(FP), R4
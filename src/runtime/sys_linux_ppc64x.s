@@ -37,11 +37,14 @@
 #define SYS_sigaltstack		185
 #define SYS_ugetrlimit		190
 #define SYS_madvise		205
+#define SYS_mlock		150
+#define SYS_munlock		151
 #define SYS_mincore		206
 #define SYS_gettid		207
 #define SYS_tkill		208
 #define SYS_futex		221
 #define SYS_sched_getaffinity	223
+#define SYS_getcpu		302
 #define SYS_exit_group		234
 #define SYS_epoll_create	236
 #define SYS_epoll_ctl		237
@@ -387,7 +390,21 @@ TEXT runtime·madvise(SB),NOSPLIT|NOFRAME,$0
 	MOVD	n+8(FP), R4
 	MOVW	flags+16(FP), R5
 	SYSCALL	$SYS_madvise
-	// ignore failure - maybe pages are locked
+	MOVW	R3, ret+24(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT|NOFRAME,$0
+	MOVD	addr+0(FP), R3
+	MOVD	n+8(FP), R4
+	SYSCALL	$SYS_mlock
+	MOVW	R3, ret+16(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT|NOFRAME,$0
+	MOVD	addr+0(FP), R3
+	MOVD	n+8(FP), R4
+	SYSCALL	$SYS_munlock
+	MOVW	R3, ret+16(FP)
 	RET
 
 // int64 futex(int32 *uaddr, int32 op, int32 val,
@@ -487,6 +504,16 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT|NOFRAME,$0
 	MOVW	R3, ret+24(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT|NOFRAME,$0
+	MOVD	cpu+0(FP), R3
+	MOVD	node+8(FP), R4
+	MOVD	cache+16(FP), R5
+	SYSCALL	$SYS_getcpu
+	BVC	2(PC)
+	MOVW	$-1, R3
+	MOVW	R3, ret+24(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT|NOFRAME,$0
 	MOVW    size+0(FP), R3
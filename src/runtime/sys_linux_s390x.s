@@ -31,11 +31,14 @@
 #define SYS_sigaltstack         186
 #define SYS_ugetrlimit          191
 #define SYS_madvise             219
+#define SYS_mlock               150
+#define SYS_munlock             151
 #define SYS_mincore             218
 #define SYS_gettid              236
 #define SYS_tkill               237
 #define SYS_futex               238
 #define SYS_sched_getaffinity   240
+#define SYS_getcpu              311
 #define SYS_exit_group          248
 #define SYS_epoll_create        249
 #define SYS_epoll_ctl           250
@@ -298,7 +301,23 @@ TEXT runtime·madvise(SB),NOSPLIT|NOFRAME,$0
 	MOVW	flags+16(FP), R4
 	MOVW	$SYS_madvise, R1
 	SYSCALL
-	// ignore failure - maybe pages are locked
+	MOVW	R2, ret+24(FP)
+	RET
+
+TEXT runtime·mlock(SB),NOSPLIT|NOFRAME,$0
+	MOVD	addr+0(FP), R2
+	MOVD	n+8(FP), R3
+	MOVW	$SYS_mlock, R1
+	SYSCALL
+	MOVW	R2, ret+16(FP)
+	RET
+
+TEXT runtime·munlock(SB),NOSPLIT|NOFRAME,$0
+	MOVD	addr+0(FP), R2
+	MOVD	n+8(FP), R3
+	MOVW	$SYS_munlock, R1
+	SYSCALL
+	MOVW	R2, ret+16(FP)
 	RET
 
 // int64 futex(int32 *uaddr, int32 op, int32 val,
@@ -399,6 +418,15 @@ TEXT runtime·sched_getaffinity(SB),NOSPLIT|NOFRAME,$0
 	MOVW	R2, ret+24(FP)
 	RET
 
+TEXT runtime·getcpu(SB),NOSPLIT|NOFRAME,$0
+	MOVD	cpu+0(FP), R2
+	MOVD	node+8(FP), R3
+	MOVD	cache+16(FP), R4
+	MOVW	$SYS_getcpu, R1
+	SYSCALL
+	MOVW	R2, ret+24(FP)
+	RET
+
 // int32 runtime·epollcreate(int32 size);
 TEXT runtime·epollcreate(SB),NOSPLIT|NOFRAME,$0
 	MOVW    size+0(FP), R2
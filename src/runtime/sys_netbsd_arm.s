@@ -288,7 +288,7 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	MOVW n+4(FP), R1	// arg 2 - len
 	MOVW flags+8(FP), R2	// arg 3 - behav
 	SWI $0xa0004b	// sys_madvise
-	// ignore failure - maybe pages are locked
+	MOVW R0, ret+12(FP)
 	RET
 
 TEXT runtime·sigaltstack(SB),NOSPLIT,$-4
@@ -153,6 +153,7 @@ TEXT runtime·madvise(SB),NOSPLIT,$0
 	SWI	$0
 	MOVW.CS	$0, R8			// crash on syscall failure
 	MOVW.CS	R8, (R8)
+	MOVW	R0, ret+12(FP)
 	RET
 
 TEXT runtime·setitimer(SB),NOSPLIT,$0
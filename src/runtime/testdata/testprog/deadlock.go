@@ -20,6 +20,7 @@ func init() {
 	register("LockedDeadlock2", LockedDeadlock2)
 	register("GoexitDeadlock", GoexitDeadlock)
 	register("StackOverflow", StackOverflow)
+	register("StackOverflowDefaultLimit", StackOverflowDefaultLimit)
 	register("ThreadExhaustion", ThreadExhaustion)
 	register("RecursivePanic", RecursivePanic)
 	register("GoexitExit", GoexitExit)
@@ -80,6 +81,17 @@ func StackOverflow() {
 	f()
 }
 
+// StackOverflowDefaultLimit overflows the stack without calling
+// SetMaxStack, so the limit comes from the runtime (and GODEBUG).
+func StackOverflowDefaultLimit() {
+	var f func() byte
+	f = func() byte {
+		var buf [64 << 10]byte
+		return buf[0] + f()
+	}
+	f()
+}
+
 func ThreadExhaustion() {
 	debug.SetMaxThreads(10)
 	c := make(chan int)
@@ -9,6 +9,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -17,6 +18,7 @@ func init() {
 	register("GCFairness", GCFairness)
 	register("GCFairness2", GCFairness2)
 	register("GCSys", GCSys)
+	register("GCLabelLimit", GCLabelLimit)
 }
 
 func GCSys() {
@@ -121,3 +123,32 @@ func GCFairness2() {
 	}
 	fmt.Println("OK")
 }
+
+var gcLabelSink []byte
+
+// GCLabelLimit starts the first GC from a goroutine whose label group
+// is limited to one running goroutine. The mark workers must not be
+// held back by the limit.
+func GCLabelLimit() {
+	runtime.SetLabelConcurrencyLimit("t", "a", 1)
+	done := make(chan bool)
+	go func() {
+		runtime.SetCurrentGoroutineLabels(map[string]string{"t": "a"})
+		runtime.GC()
+		done <- true
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			for j := 0; j < 10000; j++ {
+				gcLabelSink = make([]byte, 1024)
+			}
+			wg.Done()
+		}()
+	}
+	<-done
+	wg.Wait()
+	runtime.GC()
+	fmt.Println("OK")
+}
@@ -5,8 +5,13 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +29,9 @@ func init() {
 		runtime.LockOSThread()
 	})
 	register("LockOSThreadAlt", LockOSThreadAlt)
+
+	register("ThreadLimitCallback", ThreadLimitCallback)
+	register("ThreadInitMPool", ThreadInitMPool)
 }
 
 func LockOSThreadMain() {
@@ -92,3 +100,91 @@ func LockOSThreadAlt() {
 ok:
 	println("OK")
 }
+
+func ThreadLimitCallback() {
+	var over int32
+	runtime.SetThreadLimitCallback(func(current int32) bool {
+		atomic.StoreInt32(&over, current)
+		return true
+	})
+	n, _ := runtime.ThreadCreateProfile(nil)
+	debug.SetMaxThreads(n + 4)
+
+	// Each goroutine holds on to its own thread, forcing the
+	// runtime to start new ones past the limit.
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			runtime.LockOSThread()
+			wg.Done()
+			<-stop
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	if atomic.LoadInt32(&over) == 0 {
+		println("callback not called")
+		return
+	}
+	println("OK")
+}
+
+// ThreadInitMPool checks that the Ms kept idle for
+// GODEBUG=syscallmpool=8, set by the caller, run the thread init
+// function before any goroutine.
+func ThreadInitMPool() {
+	old := make(map[string]bool)
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		println("reading threads:", err.Error())
+		return
+	}
+	for _, t := range tasks {
+		old[t.Name()] = true
+	}
+
+	var mu sync.Mutex
+	inited := make(map[int]bool)
+	runtime.SetThreadInitFn(func() {
+		mu.Lock()
+		inited[gettid()] = true
+		mu.Unlock()
+	})
+
+	// The first goroutines use up the Ms already in the pool; the
+	// second ones take the Ms that refill it, which start after the
+	// init function was set.
+	stop := make(chan bool)
+	defer close(stop)
+	for round := 0; round < 2; round++ {
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumIdleM() < 8 {
+			if time.Now().After(deadline) {
+				println("idle Ms:", runtime.NumIdleM())
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		tids := make(chan int)
+		for i := 0; i < 8; i++ {
+			go func() {
+				runtime.LockOSThread()
+				tids <- gettid()
+				<-stop
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			tid := <-tids
+			mu.Lock()
+			ok := inited[tid]
+			mu.Unlock()
+			if round == 1 && !ok && !old[strconv.Itoa(tid)] {
+				println("thread", tid, "ran a goroutine without the init function")
+				return
+			}
+		}
+	}
+	println("OK")
+}
@@ -4,12 +4,315 @@
 
 package main
 
-import "runtime"
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 func init() {
 	register("NumGoroutine", NumGoroutine)
+	register("NoSteal", NoSteal)
+	register("StealNext", StealNext)
+	register("STWLog", STWLog)
+	register("SudogChurn", SudogChurn)
+	register("SyscallMPool", SyscallMPool)
+	register("FinalPanicHook", FinalPanicHook)
+	register("ScavengeLimit", ScavengeLimit)
+	register("SchedRandom", SchedRandom)
+	register("GoidCacheBatch", GoidCacheBatch)
+	register("SchedLatency", SchedLatency)
+	register("NetpollOff", NetpollOff)
 }
 
 func NumGoroutine() {
 	println(runtime.NumGoroutine())
 }
+
+// NoSteal runs goroutines and a GC with GODEBUG=nosteal=1, or another
+// setting that limits work stealing, set by the caller, checking that
+// the scheduler still makes progress.
+func NoSteal() {
+	runtime.GOMAXPROCS(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := make([]byte, 0)
+			for j := 0; j < 1000; j++ {
+				s = append(s, byte(j))
+			}
+			runtime.Gosched()
+		}()
+	}
+	runtime.GC()
+	wg.Wait()
+	println("OK")
+}
+
+// StealNext readies a goroutine into the runnext slot of a P that then
+// stays busy for 5ms, with GODEBUG=stealnextbackoffus=N set by the
+// caller, and prints "stolen" if an idle P took the goroutine before
+// the busy P was done in any of a few trials, or "kept" otherwise.
+func StealNext() {
+	runtime.GOMAXPROCS(2)
+	// The busy goroutine cannot be preempted, so a GC would deadlock.
+	debug.SetGCPercent(-1)
+	stolen := false
+	for i := 0; i < 3; i++ {
+		var busy uint32
+		wake := make(chan bool)
+		ranEarly := make(chan bool, 1)
+		go func() {
+			<-wake
+			ranEarly <- atomic.LoadUint32(&busy) == 1
+		}()
+		time.Sleep(time.Millisecond) // let it block
+		done := make(chan bool)
+		go func() {
+			atomic.StoreUint32(&busy, 1)
+			wake <- true // the receiver goes to this P's runnext
+			start := time.Now()
+			for time.Since(start) < 5*time.Millisecond {
+			}
+			atomic.StoreUint32(&busy, 0)
+			done <- true
+		}()
+		<-done
+		if <-ranEarly {
+			stolen = true
+		}
+		// Let an idle P still backing off give up on this
+		// goroutine before its g can be reused.
+		time.Sleep(50 * time.Millisecond)
+	}
+	if stolen {
+		println("stolen")
+	} else {
+		println("kept")
+	}
+}
+
+// STWLog stops the world once with GODEBUG=stwlog=1 set by the caller.
+func STWLog() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	println("OK")
+}
+
+// SudogChurn blocks many goroutines on channels and in selects, with
+// the sudog cache size set by the caller with GODEBUG=sudogcache=N,
+// checking that sudogs still flow between the per-P and central caches.
+func SudogChurn() {
+	runtime.GOMAXPROCS(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c1, c2 := make(chan int), make(chan int)
+			go func() {
+				for j := 0; j < 200; j++ {
+					c1 <- j
+					<-c2
+				}
+				close(c1)
+			}()
+			for range c1 {
+				select {
+				case c2 <- 0:
+				case <-c1:
+					panic("unexpected receive")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	runtime.GC()
+	println("OK")
+}
+
+// SyscallMPool waits for the pool of idle Ms kept by
+// GODEBUG=syscallmpool=8, set by the caller, to fill up.
+func SyscallMPool() {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumIdleM() < 8 {
+		if time.Now().After(deadline) {
+			println("idle Ms:", runtime.NumIdleM())
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	println("OK")
+}
+
+// FinalPanicHook returns from main with a hook installed by
+// SetFinalPanicHook.
+func FinalPanicHook() {
+	runtime.SetFinalPanicHook(func() {
+		println("hook")
+	})
+	println("main done")
+}
+
+var scavengeSink [][]byte
+
+// ScavengeLimit frees 64MB of heap and waits for sysmon to return it
+// to the operating system, which takes well under the default five
+// minutes with GODEBUG=scavengelimitns=X, set by the caller.
+func ScavengeLimit() {
+	for i := 0; i < 64; i++ {
+		scavengeSink = append(scavengeSink, make([]byte, 1<<20))
+	}
+	scavengeSink = nil
+	runtime.GC()
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.ReadMemStats(&after)
+		if after.HeapReleased >= before.HeapReleased+32<<20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			println("HeapReleased went from", before.HeapReleased, "to", after.HeapReleased)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	println("OK")
+}
+
+// SchedRandom starts pairs of goroutines on one P and records which
+// one runs first. Normally that is always the second, which the new
+// goroutine takes the next-to-run slot from; with GODEBUG=schedrandom=1,
+// set by the caller, both orders should show up.
+func SchedRandom() {
+	runtime.GOMAXPROCS(1)
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		c := make(chan int, 2)
+		go func() { c <- 1 }()
+		go func() { c <- 2 }()
+		seen[<-c] = true
+		<-c
+	}
+	if len(seen) != 2 {
+		println("goroutines always ran in the same order")
+		return
+	}
+	println("OK")
+}
+
+// GoidCacheBatch holds four Ps at once with goroutines that spin until
+// all of them are running, then has each start a goroutine and report
+// its id. With GODEBUG=goidcachebatch=1000, set by the caller, at least
+// three of the four come from a P other than the one main started on,
+// which has to reserve ids above the first thousand.
+func GoidCacheBatch() {
+	runtime.GOMAXPROCS(4)
+	var started int32
+	var max int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			atomic.AddInt32(&started, 1)
+			for atomic.LoadInt32(&started) < 4 {
+			}
+			go func() {
+				defer wg.Done()
+				id := curGoid()
+				for {
+					old := atomic.LoadInt64(&max)
+					if id <= old || atomic.CompareAndSwapInt64(&max, old, id) {
+						break
+					}
+				}
+			}()
+		}()
+	}
+	wg.Wait()
+	if max <= 1000 {
+		println("largest goroutine id", max, "is within the first batch")
+		return
+	}
+	println("OK")
+}
+
+// curGoid returns the id of the calling goroutine, parsed from the
+// header of its stack trace.
+func curGoid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	var id int64
+	for _, c := range b[len("goroutine "):] {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + int64(c-'0')
+	}
+	return id
+}
+
+// SchedLatency passes a value back and forth between two goroutines
+// with GODEBUG=schedlatency=1 set by the caller, checking that each
+// wakeup is recorded in SchedLatencyBuckets.
+func SchedLatency() {
+	const N = 1000
+	before := runtime.SchedLatencyBuckets()
+	c := make(chan int)
+	go func() {
+		for v := range c {
+			c <- v
+		}
+	}()
+	for i := 0; i < N; i++ {
+		c <- i
+		<-c
+	}
+	close(c)
+	after := runtime.SchedLatencyBuckets()
+	var n uint64
+	for i := range after {
+		n += after[i] - before[i]
+	}
+	if n < N {
+		println("recorded", n, "scheduling latencies, want at least", N)
+		return
+	}
+	println("OK")
+}
+
+// NetpollOff blocks a goroutine reading from a pipe, which waits for
+// the network poller, and then writes to the pipe. With
+// GODEBUG=netpolloff=1, set by the caller, the reader must not wake.
+func NetpollOff() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		println("pipe:", err.Error())
+		return
+	}
+	var woke uint32
+	go func() {
+		var b [1]byte
+		r.Read(b[:])
+		atomic.StoreUint32(&woke, 1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := w.Write([]byte{1}); err != nil {
+		println("write:", err.Error())
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadUint32(&woke) != 0 {
+		println("reader woke with netpolloff=1")
+		return
+	}
+	println("OK")
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9,!windows
+
+package main
+
+/*
+#include <stddef.h>
+#include <pthread.h>
+
+static pthread_mutex_t cgoCallsMu = PTHREAD_MUTEX_INITIALIZER;
+static pthread_cond_t cgoCallsCond = PTHREAD_COND_INITIALIZER;
+static int cgoCallsReleased;
+
+static void cgoCallsWait() {
+	pthread_mutex_lock(&cgoCallsMu);
+	while (!cgoCallsReleased) {
+		pthread_cond_wait(&cgoCallsCond, &cgoCallsMu);
+	}
+	pthread_mutex_unlock(&cgoCallsMu);
+}
+
+static void cgoCallsRelease() {
+	pthread_mutex_lock(&cgoCallsMu);
+	cgoCallsReleased = 1;
+	pthread_cond_broadcast(&cgoCallsCond);
+	pthread_mutex_unlock(&cgoCallsMu);
+}
+
+extern void CallbackCgoCallsInFlight();
+
+static void* cgoCallsThread(void* arg __attribute__ ((unused))) {
+	CallbackCgoCallsInFlight();
+	return NULL;
+}
+
+static void cgoCallsCallback() {
+	pthread_t tid;
+	pthread_create(&tid, NULL, cgoCallsThread, NULL);
+	pthread_join(tid, NULL);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+func init() {
+	register("CgoCallsInFlight", CgoCallsInFlight)
+}
+
+func CgoCallsInFlight() {
+	if n := runtime.CgoCallsInFlight(); n != 0 {
+		fmt.Printf("CgoCallsInFlight at start = %d, want 0\n", n)
+		return
+	}
+
+	// The calling goroutine is in C while the callback runs on a
+	// thread created by C, which is not.
+	if C.cgoCallsCallback(); callbackCgoCalls != 1 {
+		fmt.Printf("CgoCallsInFlight in callback = %d, want 1\n", callbackCgoCalls)
+		return
+	}
+	if n := runtime.CgoCallsInFlight(); n != 0 {
+		fmt.Printf("CgoCallsInFlight after callback = %d, want 0\n", n)
+		return
+	}
+
+	const calls = 4
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			C.cgoCallsWait()
+			wg.Done()
+		}()
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.CgoCallsInFlight() != calls {
+		if time.Now().After(deadline) {
+			fmt.Printf("CgoCallsInFlight = %d, want %d\n", runtime.CgoCallsInFlight(), calls)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	C.cgoCallsRelease()
+	wg.Wait()
+	if n := runtime.CgoCallsInFlight(); n != 0 {
+		fmt.Printf("CgoCallsInFlight after release = %d, want 0\n", n)
+		return
+	}
+	fmt.Println("OK")
+}
+
+var callbackCgoCalls int32
+
+//export CallbackCgoCallsInFlight
+func CallbackCgoCallsInFlight() {
+	callbackCgoCalls = runtime.CgoCallsInFlight()
+}
@@ -64,7 +64,9 @@ const (
 	traceEvGoBlockGC         = 42 // goroutine blocks on GC assist [timestamp, stack]
 	traceEvGCMarkAssistStart = 43 // GC mark assist start [timestamp, stack]
 	traceEvGCMarkAssistDone  = 44 // GC mark assist done [timestamp]
-	traceEvCount             = 45
+	traceEvGoRunqSpill       = 45 // local run queue spilled to global queue [timestamp, P id, number of goroutines moved]
+	traceEvGoRunnextSteal    = 46 // goroutine in another P's runnext slot stolen [timestamp, victim P id, thief P id, goroutine id]
+	traceEvCount             = 47
 )
 
 const (
@@ -333,11 +335,13 @@ func StopTrace() {
 	if trace.reading != 0 || trace.reader != 0 {
 		throw("trace: reading after shutdown")
 	}
+	freed := sysFreeBatcher{stat: &memstats.other_sys}
 	for trace.empty != 0 {
 		buf := trace.empty
 		trace.empty = buf.ptr().link
-		sysFree(unsafe.Pointer(buf), unsafe.Sizeof(*buf.ptr()), &memstats.other_sys)
+		freed.free(unsafe.Pointer(buf), unsafe.Sizeof(*buf.ptr()))
 	}
+	freed.flush()
 	trace.strings = nil
 	trace.shutdown = false
 	unlock(&trace.lock)
@@ -901,11 +905,13 @@ func (a *traceAlloc) alloc(n uintptr) unsafe.Pointer {
 
 // drop frees all previously allocated memory and resets the allocator.
 func (a *traceAlloc) drop() {
+	freed := sysFreeBatcher{stat: &memstats.other_sys}
 	for a.head != 0 {
 		block := a.head.ptr()
 		a.head.set(block.next.ptr())
-		sysFree(unsafe.Pointer(block), unsafe.Sizeof(traceAllocBlock{}), &memstats.other_sys)
+		freed.free(unsafe.Pointer(block), unsafe.Sizeof(traceAllocBlock{}))
 	}
+	freed.flush()
 }
 
 // The following functions write specific events to trace.
@@ -994,6 +1000,14 @@ func traceGCMarkAssistDone() {
 	traceEvent(traceEvGCMarkAssistDone, -1)
 }
 
+func traceGoRunqSpill(pp *p, n uint32) {
+	traceEvent(traceEvGoRunqSpill, -1, uint64(pp.id), uint64(n))
+}
+
+func traceGoRunnextSteal(victim *p, gp *g) {
+	traceEvent(traceEvGoRunnextSteal, -1, uint64(victim.id), uint64(getg().m.p.ptr().id), uint64(gp.goid))
+}
+
 func traceGoCreate(newg *g, pc uintptr) {
 	newg.traceseq = 0
 	newg.tracelastp = getg().m.p
@@ -17,6 +17,7 @@ import (
 	. "runtime/trace"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -536,6 +537,82 @@ func TestTraceFutileWakeup(t *testing.T) {
 	}
 }
 
+func TestTraceRunqSpill(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	buf := new(bytes.Buffer)
+	if err := Start(buf); err != nil {
+		t.Fatalf("failed to start tracing: %v", err)
+	}
+
+	// With a single P, creating more goroutines than fit in its local
+	// run queue without yielding forces a spill to the global queue.
+	var wg sync.WaitGroup
+	wg.Add(1000)
+	for i := 0; i < 1000; i++ {
+		go wg.Done()
+	}
+	wg.Wait()
+
+	Stop()
+	saveTrace(t, buf, "TestTraceRunqSpill")
+	events, _ := parseTrace(t, buf)
+	found := false
+	for _, ev := range events {
+		if ev.Type != trace.EvGoRunqSpill {
+			continue
+		}
+		found = true
+		if ev.Args[0] != 0 {
+			t.Errorf("spill from P %v, want P 0", ev.Args[0])
+		}
+		if ev.Args[1] == 0 {
+			t.Errorf("spill of 0 goroutines")
+		}
+	}
+	if !found {
+		t.Fatalf("no EvGoRunqSpill event in trace")
+	}
+}
+
+func TestTraceRunnextSteal(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	buf := new(bytes.Buffer)
+	if err := Start(buf); err != nil {
+		t.Fatalf("failed to start tracing: %v", err)
+	}
+
+	// A new goroutine goes into its creator's runnext slot. While
+	// the creator keeps running, the only way for the new goroutine
+	// to run is for the other P to steal it from there.
+	for i := 0; i < 10; i++ {
+		var ran uint32
+		go atomic.StoreUint32(&ran, 1)
+		start := time.Now()
+		for atomic.LoadUint32(&ran) == 0 && time.Since(start) < 100*time.Millisecond {
+		}
+	}
+
+	Stop()
+	saveTrace(t, buf, "TestTraceRunnextSteal")
+	events, _ := parseTrace(t, buf)
+	found := false
+	for _, ev := range events {
+		if ev.Type != trace.EvGoRunnextSteal {
+			continue
+		}
+		found = true
+		if ev.Args[0] == ev.Args[1] || ev.Args[0] > 1 || ev.Args[1] > 1 {
+			t.Errorf("runnext stolen from P %v by P %v, want different Ps among 0 and 1", ev.Args[0], ev.Args[1])
+		}
+		if ev.P != int(ev.Args[1]) {
+			t.Errorf("runnext steal by P %v recorded on P %v", ev.Args[1], ev.P)
+		}
+	}
+	if !found {
+		t.Fatalf("no EvGoRunnextSteal event in trace")
+	}
+}
+
 func saveTrace(t *testing.T, buf *bytes.Buffer, name string) {
 	if !*saveTraces {
 		return
@@ -917,7 +917,8 @@ func isSystemGoroutine(gp *g) bool {
 		f.funcID == funcID_bgsweep ||
 		f.funcID == funcID_forcegchelper ||
 		f.funcID == funcID_timerproc ||
-		f.funcID == funcID_gcBgMarkWorker
+		f.funcID == funcID_gcBgMarkWorker ||
+		f.funcID == funcID_hookHelperMain
 }
 
 // SetCgoTraceback records three C functions to use to gather
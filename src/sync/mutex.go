@@ -108,7 +108,7 @@ func (m *Mutex) Lock() {
 				atomic.CompareAndSwapInt32(&m.state, old, old|mutexWoken) {
 				awoke = true
 			}
-			runtime_doSpin()
+			runtime_doSpin(unsafe.Pointer(m))
 			iter++
 			old = m.state
 			continue
@@ -138,8 +138,14 @@ func (m *Mutex) Lock() {
 		}
 		if atomic.CompareAndSwapInt32(&m.state, old, new) {
 			if old&(mutexLocked|mutexStarving) == 0 {
+				if iter > 0 {
+					runtime_spinResult(unsafe.Pointer(m), true)
+				}
 				break // locked the mutex with CAS
 			}
+			if iter > 0 {
+				runtime_spinResult(unsafe.Pointer(m), false)
+			}
 			// If we were already waiting before, queue at the front of the queue.
 			queueLifo := waitStartTime != 0
 			if waitStartTime == 0 {
@@ -57,7 +57,12 @@ func init() {
 // runtime_canSpin returns true is spinning makes sense at the moment.
 func runtime_canSpin(i int) bool
 
-// runtime_doSpin does active spinning.
-func runtime_doSpin()
+// runtime_doSpin does active spinning for m, for as long as spinning
+// has recently paid off for it.
+func runtime_doSpin(m unsafe.Pointer)
+
+// runtime_spinResult reports whether spinning on m ended with m
+// acquired, rather than with the caller about to park.
+func runtime_spinResult(m unsafe.Pointer, acquired bool)
 
 func runtime_nanotime() int64